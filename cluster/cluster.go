@@ -13,6 +13,14 @@ var (
 )
 
 func Init() {
+	sockOpts := network.SockOpts{
+		Delay:       conf.SockDelay,
+		ReadBuffer:  conf.SockReadBuffer,
+		WriteBuffer: conf.SockWriteBuffer,
+		KeepAlive:   conf.SockKeepAlive,
+		Linger:      conf.SockLinger,
+	}
+
 	if conf.ListenAddr != "" {
 		server = new(network.TCPServer)
 		server.Addr = conf.ListenAddr
@@ -20,6 +28,8 @@ func Init() {
 		server.PendingWriteNum = conf.PendingWriteNum
 		server.LenMsgLen = 4
 		server.MaxMsgLen = math.MaxUint32
+		server.AcceptShards = conf.AcceptShards
+		server.SockOpts = sockOpts
 		server.NewAgent = newAgent
 
 		server.Start()
@@ -33,6 +43,7 @@ func Init() {
 		client.PendingWriteNum = conf.PendingWriteNum
 		client.LenMsgLen = 4
 		client.MaxMsgLen = math.MaxUint32
+		client.SockOpts = sockOpts
 		client.NewAgent = newAgent
 
 		client.Start()
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"unicode/utf8"
+)
+
+const maxHistory = 1000
+
+var errInterrupt = errors.New("interrupt")
+
+// a line editor for terminals, with history and tab completion
+type editor struct {
+	fd       int
+	in       *bufio.Reader
+	out      io.Writer
+	history  []string
+	complete func(line string) []string
+
+	prompt string
+	line   []rune
+	pos    int
+}
+
+func newEditor(in *os.File, out io.Writer) *editor {
+	return &editor{fd: int(in.Fd()), in: bufio.NewReader(in), out: out}
+}
+
+func (e *editor) loadHistory(filename string) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if line != "" {
+			e.history = append(e.history, line)
+		}
+	}
+}
+
+func (e *editor) saveHistory(filename string) error {
+	history := e.history
+	if len(history) > maxHistory {
+		history = history[len(history)-maxHistory:]
+	}
+	return os.WriteFile(filename, []byte(strings.Join(history, "\n")+"\n"), 0600)
+}
+
+func (e *editor) addHistory(line string) {
+	if line == "" || len(e.history) > 0 && e.history[len(e.history)-1] == line {
+		return
+	}
+	e.history = append(e.history, line)
+}
+
+// returns io.EOF on Ctrl-D and errInterrupt on Ctrl-C
+func (e *editor) readLine(prompt string) (string, error) {
+	state, err := makeRaw(e.fd)
+	if err != nil {
+		return "", err
+	}
+	defer restore(e.fd, state)
+
+	e.prompt = prompt
+	e.line = e.line[:0]
+	e.pos = 0
+	hist := len(e.history)
+	saved := ""
+	e.refresh()
+
+	for {
+		r, _, err := e.in.ReadRune()
+		if err != nil {
+			return "", err
+		}
+
+		switch r {
+		case '\r', '\n':
+			fmt.Fprint(e.out, "\r\n")
+			line := string(e.line)
+			e.addHistory(strings.TrimSpace(line))
+			return line, nil
+		case 3: // Ctrl-C
+			fmt.Fprint(e.out, "^C\r\n")
+			return "", errInterrupt
+		case 4: // Ctrl-D
+			if len(e.line) == 0 {
+				fmt.Fprint(e.out, "\r\n")
+				return "", io.EOF
+			}
+			e.delete(e.pos)
+		case 127, 8: // Backspace
+			if e.pos > 0 {
+				e.pos--
+				e.delete(e.pos)
+			}
+		case 1: // Ctrl-A
+			e.pos = 0
+		case 5: // Ctrl-E
+			e.pos = len(e.line)
+		case 2: // Ctrl-B
+			if e.pos > 0 {
+				e.pos--
+			}
+		case 6: // Ctrl-F
+			if e.pos < len(e.line) {
+				e.pos++
+			}
+		case 11: // Ctrl-K
+			e.line = e.line[:e.pos]
+		case 21: // Ctrl-U
+			e.line = append(e.line[:0], e.line[e.pos:]...)
+			e.pos = 0
+		case 23: // Ctrl-W
+			i := e.pos
+			for i > 0 && e.line[i-1] == ' ' {
+				i--
+			}
+			for i > 0 && e.line[i-1] != ' ' {
+				i--
+			}
+			e.line = append(e.line[:i], e.line[e.pos:]...)
+			e.pos = i
+		case 12: // Ctrl-L
+			fmt.Fprint(e.out, "\x1b[H\x1b[2J")
+		case 16, 14: // Ctrl-P, Ctrl-N
+			hist, saved = e.browse(r == 16, hist, saved)
+		case '\t':
+			e.tab()
+		case 27: // escape sequences
+			seq := e.readEscape()
+			switch seq {
+			case "[A", "OA":
+				hist, saved = e.browse(true, hist, saved)
+			case "[B", "OB":
+				hist, saved = e.browse(false, hist, saved)
+			case "[C", "OC":
+				if e.pos < len(e.line) {
+					e.pos++
+				}
+			case "[D", "OD":
+				if e.pos > 0 {
+					e.pos--
+				}
+			case "[H", "OH", "[1~":
+				e.pos = 0
+			case "[F", "OF", "[4~":
+				e.pos = len(e.line)
+			case "[3~":
+				e.delete(e.pos)
+			}
+		default:
+			if r >= ' ' && r != utf8.RuneError {
+				e.insert(string(r))
+			}
+		}
+		e.refresh()
+	}
+}
+
+func (e *editor) readEscape() string {
+	var seq []byte
+	for {
+		b, err := e.in.ReadByte()
+		if err != nil {
+			return string(seq)
+		}
+		seq = append(seq, b)
+		if len(seq) > 1 && (b >= 'A' && b <= 'Z' || b == '~') {
+			return string(seq)
+		}
+		if len(seq) > 4 {
+			return string(seq)
+		}
+	}
+}
+
+func (e *editor) insert(s string) {
+	rs := []rune(s)
+	line := make([]rune, 0, len(e.line)+len(rs))
+	line = append(line, e.line[:e.pos]...)
+	line = append(line, rs...)
+	line = append(line, e.line[e.pos:]...)
+	e.line = line
+	e.pos += len(rs)
+}
+
+func (e *editor) delete(pos int) {
+	if pos < len(e.line) {
+		e.line = append(e.line[:pos], e.line[pos+1:]...)
+	}
+}
+
+// saved is the line being typed before browsing
+func (e *editor) browse(up bool, hist int, saved string) (int, string) {
+	if hist == len(e.history) {
+		saved = string(e.line)
+	}
+	if up && hist > 0 {
+		hist--
+	} else if !up && hist < len(e.history) {
+		hist++
+	} else {
+		return hist, saved
+	}
+
+	if hist == len(e.history) {
+		e.line = []rune(saved)
+	} else {
+		e.line = []rune(e.history[hist])
+	}
+	e.pos = len(e.line)
+	return hist, saved
+}
+
+func (e *editor) tab() {
+	if e.complete == nil {
+		return
+	}
+
+	head := string(e.line[:e.pos])
+	candidates := e.complete(head)
+	if len(candidates) == 0 {
+		return
+	}
+
+	word := head[strings.LastIndex(head, " ")+1:]
+	prefix := commonPrefix(candidates)
+	if len(candidates) == 1 {
+		prefix += " "
+	}
+	if len(prefix) > len(word) && strings.HasPrefix(prefix, word) {
+		e.insert(prefix[len(word):])
+		return
+	}
+
+	// nothing to insert, list the candidates
+	fmt.Fprint(e.out, "\r\n"+strings.Join(candidates, "  ")+"\r\n")
+}
+
+func (e *editor) refresh() {
+	fmt.Fprintf(e.out, "\r%v%v\x1b[K", e.prompt, string(e.line))
+	if n := len(e.line) - e.pos; n > 0 {
+		fmt.Fprintf(e.out, "\x1b[%dD", n)
+	}
+}
+
+// the prefix never splits a rune
+func commonPrefix(words []string) string {
+	prefix := []rune(words[0])
+	for _, w := range words[1:] {
+		n := 0
+		for _, r := range w {
+			if n == len(prefix) || prefix[n] != r {
+				break
+			}
+			n++
+		}
+		prefix = prefix[:n]
+	}
+	return string(prefix)
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/name5566/leaf/conf"
+	"github.com/name5566/leaf/console"
+	"github.com/name5566/leaf/log"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+func init() {
+	// keep the output of the examples clean
+	logger, _ := log.New("fatal", "", 0)
+	log.Export(logger)
+}
+
+func Example_promptPrefix() {
+	fmt.Println(promptPrefix([]byte("agents\r\nLe"), "Leaf# "))
+	fmt.Println(promptPrefix([]byte("agents\r\nLeaf#"), "Leaf# "))
+	fmt.Println(promptPrefix([]byte("agents\r\n"), "Leaf# "))
+	fmt.Println(promptPrefix([]byte("L"), "Leaf# "))
+
+	// Output:
+	// 2
+	// 5
+	// 0
+	// 1
+}
+
+func Example_commonPrefix() {
+	fmt.Printf("%q\n", commonPrefix([]string{"cpuprof", "complete"}))
+	fmt.Printf("%q\n", commonPrefix([]string{"héllo", "hèllo"}))
+	fmt.Printf("%q\n", commonPrefix([]string{"日本", "日曜"}))
+	fmt.Printf("%q\n", commonPrefix([]string{"help"}))
+
+	// Output:
+	// "c"
+	// "h"
+	// "日"
+	// "help"
+}
+
+func Example_target() {
+	sessions := []*session{{addr: "host1:3333"}, {addr: "host2:3333"}}
+	show := func(line string) {
+		targets, rest := target(sessions, line)
+		var addrs []string
+		for _, s := range targets {
+			addrs = append(addrs, s.addr)
+		}
+		fmt.Printf("%v %q\n", addrs, rest)
+	}
+
+	show("agents")
+	show("@2 agents -format json")
+	show("@host1:3333   help")
+	show("@3 agents")
+	show("@host3:3333 agents")
+	show("@1")
+
+	// Output:
+	// [host1:3333 host2:3333] "agents"
+	// [host2:3333] "agents -format json"
+	// [host1:3333] "help"
+	// [] "agents"
+	// [] "agents"
+	// [host1:3333] ""
+}
+
+func Example_session() {
+	// the two sessions of the example
+	var streams int32
+	console.RegisterStream("both", "waits for the other session", func(s *console.Stream, args []string) {
+		atomic.AddInt32(&streams, 1)
+		deadline := time.Now().Add(time.Second)
+		for atomic.LoadInt32(&streams) < 2 && time.Now().Before(deadline) {
+			time.Sleep(time.Millisecond)
+		}
+		s.Printf("streams %v\r\n", atomic.LoadInt32(&streams))
+	})
+
+	conf.ConsolePort = 3591
+	console.Init()
+	defer console.Destroy()
+
+	var sessions []*session
+	for i := 0; i < 2; i++ {
+		var s *session
+		var err error
+		for i := 0; i < 100; i++ {
+			s, err = dial("localhost:3591", conf.ConsolePrompt, time.Second)
+			if err == nil {
+				break
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		defer s.close()
+		sessions = append(sessions, s)
+	}
+
+	// the complete command of the server
+	fmt.Println(sessions[0].complete("he"))
+	fmt.Println(sessions[0].complete("cpuprof "))
+	fmt.Println(sessions[0].complete("cpuprof st"))
+	fmt.Println(sessions[0].complete("help cpu"))
+	fmt.Println(sessions[0].complete("unknown "))
+
+	// side by side, one after another the first would wait alone
+	var buf bytes.Buffer
+	err := run(sessions, "both", &buf)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	lines := strings.Split(strings.TrimSpace(strings.Replace(buf.String(), "\r", "", -1)), "\n")
+	sort.Strings(lines)
+	fmt.Println(strings.Join(lines, "\n"))
+
+	// Output:
+	// [help]
+	// [start stop]
+	// [start stop]
+	// [cpuprof]
+	// []
+	// [localhost:3591] streams 2
+	// [localhost:3591] streams 2
+}
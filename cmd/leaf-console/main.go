@@ -0,0 +1,277 @@
+// leaf-console is a client of the Leaf console (conf.ConsolePort) with line
+// editing, history, completion, several servers at once and scripts.
+//
+//	leaf-console -addr localhost:3333
+//	leaf-console -addr host1:3333,host2:3333 -c "agents; prof heap"
+//	leaf-console -addr localhost:3333 -f commands.txt
+//
+// With several servers a line runs on every server side by side, each line
+// of the output is prefixed by its server, "@2 cmd" or "@host1:3333 cmd"
+// runs it on one server only. Ctrl-C stops the streaming commands such as
+// `tail log` or `top modules`. Line editing needs a terminal on linux, the
+// BSDs, darwin or windows.
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	addrs   = flag.String("addr", "localhost:3333", "console addresses, comma separated")
+	prompt  = flag.String("prompt", "Leaf# ", "the conf.ConsolePrompt of the servers")
+	cmds    = flag.String("c", "", "commands to run, separated by ;")
+	script  = flag.String("f", "", "script to run, one command per line, - for stdin")
+	timeout = flag.Duration("timeout", 5*time.Second, "dial timeout")
+)
+
+func main() {
+	flag.Parse()
+
+	var sessions []*session
+	for _, addr := range strings.Split(*addrs, ",") {
+		addr = strings.TrimSpace(addr)
+		if addr == "" {
+			continue
+		}
+		s, err := dial(addr, *prompt, *timeout)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "leaf-console: %v\n", err)
+			os.Exit(1)
+		}
+		defer s.close()
+		sessions = append(sessions, s)
+	}
+	if len(sessions) == 0 {
+		fmt.Fprintln(os.Stderr, "leaf-console: no address")
+		os.Exit(2)
+	}
+
+	var err error
+	switch {
+	case *cmds != "":
+		err = runScript(sessions, strings.NewReader(strings.Replace(*cmds, ";", "\n", -1)))
+	case *script == "-":
+		err = runScript(sessions, os.Stdin)
+	case *script != "":
+		var f *os.File
+		f, err = os.Open(*script)
+		if err == nil {
+			err = runScript(sessions, f)
+			f.Close()
+		}
+	case !isTerminal(int(os.Stdin.Fd())):
+		err = runScript(sessions, os.Stdin)
+	default:
+		err = runInteractive(sessions)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "leaf-console: %v\n", err)
+		for _, s := range sessions {
+			s.close()
+		}
+		os.Exit(1)
+	}
+}
+
+// blank lines and lines starting with # are skipped
+func runScript(sessions []*session, r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if line == "quit" {
+			return nil
+		}
+		err := run(sessions, line, os.Stdout)
+		if err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+func runInteractive(sessions []*session) error {
+	// Ctrl-C stops the streaming commands, the line editor reads it
+	// as a key otherwise
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	go func() {
+		for range interrupt {
+			for _, s := range sessions {
+				s.interrupt()
+			}
+		}
+	}()
+
+	e := newEditor(os.Stdin, os.Stdout)
+	e.complete = func(line string) []string {
+		s, line := target(sessions, line)
+		if len(s) == 0 {
+			return nil
+		}
+		return s[0].complete(line)
+	}
+
+	historyFile := ""
+	if home, err := os.UserHomeDir(); err == nil {
+		historyFile = filepath.Join(home, ".leaf_console_history")
+		e.loadHistory(historyFile)
+	}
+	defer func() {
+		if historyFile != "" {
+			e.saveHistory(historyFile)
+		}
+	}()
+
+	p := *prompt
+	if p == "" {
+		p = "> "
+	}
+	if len(sessions) > 1 {
+		p = fmt.Sprintf("[%v] %v", len(sessions), p)
+	}
+
+	for {
+		line, err := e.readLine(p)
+		if err == errInterrupt {
+			continue
+		} else if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		if line == "quit" {
+			return nil
+		}
+		err = run(sessions, line, os.Stdout)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// runs a line on the targeted sessions side by side
+func run(sessions []*session, line string, w io.Writer) error {
+	targets, line := target(sessions, line)
+	if len(targets) == 0 {
+		fmt.Fprintln(w, "unknown server")
+		return nil
+	}
+	if len(sessions) == 1 {
+		s := targets[0]
+		err := s.exec(line, w)
+		if err != nil {
+			return fmt.Errorf("%v: %v", s.addr, err)
+		}
+		return nil
+	}
+
+	var mutex sync.Mutex
+	var wg sync.WaitGroup
+	errs := make([]error, len(targets))
+	for i, s := range targets {
+		wg.Add(1)
+		go func(i int, s *session) {
+			defer wg.Done()
+			pw := &prefixWriter{w: w, mutex: &mutex, prefix: "[" + s.addr + "] "}
+			err := s.exec(line, pw)
+			pw.flush()
+			if err != nil {
+				errs[i] = fmt.Errorf("%v: %v", s.addr, err)
+			}
+		}(i, s)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writes whole lines prefixed, the writers sharing w share the mutex
+type prefixWriter struct {
+	w       io.Writer
+	mutex   *sync.Mutex
+	prefix  string
+	pending []byte
+}
+
+func (pw *prefixWriter) Write(b []byte) (int, error) {
+	pw.pending = append(pw.pending, b...)
+	i := bytes.LastIndexByte(pw.pending, '\n')
+	if i < 0 {
+		return len(b), nil
+	}
+
+	err := pw.write(pw.pending[:i+1])
+	pw.pending = append(pw.pending[:0], pw.pending[i+1:]...)
+	return len(b), err
+}
+
+// writes the last line, ended or not
+func (pw *prefixWriter) flush() error {
+	if len(pw.pending) == 0 {
+		return nil
+	}
+	err := pw.write(append(pw.pending, '\n'))
+	pw.pending = pw.pending[:0]
+	return err
+}
+
+func (pw *prefixWriter) write(lines []byte) error {
+	var buf bytes.Buffer
+	for _, line := range bytes.SplitAfter(lines, []byte("\n")) {
+		if len(line) > 0 {
+			buf.WriteString(pw.prefix)
+			buf.Write(line)
+		}
+	}
+
+	pw.mutex.Lock()
+	defer pw.mutex.Unlock()
+	_, err := pw.w.Write(buf.Bytes())
+	return err
+}
+
+// "@2 cmd" and "@addr cmd" target one session
+func target(sessions []*session, line string) ([]*session, string) {
+	if !strings.HasPrefix(line, "@") {
+		return sessions, line
+	}
+
+	name := strings.TrimPrefix(line, "@")
+	rest := ""
+	if i := strings.Index(name, " "); i >= 0 {
+		name, rest = name[:i], strings.TrimLeft(name[i:], " ")
+	}
+	if i, err := strconv.Atoi(name); err == nil && i >= 1 && i <= len(sessions) {
+		return sessions[i-1 : i], rest
+	}
+	for _, s := range sessions {
+		if s.addr == name {
+			return []*session{s}, rest
+		}
+	}
+	return nil, rest
+}
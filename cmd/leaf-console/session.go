@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"time"
+)
+
+// a connection to the console of a Leaf server
+type session struct {
+	addr   string
+	prompt string
+	conn   net.Conn
+	r      *bufio.Reader
+}
+
+func dial(addr, prompt string, timeout time.Duration) (*session, error) {
+	conn, err := net.DialTimeout("tcp", addr, timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &session{addr: addr, prompt: prompt, conn: conn, r: bufio.NewReader(conn)}
+
+	// the header expected by network.TCPServer
+	_, err = conn.Write([]byte("{{{"))
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	// the first prompt
+	conn.SetReadDeadline(time.Now().Add(timeout))
+	err = s.read(io.Discard)
+	conn.SetReadDeadline(time.Time{})
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *session) close() {
+	s.conn.Write([]byte("quit\n"))
+	s.conn.Close()
+}
+
+// stops the streaming command being run, ignored by the server otherwise
+func (s *session) interrupt() {
+	s.conn.Write([]byte{0x03})
+}
+
+// runs a command line, the output is streamed to w
+func (s *session) exec(line string, w io.Writer) error {
+	_, err := s.conn.Write([]byte(line + "\n"))
+	if err != nil {
+		return err
+	}
+	return s.read(w)
+}
+
+// runs a command line and returns its output
+func (s *session) call(line string) (string, error) {
+	var buf bytes.Buffer
+	err := s.exec(line, &buf)
+	return buf.String(), err
+}
+
+// copies the output to w until the prompt, with no prompt the output ends
+// once the server is idle
+func (s *session) read(w io.Writer) error {
+	if s.prompt == "" {
+		return s.readIdle(w)
+	}
+
+	var pending []byte
+	for {
+		b, err := s.r.ReadByte()
+		if err != nil {
+			return err
+		}
+		pending = append(pending, b)
+
+		// the prompt follows a line
+		if bytes.HasSuffix(pending, []byte(s.prompt)) {
+			n := len(pending) - len(s.prompt)
+			if n == 0 || pending[n-1] == '\n' {
+				_, err = w.Write(pending[:n])
+				return err
+			}
+		}
+
+		// hold back what could start the prompt
+		if s.r.Buffered() == 0 {
+			keep := promptPrefix(pending, s.prompt)
+			_, err = w.Write(pending[:len(pending)-keep])
+			if err != nil {
+				return err
+			}
+			pending = append(pending[:0], pending[len(pending)-keep:]...)
+		}
+	}
+}
+
+func (s *session) readIdle(w io.Writer) error {
+	defer s.conn.SetReadDeadline(time.Time{})
+
+	buf := make([]byte, 4096)
+	for {
+		s.conn.SetReadDeadline(time.Now().Add(300 * time.Millisecond))
+		n, err := s.r.Read(buf)
+		w.Write(buf[:n])
+		if err != nil {
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				return nil
+			}
+			return err
+		}
+	}
+}
+
+// the length of the longest suffix of b starting the prompt
+func promptPrefix(b []byte, prompt string) int {
+	for n := len(prompt) - 1; n > 0; n-- {
+		if n <= len(b) && bytes.HasSuffix(b, []byte(prompt[:n])) {
+			return n
+		}
+	}
+	return 0
+}
+
+// the candidates for the word being typed at the end of line
+func (s *session) complete(line string) []string {
+	args := strings.Fields(line)
+	if len(args) == 0 || strings.HasSuffix(line, " ") {
+		args = append(args, `""`)
+	}
+
+	output, err := s.call("complete " + strings.Join(args, " "))
+	if err != nil {
+		return nil
+	}
+	var candidates []string
+	for _, c := range strings.Split(output, "\n") {
+		c = strings.TrimSpace(c)
+		if c != "" {
+			candidates = append(candidates, c)
+		}
+	}
+	return candidates
+}
@@ -0,0 +1,22 @@
+//go:build !linux && !darwin && !dragonfly && !freebsd && !netbsd && !openbsd && !windows
+
+package main
+
+import (
+	"errors"
+)
+
+type termState struct{}
+
+// no line editing, plain lines are read
+func isTerminal(fd int) bool {
+	return false
+}
+
+func makeRaw(fd int) (*termState, error) {
+	return nil, errors.New("raw terminal not supported")
+}
+
+func restore(fd int, state *termState) error {
+	return nil
+}
@@ -0,0 +1,51 @@
+//go:build linux || darwin || dragonfly || freebsd || netbsd || openbsd
+
+package main
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+type termState syscall.Termios
+
+func ioctl(fd int, req uintptr, t *syscall.Termios) error {
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, uintptr(fd), req, uintptr(unsafe.Pointer(t)))
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+func isTerminal(fd int) bool {
+	var t syscall.Termios
+	return ioctl(fd, ioctlGetTermios, &t) == nil
+}
+
+// no echo, no line buffering, no signals, the output is left processed
+func makeRaw(fd int) (*termState, error) {
+	var t syscall.Termios
+	err := ioctl(fd, ioctlGetTermios, &t)
+	if err != nil {
+		return nil, err
+	}
+	old := termState(t)
+
+	t.Iflag &^= syscall.IGNBRK | syscall.BRKINT | syscall.PARMRK | syscall.ISTRIP |
+		syscall.INLCR | syscall.IGNCR | syscall.ICRNL | syscall.IXON
+	t.Lflag &^= syscall.ECHO | syscall.ECHONL | syscall.ICANON | syscall.ISIG | syscall.IEXTEN
+	t.Cflag &^= syscall.CSIZE | syscall.PARENB
+	t.Cflag |= syscall.CS8
+	t.Cc[syscall.VMIN] = 1
+	t.Cc[syscall.VTIME] = 0
+	err = ioctl(fd, ioctlSetTermios, &t)
+	if err != nil {
+		return nil, err
+	}
+	return &old, nil
+}
+
+func restore(fd int, state *termState) error {
+	t := syscall.Termios(*state)
+	return ioctl(fd, ioctlSetTermios, &t)
+}
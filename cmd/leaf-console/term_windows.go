@@ -0,0 +1,63 @@
+package main
+
+import (
+	"syscall"
+)
+
+const (
+	enableProcessedInput            = 0x1
+	enableLineInput                 = 0x2
+	enableEchoInput                 = 0x4
+	enableVirtualTerminalInput      = 0x200
+	enableVirtualTerminalProcessing = 0x4
+)
+
+var procSetConsoleMode = syscall.NewLazyDLL("kernel32.dll").NewProc("SetConsoleMode")
+
+type termState struct {
+	in, out uint32
+}
+
+func setConsoleMode(h syscall.Handle, mode uint32) error {
+	r, _, err := procSetConsoleMode.Call(uintptr(h), uintptr(mode))
+	if r == 0 {
+		return err
+	}
+	return nil
+}
+
+func isTerminal(fd int) bool {
+	var mode uint32
+	return syscall.GetConsoleMode(syscall.Handle(fd), &mode) == nil
+}
+
+// no echo, no line buffering, Ctrl-C and the keys are read as the escape
+// sequences of a VT100, the output interprets them
+func makeRaw(fd int) (*termState, error) {
+	var state termState
+	err := syscall.GetConsoleMode(syscall.Handle(fd), &state.in)
+	if err != nil {
+		return nil, err
+	}
+	err = syscall.GetConsoleMode(syscall.Stdout, &state.out)
+	if err != nil {
+		return nil, err
+	}
+
+	in := state.in&^(enableProcessedInput|enableLineInput|enableEchoInput) | enableVirtualTerminalInput
+	err = setConsoleMode(syscall.Handle(fd), in)
+	if err != nil {
+		return nil, err
+	}
+	err = setConsoleMode(syscall.Stdout, state.out|enableVirtualTerminalProcessing)
+	if err != nil {
+		setConsoleMode(syscall.Handle(fd), state.in)
+		return nil, err
+	}
+	return &state, nil
+}
+
+func restore(fd int, state *termState) error {
+	setConsoleMode(syscall.Stdout, state.out)
+	return setConsoleMode(syscall.Handle(fd), state.in)
+}
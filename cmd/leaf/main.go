@@ -0,0 +1,103 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/name5566/leaf/idl"
+	"github.com/name5566/leaf/scaffold"
+	"os"
+	"path/filepath"
+)
+
+const usage = `Usage:
+
+	leaf new [flags] <dir>		create a new Leaf server
+	leaf gen [flags] <file.idl>	generate the messages, the routing and the handlers
+`
+
+func main() {
+	if len(os.Args) < 2 {
+		exitUsage()
+	}
+
+	switch os.Args[1] {
+	case "new":
+		cmdNew(os.Args[2:])
+	case "gen":
+		cmdGen(os.Args[2:])
+	default:
+		exitUsage()
+	}
+}
+
+func exitUsage() {
+	fmt.Fprint(os.Stderr, usage)
+	os.Exit(2)
+}
+
+func exit(cmd string, err error) {
+	fmt.Fprintf(os.Stderr, "leaf %v: %v\n", cmd, err)
+	os.Exit(1)
+}
+
+func cmdNew(args []string) {
+	p := new(scaffold.Project)
+	fs := flag.NewFlagSet("new", flag.ExitOnError)
+	fs.StringVar(&p.Module, "module", "", "go module path (default: the base of dir)")
+	fs.StringVar(&p.Processor, "processor", scaffold.ProcessorJSON, "message processor, json or protobuf")
+	fs.StringVar(&p.LeafPath, "leaf", "github.com/name5566/leaf", "import path of Leaf")
+	fs.StringVar(&p.TCPAddr, "tcp", "127.0.0.1:3563", "tcp listen address")
+	fs.StringVar(&p.WSAddr, "ws", "127.0.0.1:3653", "websocket listen address")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		exitUsage()
+	}
+	p.Dir = fs.Arg(0)
+
+	err := p.Generate()
+	if err != nil {
+		exit("new", err)
+	}
+	for _, name := range p.Files() {
+		fmt.Println(name)
+	}
+}
+
+func cmdGen(args []string) {
+	g := new(idl.Generator)
+	fs := flag.NewFlagSet("gen", flag.ExitOnError)
+	dir := fs.String("o", ".", "project directory")
+	fs.StringVar(&g.LeafPath, "leaf", "github.com/name5566/leaf", "import path of Leaf")
+	fs.StringVar(&g.GatePackage, "gate", "gate", "directory of the package routing the messages")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		exitUsage()
+	}
+
+	file, err := idl.ParseFile(fs.Arg(0))
+	if err != nil {
+		exit("gen", err)
+	}
+	outs, err := g.Generate(file)
+	if err != nil {
+		exit("gen", err)
+	}
+
+	for _, out := range outs {
+		filename := filepath.Join(*dir, filepath.FromSlash(out.Name))
+		if out.Stub {
+			if _, err := os.Stat(filename); err == nil {
+				continue
+			}
+		}
+		err = os.MkdirAll(filepath.Dir(filename), 0755)
+		if err != nil {
+			exit("gen", err)
+		}
+		err = os.WriteFile(filename, out.Data, 0644)
+		if err != nil {
+			exit("gen", err)
+		}
+		fmt.Println(out.Name)
+	}
+}
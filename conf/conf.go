@@ -1,5 +1,9 @@
 package conf
 
+import (
+	"time"
+)
+
 var (
 	LenStackBuf = 4096
 
@@ -17,4 +21,12 @@ var (
 	ListenAddr      string
 	ConnAddrs       []string
 	PendingWriteNum int
+	AcceptShards    int // listeners sharing ListenAddr by SO_REUSEPORT
+
+	// cluster socket options, see network.SockOpts
+	SockDelay       bool
+	SockReadBuffer  int
+	SockWriteBuffer int
+	SockKeepAlive   time.Duration
+	SockLinger      time.Duration
 )
@@ -0,0 +1,355 @@
+package console
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type ArgKind int
+
+const (
+	ArgString ArgKind = iota
+	ArgInt
+	ArgFloat
+	ArgBool
+	ArgDuration
+)
+
+func (k ArgKind) String() string {
+	switch k {
+	case ArgInt:
+		return "int"
+	case ArgFloat:
+		return "float"
+	case ArgBool:
+		return "bool"
+	case ArgDuration:
+		return "duration"
+	default:
+		return "string"
+	}
+}
+
+// the type of the values of a kind
+func (k ArgKind) valid(v interface{}) bool {
+	switch k {
+	case ArgInt:
+		_, ok := v.(int)
+		return ok
+	case ArgFloat:
+		_, ok := v.(float64)
+		return ok
+	case ArgBool:
+		_, ok := v.(bool)
+		return ok
+	case ArgDuration:
+		_, ok := v.(time.Duration)
+		return ok
+	default:
+		_, ok := v.(string)
+		return ok
+	}
+}
+
+type Arg struct {
+	Name     string
+	Kind     ArgKind
+	Help     string
+	Default  interface{} // of the type of Kind, a positional arg with no default is required
+	Choices  []string    // the allowed values, also completed by leaf-console
+	Variadic bool        // the last positional arg only, takes the remaining args
+	Validate func(v interface{}) error
+}
+
+// the args of a command registered with RegisterArgs, flags are written
+// -name value, -name=value or -name for the bool flags, anywhere on the line
+type Args struct {
+	Positional []*Arg
+	Flags      []*Arg
+}
+
+// the parsed args, variadic args are []interface{}
+type Values map[string]interface{}
+
+func (v Values) String(name string) string {
+	s, _ := v[name].(string)
+	return s
+}
+
+func (v Values) Int(name string) int {
+	i, _ := v[name].(int)
+	return i
+}
+
+func (v Values) Float(name string) float64 {
+	f, _ := v[name].(float64)
+	return f
+}
+
+func (v Values) Bool(name string) bool {
+	b, _ := v[name].(bool)
+	return b
+}
+
+func (v Values) Duration(name string) time.Duration {
+	d, _ := v[name].(time.Duration)
+	return d
+}
+
+func (v Values) List(name string) []interface{} {
+	l, _ := v[name].([]interface{})
+	return l
+}
+
+func (args *Args) flag(name string) *Arg {
+	for _, f := range args.Flags {
+		if f.Name == name {
+			return f
+		}
+	}
+	return nil
+}
+
+func (args *Args) Parse(words []string) (Values, error) {
+	values := make(Values)
+	var positional []string
+	for i := 0; i < len(words); i++ {
+		w := words[i]
+		if len(w) < 2 || w[0] != '-' || isNumber(w) {
+			positional = append(positional, w)
+			continue
+		}
+		if w == "--" {
+			positional = append(positional, words[i+1:]...)
+			break
+		}
+
+		name := strings.TrimLeft(w, "-")
+		value, hasValue := "", false
+		if j := strings.Index(name, "="); j >= 0 {
+			name, value, hasValue = name[:j], name[j+1:], true
+		}
+		f := args.flag(name)
+		if f == nil {
+			return nil, fmt.Errorf("unknown flag -%v", name)
+		}
+		if !hasValue {
+			if f.Kind == ArgBool {
+				value = "true"
+			} else if i+1 < len(words) {
+				i++
+				value = words[i]
+			} else {
+				return nil, fmt.Errorf("flag -%v needs a value", name)
+			}
+		}
+		v, err := f.parse(value)
+		if err != nil {
+			return nil, err
+		}
+		values[f.Name] = v
+	}
+
+	for i, a := range args.Positional {
+		if a.Variadic {
+			var list []interface{}
+			for _, s := range positional[min(i, len(positional)):] {
+				v, err := a.parse(s)
+				if err != nil {
+					return nil, err
+				}
+				list = append(list, v)
+			}
+			if len(list) > 0 {
+				values[a.Name] = list
+			} else if a.Default == nil {
+				return nil, fmt.Errorf("missing %v", a.Name)
+			}
+			positional = nil
+			break
+		}
+		if i >= len(positional) {
+			if a.Default == nil {
+				return nil, fmt.Errorf("missing %v", a.Name)
+			}
+			continue
+		}
+		v, err := a.parse(positional[i])
+		if err != nil {
+			return nil, err
+		}
+		values[a.Name] = v
+	}
+	if len(positional) > len(args.Positional) {
+		return nil, fmt.Errorf("too many args: %v", strings.Join(positional[len(args.Positional):], " "))
+	}
+
+	// defaults
+	for _, list := range [][]*Arg{args.Positional, args.Flags} {
+		for _, a := range list {
+			if _, ok := values[a.Name]; ok || a.Default == nil {
+				continue
+			}
+			// a variadic arg is always a list
+			if _, ok := a.Default.([]interface{}); a.Variadic && !ok {
+				values[a.Name] = []interface{}{a.Default}
+			} else {
+				values[a.Name] = a.Default
+			}
+		}
+	}
+
+	return values, nil
+}
+
+// the default of a variadic arg may be a list
+func (a *Arg) validDefault() bool {
+	if a.Default == nil {
+		return true
+	}
+	list, ok := a.Default.([]interface{})
+	if !a.Variadic || !ok {
+		return a.Kind.valid(a.Default)
+	}
+	for _, v := range list {
+		if !a.Kind.valid(v) {
+			return false
+		}
+	}
+	return true
+}
+
+func isNumber(s string) bool {
+	_, err := strconv.ParseFloat(s, 64)
+	return err == nil
+}
+
+func (a *Arg) parse(s string) (interface{}, error) {
+	if len(a.Choices) > 0 {
+		found := false
+		for _, c := range a.Choices {
+			if c == s {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("invalid %v %q, must be one of %v", a.Name, s, strings.Join(a.Choices, "|"))
+		}
+	}
+
+	var v interface{}
+	var err error
+	switch a.Kind {
+	case ArgInt:
+		v, err = strconv.Atoi(s)
+	case ArgFloat:
+		v, err = strconv.ParseFloat(s, 64)
+	case ArgBool:
+		v, err = strconv.ParseBool(s)
+	case ArgDuration:
+		v, err = time.ParseDuration(s)
+	default:
+		v = s
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid %v %q, %v expected", a.Name, s, a.Kind)
+	}
+
+	if a.Validate != nil {
+		err = a.Validate(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %v %q: %v", a.Name, s, err)
+		}
+	}
+	return v, nil
+}
+
+func (args *Args) Usage(name string) string {
+	usage := "Usage: " + name
+	for _, a := range args.Positional {
+		s := "<" + a.Name + ">"
+		if a.Variadic {
+			s += "..."
+		}
+		if a.Default != nil {
+			s = "[" + s + "]"
+		}
+		usage += " " + s
+	}
+	if len(args.Flags) > 0 {
+		usage += " [flags]"
+	}
+
+	var lines [][2]string
+	for _, a := range args.Positional {
+		lines = append(lines, [2]string{a.Name, a.describe()})
+	}
+	for _, f := range args.Flags {
+		s := "-" + f.Name
+		if f.Kind != ArgBool {
+			s += " " + f.Kind.String()
+		}
+		lines = append(lines, [2]string{s, f.describe()})
+	}
+
+	width := 0
+	for _, l := range lines {
+		width = max(width, len(l[0]))
+	}
+	for _, l := range lines {
+		usage += fmt.Sprintf("\r\n  %-*v - %v", width, l[0], l[1])
+	}
+	return usage
+}
+
+func (a *Arg) describe() string {
+	s := a.Help
+	if len(a.Choices) > 0 {
+		s += " (" + strings.Join(a.Choices, "|") + ")"
+	}
+	if a.Default != nil && a.Default != false && a.Default != "" {
+		s += fmt.Sprintf(" (default %v)", a.Default)
+	}
+	return strings.TrimSpace(s)
+}
+
+// the flag names and the choices of the arg being typed
+func (args *Args) complete(words []string, prefix string) []string {
+	if strings.HasPrefix(prefix, "-") {
+		var names []string
+		for _, f := range args.Flags {
+			names = append(names, "-"+f.Name)
+		}
+		return names
+	}
+
+	// the value of a flag
+	if len(words) > 0 {
+		last := strings.TrimLeft(words[len(words)-1], "-")
+		if f := args.flag(last); f != nil && strings.HasPrefix(words[len(words)-1], "-") && f.Kind != ArgBool {
+			return f.Choices
+		}
+	}
+
+	// the positional arg
+	n := 0
+	for i := 0; i < len(words); i++ {
+		if strings.HasPrefix(words[i], "-") && !isNumber(words[i]) {
+			f := args.flag(strings.TrimLeft(words[i], "-"))
+			if f != nil && f.Kind != ArgBool && !strings.Contains(words[i], "=") {
+				i++
+			}
+			continue
+		}
+		n++
+	}
+	for i, a := range args.Positional {
+		if i == n || a.Variadic && n >= i {
+			return a.Choices
+		}
+	}
+	return nil
+}
@@ -8,13 +8,18 @@ import (
 	"os"
 	"path"
 	"runtime/pprof"
+	"strings"
 	"time"
 )
 
 var commands = []Command{
 	new(CommandHelp),
+	new(CommandComplete),
 	new(CommandCPUProf),
 	new(CommandProf),
+	new(CommandTail),
+	new(CommandWatch),
+	new(CommandTop),
 }
 
 type Command interface {
@@ -26,6 +31,19 @@ type Command interface {
 	run(args []string) string
 }
 
+// implemented by the commands with a usage
+type usager interface {
+	// must goroutine safe
+	usage() string
+}
+
+// implemented by the commands able to complete their arguments
+type completer interface {
+	// must goroutine safe
+	// args are the complete args, prefix the arg being typed
+	complete(args []string, prefix string) []string
+}
+
 type ExternalCommand struct {
 	_name  string
 	_help  string
@@ -76,6 +94,87 @@ func Register(name string, help string, f interface{}, server *chanrpc.Server) {
 	commands = append(commands, c)
 }
 
+// the handler f is a func([]interface{}) interface{} called with the parsed
+// Values and returns the output, formatted by Format, a -format flag picks
+// the table or json format
+// you must call the function before calling console.Init
+// goroutine not safe
+func RegisterArgs(name string, help string, args Args, f interface{}, server *chanrpc.Server) {
+	for _, c := range commands {
+		if c.name() == name {
+			log.Fatal("command %v is already registered", name)
+		}
+	}
+	for _, a := range args.Positional[:max(len(args.Positional)-1, 0)] {
+		if a.Variadic {
+			log.Fatal("command %v: only the last arg can be variadic", name)
+		}
+	}
+	for _, list := range [][]*Arg{args.Positional, args.Flags} {
+		for _, a := range list {
+			if !a.validDefault() {
+				log.Fatal("command %v: default %v of %v is %T, %v expected", name, a.Default, a.Name, a.Default, a.Kind)
+			}
+		}
+	}
+	if _, ok := f.(func([]interface{}) interface{}); !ok {
+		log.Fatal("command %v: handler %T is not func([]interface{}) interface{}", name, f)
+	}
+	if args.flag("format") == nil {
+		args.Flags = append(args.Flags, &Arg{
+			Name:    "format",
+			Help:    "output format",
+			Default: FormatTable,
+			Choices: []string{FormatTable, FormatJSON},
+		})
+	}
+
+	server.Register(name, f)
+
+	c := new(ArgsCommand)
+	c._name = name
+	c._help = help
+	c.args = args
+	c.server = server
+	commands = append(commands, c)
+}
+
+type ArgsCommand struct {
+	_name  string
+	_help  string
+	args   Args
+	server *chanrpc.Server
+}
+
+func (c *ArgsCommand) name() string {
+	return c._name
+}
+
+func (c *ArgsCommand) help() string {
+	return c._help
+}
+
+func (c *ArgsCommand) usage() string {
+	return c.args.Usage(c._name)
+}
+
+func (c *ArgsCommand) complete(args []string, prefix string) []string {
+	return c.args.complete(args, prefix)
+}
+
+func (c *ArgsCommand) run(args []string) string {
+	values, err := c.args.Parse(args)
+	if err != nil {
+		return err.Error() + "\r\n" + c.usage()
+	}
+
+	ret, err := c.server.Call1(c._name, values)
+	if err != nil {
+		return err.Error()
+	}
+	return Format(ret, values.String("format"))
+}
+
 // help
 type CommandHelp struct{}
 
@@ -84,10 +183,36 @@ func (c *CommandHelp) name() string {
 }
 
 func (c *CommandHelp) help() string {
-	return "this help text"
+	return "this help text, try `help <command>` for the usage of a command"
+}
+
+func (c *CommandHelp) complete(args []string, prefix string) []string {
+	if len(args) > 0 {
+		return nil
+	}
+	var names []string
+	for _, c := range commands {
+		if _, ok := c.(usager); ok {
+			names = append(names, c.name())
+		}
+	}
+	return names
 }
 
-func (c *CommandHelp) run([]string) string {
+func (c *CommandHelp) run(args []string) string {
+	if len(args) > 0 {
+		for _, c := range commands {
+			if c.name() != args[0] {
+				continue
+			}
+			if u, ok := c.(usager); ok {
+				return u.usage()
+			}
+			return c.name() + " - " + c.help()
+		}
+		return "command not found"
+	}
+
 	output := "Commands:\r\n"
 	for _, c := range commands {
 		output += c.name() + " - " + c.help() + "\r\n"
@@ -97,6 +222,60 @@ func (c *CommandHelp) run([]string) string {
 	return output
 }
 
+// complete
+type CommandComplete struct{}
+
+func (c *CommandComplete) name() string {
+	return "complete"
+}
+
+func (c *CommandComplete) help() string {
+	return "completion candidates of a line, used by leaf-console"
+}
+
+// the last arg is the prefix being typed, an empty prefix is passed as ""
+func (c *CommandComplete) run(args []string) string {
+	if len(args) > 0 && args[len(args)-1] == `""` {
+		args[len(args)-1] = ""
+	}
+
+	var candidates []string
+	if len(args) <= 1 {
+		var prefix string
+		if len(args) == 1 {
+			prefix = args[0]
+		}
+		for _, c := range commands {
+			candidates = append(candidates, c.name())
+		}
+		candidates = append(candidates, "quit")
+		candidates = filterPrefix(candidates, prefix)
+	} else {
+		for _, _c := range commands {
+			if _c.name() != args[0] {
+				continue
+			}
+			if cc, ok := _c.(completer); ok {
+				prefix := args[len(args)-1]
+				candidates = filterPrefix(cc.complete(args[1:len(args)-1], prefix), prefix)
+			}
+			break
+		}
+	}
+
+	return strings.Join(candidates, "\r\n")
+}
+
+func filterPrefix(words []string, prefix string) []string {
+	var filtered []string
+	for _, w := range words {
+		if strings.HasPrefix(w, prefix) {
+			filtered = append(filtered, w)
+		}
+	}
+	return filtered
+}
+
 // cpuprof
 type CommandCPUProf struct{}
 
@@ -116,6 +295,13 @@ func (c *CommandCPUProf) usage() string {
 		"  stop  - stops the current CPU profile"
 }
 
+func (c *CommandCPUProf) complete(args []string, prefix string) []string {
+	if len(args) == 0 {
+		return []string{"start", "stop"}
+	}
+	return nil
+}
+
 func (c *CommandCPUProf) run(args []string) string {
 	if len(args) == 0 {
 		return c.usage()
@@ -175,6 +361,13 @@ func (c *CommandProf) usage() string {
 		"  block     - stack traces that led to blocking on synchronization primitives"
 }
 
+func (c *CommandProf) complete(args []string, prefix string) []string {
+	if len(args) == 0 {
+		return []string{"goroutine", "heap", "thread", "block"}
+	}
+	return nil
+}
+
 func (c *CommandProf) run(args []string) string {
 	if len(args) == 0 {
 		return c.usage()
@@ -3,6 +3,7 @@ package console
 import (
 	"bufio"
 	"github.com/name5566/leaf/conf"
+	"github.com/name5566/leaf/log"
 	"github.com/name5566/leaf/network"
 	"math"
 	"strconv"
@@ -31,29 +32,118 @@ func Destroy() {
 	}
 }
 
+// sent on the input channel for Ctrl-C
+const inputCancel = "\x03"
+
 type Agent struct {
 	conn   *network.TCPConn
 	reader *bufio.Reader
+	input  chan string
+	done   chan struct{}
 }
 
 func newAgent(conn *network.TCPConn) network.Agent {
 	a := new(Agent)
 	a.conn = conn
 	a.reader = bufio.NewReader(conn)
+	a.input = make(chan string, 1)
+	a.done = make(chan struct{})
 	return a
 }
 
+// reads the lines and Ctrl-C (0x03 or telnet IAC IP), the other telnet
+// commands and subnegotiations (IAC SB ... IAC SE) are skipped
+func (a *Agent) read() {
+	defer close(a.input)
+
+	send := func(s string) bool {
+		select {
+		case a.input <- s:
+			return true
+		case <-a.done:
+			return false
+		}
+	}
+
+	var line []byte
+	for {
+		b, err := a.reader.ReadByte()
+		if err != nil {
+			return
+		}
+
+		switch b {
+		case 0x03:
+			if !send(inputCancel) {
+				return
+			}
+		case 0xff:
+			cmd, err := a.reader.ReadByte()
+			if err != nil {
+				return
+			}
+			switch {
+			case cmd == 0xf4:
+				if !send(inputCancel) {
+					return
+				}
+			case cmd == 0xfa:
+				if a.skipSubnegotiation() != nil {
+					return
+				}
+			case cmd >= 0xfb && cmd <= 0xfe:
+				a.reader.ReadByte()
+			case cmd == 0xff:
+				line = append(line, cmd)
+			}
+		case '\n':
+			if !send(strings.TrimSuffix(string(line), "\r")) {
+				return
+			}
+			line = line[:0]
+		default:
+			line = append(line, b)
+		}
+	}
+}
+
+// skips the bytes up to IAC SE, IAC IAC is a data byte
+func (a *Agent) skipSubnegotiation() error {
+	for {
+		b, err := a.reader.ReadByte()
+		if err != nil {
+			return err
+		}
+		if b != 0xff {
+			continue
+		}
+		b, err = a.reader.ReadByte()
+		if err != nil {
+			return err
+		}
+		if b == 0xf0 {
+			return nil
+		}
+	}
+}
+
 func (a *Agent) Run() {
+	defer close(a.done)
+	go a.read()
+
 	for {
 		if conf.ConsolePrompt != "" {
 			a.conn.Write([]byte(conf.ConsolePrompt))
 		}
 
-		line, err := a.reader.ReadString('\n')
-		if err != nil {
+		line, ok := <-a.input
+		if !ok {
 			break
 		}
-		line = strings.TrimSuffix(line[:len(line)-1], "\r")
+		if line == inputCancel {
+			a.conn.Write([]byte("\r\n"))
+			continue
+		}
 
 		args := strings.Fields(line)
 		if len(args) == 0 {
@@ -73,6 +163,12 @@ func (a *Agent) Run() {
 			a.conn.Write([]byte("command not found, try `help` for help\r\n"))
 			continue
 		}
+		if sc, ok := c.(streamer); ok {
+			if !a.stream(sc, args[1:]) {
+				break
+			}
+			continue
+		}
 		output := c.run(args[1:])
 		if output != "" {
 			a.conn.Write([]byte(output + "\r\n"))
@@ -81,3 +177,32 @@ func (a *Agent) Run() {
 }
 
 func (a *Agent) OnClose() {}
+
+// returns false once the session is closed
+func (a *Agent) stream(sc streamer, args []string) bool {
+	s := newStream(a.conn)
+	finished := make(chan struct{})
+	go func() {
+		defer close(finished)
+		defer func() {
+			if r := recover(); r != nil {
+				log.Error("%v", r)
+			}
+		}()
+		sc.stream(s, args)
+	}()
+
+	for {
+		select {
+		case <-finished:
+			return true
+		case _, ok := <-a.input:
+			// any input cancels
+			s.cancel()
+			if !ok {
+				<-finished
+				return false
+			}
+		}
+	}
+}
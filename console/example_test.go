@@ -0,0 +1,357 @@
+package console_test
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"github.com/name5566/leaf/conf"
+	"github.com/name5566/leaf/console"
+	"github.com/name5566/leaf/log"
+	"net"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+func init() {
+	// keep the output of the examples clean
+	logger, _ := log.New("fatal", "", 0)
+	log.Export(logger)
+}
+
+// a console session, the prompt ends the output of a command
+type session struct {
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+// the console is destroyed by close
+func startConsole(port int) (*session, error) {
+	conf.ConsolePort = port
+	console.Init()
+
+	var conn net.Conn
+	var err error
+	for i := 0; i < 50; i++ {
+		conn, err = net.Dial("tcp", fmt.Sprintf("localhost:%v", port))
+		if err == nil {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if err != nil {
+		console.Destroy()
+		return nil, err
+	}
+	conn.Write([]byte("{{{"))
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	s := &session{conn: conn, reader: bufio.NewReader(conn)}
+	_, err = s.readPrompt()
+	if err != nil {
+		s.close()
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *session) close() {
+	s.conn.Close()
+	console.Destroy()
+}
+
+func (s *session) readPrompt() (string, error) {
+	var b []byte
+	for !bytes.HasSuffix(b, []byte(conf.ConsolePrompt)) {
+		c, err := s.reader.ReadByte()
+		if err != nil {
+			return "", err
+		}
+		b = append(b, c)
+	}
+	return string(b[:len(b)-len(conf.ConsolePrompt)]), nil
+}
+
+func (s *session) readLine() (string, error) {
+	line, err := s.reader.ReadString('\n')
+	return strings.TrimSuffix(line, "\r\n"), err
+}
+
+// reads the lines until one starts with prefix
+func (s *session) readUntil(prefix string) (string, error) {
+	for {
+		line, err := s.readLine()
+		if err != nil || strings.HasPrefix(line, prefix) {
+			return line, err
+		}
+	}
+}
+
+// the last line of the output up to the prompt
+func (s *session) lastLine() string {
+	output, err := s.readPrompt()
+	if err != nil {
+		return err.Error()
+	}
+	lines := strings.Split(strings.TrimSuffix(output, "\r\n"), "\r\n")
+	return lines[len(lines)-1]
+}
+
+func ExampleArgs() {
+	args := console.Args{
+		Positional: []*console.Arg{
+			{Name: "id", Kind: console.ArgInt, Help: "agent id"},
+			{Name: "reason", Help: "kick reason", Default: "kicked", Variadic: true},
+		},
+		Flags: []*console.Arg{
+			{Name: "ban", Kind: console.ArgDuration, Help: "ban the ip for a period"},
+			{Name: "quiet", Kind: console.ArgBool, Help: "no notice to the agent"},
+		},
+	}
+
+	fmt.Println(strings.Replace(args.Usage("kick"), "\r\n", "\n", -1))
+
+	values, err := args.Parse([]string{"42", "-ban", "10m", "spam", "flood", "-quiet"})
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	fmt.Println(values.Int("id"), values.List("reason"), values.Duration("ban") == 10*time.Minute, values.Bool("quiet"))
+
+	values, err = args.Parse([]string{"42"})
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	fmt.Println(values.List("reason"))
+
+	_, err = args.Parse([]string{"x"})
+	fmt.Println(err)
+
+	// Output:
+	// Usage: kick <id> [<reason>...] [flags]
+	//   id            - agent id
+	//   reason        - kick reason (default kicked)
+	//   -ban duration - ban the ip for a period
+	//   -quiet        - no notice to the agent
+	// 42 [spam flood] true true
+	// [kicked]
+	// invalid id "x", int expected
+}
+
+func ExampleFormat() {
+	type Agent struct {
+		ID   int
+		Addr string
+	}
+	agents := []Agent{{1, "127.0.0.1:5000"}, {22, "127.0.0.1:5001"}}
+
+	fmt.Println(strings.Replace(console.Format(agents, console.FormatTable), "\r\n", "\n", -1))
+	fmt.Println(strings.Replace(console.Format(agents[0], console.FormatJSON), "\r\n", "\n", -1))
+
+	// Output:
+	// ID  Addr
+	// 1   127.0.0.1:5000
+	// 22  127.0.0.1:5001
+	// {
+	//   "ID": 1,
+	//   "Addr": "127.0.0.1:5000"
+	// }
+}
+
+func ExampleFormat_maps() {
+	rows := []map[string]interface{}{
+		{"id": 1, "name": "gate"},
+		{"id": 2, "addr": "127.0.0.1:3563"},
+	}
+	fmt.Println(strings.Replace(console.Format(rows, console.FormatTable), "\r\n", "\n", -1))
+
+	// Output:
+	// addr            id  name
+	//                 1   gate
+	// 127.0.0.1:3563  2
+}
+
+func ExampleRegisterStream() {
+	console.RegisterStream("ticks", "print the ticks until cancelled", func(s *console.Stream, args []string) {
+		for i := 0; ; i++ {
+			s.Printf("tick %v\r\n", i)
+			select {
+			case <-s.Done():
+				s.Printf("cancelled\r\n")
+				return
+			case <-time.After(10 * time.Millisecond):
+			}
+		}
+	})
+	console.RegisterStream("progress", "a progress bar", func(s *console.Stream, args []string) {
+		for i := 0; i <= 4; i++ {
+			s.Progress(i, 4)
+		}
+	})
+
+	s, err := startConsole(3592)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	defer s.close()
+
+	// Ctrl-C
+	s.conn.Write([]byte("ticks\r\n"))
+	fmt.Println(s.readUntil("tick 2"))
+	s.conn.Write([]byte{0x03})
+	fmt.Println(s.lastLine())
+
+	// telnet IAC IP
+	s.conn.Write([]byte("ticks\r\n"))
+	fmt.Println(s.readUntil("tick 1"))
+	s.conn.Write([]byte{0xff, 0xf4})
+	fmt.Println(s.lastLine())
+
+	s.conn.Write([]byte("progress\r\n"))
+	bars := strings.Split(s.lastLine(), "\r")
+	fmt.Println(bars[len(bars)-1])
+
+	// Output:
+	// tick 2 <nil>
+	// cancelled
+	// tick 1 <nil>
+	// cancelled
+	// [##############################] 100% (4/4)
+}
+
+func ExampleCommandTail() {
+	dir, err := os.MkdirTemp("", "leaf")
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	defer os.RemoveAll(dir)
+	fileLogger, err := log.New("debug", dir, 0)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	log.Export(fileLogger)
+	defer func() {
+		logger, _ := log.New("fatal", "", 0)
+		log.Export(logger)
+		fileLogger.Close()
+	}()
+
+	s, err := startConsole(3593)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	defer s.close()
+
+	fmt.Println(strings.Split(s.run("tail"), "\r\n")[0])
+
+	// the lines printed once tail runs
+	s.conn.Write([]byte("tail log\r\n"))
+	stop := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		for i := 0; ; i++ {
+			select {
+			case <-stop:
+				return
+			case <-time.After(10 * time.Millisecond):
+				log.Release("hello %v", i)
+			}
+		}
+	}()
+	line, err := s.readUntil("20")
+	close(stop)
+	<-stopped
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	fmt.Println(strings.Split(line[strings.Index(line, "[release]"):], " ")[:2])
+	s.conn.Write([]byte{0x03})
+	s.readPrompt()
+
+	// untapped once cancelled
+	log.Release("gone")
+	fmt.Println(strings.Split(s.run("tail"), "\r\n")[0])
+
+	// Output:
+	// Usage: tail log
+	// [[release] hello]
+	// Usage: tail log
+}
+
+func (s *session) run(line string) string {
+	s.conn.Write([]byte(line + "\r\n"))
+	output, err := s.readPrompt()
+	if err != nil {
+		return err.Error()
+	}
+	return strings.TrimSuffix(output, "\r\n")
+}
+
+func ExampleRegisterWatch() {
+	var n int32
+	console.RegisterWatch("example", func() interface{} {
+		return []map[string]interface{}{{"n": atomic.AddInt32(&n, 1)}}
+	})
+
+	s, err := startConsole(3594)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	defer s.close()
+
+	fmt.Println(strings.Split(s.run("watch missing"), "\r\n")[0])
+
+	s.conn.Write([]byte("watch example 20ms\r\n"))
+	for i := 0; i < 2; i++ {
+		title, _ := s.readLine()
+		header, _ := s.readLine()
+		row, _ := s.readLine()
+		s.readLine()
+		fmt.Println(title[strings.Index(title, " ")+1:], header, row)
+	}
+	s.conn.Write([]byte{0x03})
+	s.readPrompt()
+
+	s.conn.Write([]byte("top example 20ms\r\n"))
+	title, _ := s.readLine()
+	s.readLine()
+	header, _ := s.readLine()
+	row, _ := s.readLine()
+	fmt.Printf("%q %v %v\n", title[:len("\x1b[H\x1b[2J")], title[strings.Index(title, " ")+1:], header)
+	fmt.Println(row > "2")
+	s.conn.Write([]byte{0x03})
+	s.readPrompt()
+
+	// Output:
+	// missing not found
+	// example n 1
+	// example n 2
+	// "\x1b[H\x1b[2J" example, Ctrl-C to quit n
+	// true
+}
+
+func Example_telnet() {
+	s, err := startConsole(3595)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	defer s.close()
+
+	// IAC WILL NAWS, IAC SB NAWS 80x24 IAC SE, IAC SB TTYPE with IAC IAC IAC SE
+	s.conn.Write([]byte{0xff, 0xfb, 0x1f, 0xff, 0xfa, 0x1f, 0x00, 0x50, 0x00, 0x18, 0xff, 0xf0})
+	s.conn.Write([]byte{0xff, 0xfa, 0x18, 0x00, 0xff, 0xff, 'x', 0xff, 0xf0})
+	fmt.Println(strings.Split(s.run("tail"), "\r\n")[0])
+
+	// Output:
+	// Usage: tail log
+}
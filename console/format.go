@@ -0,0 +1,156 @@
+package console
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+const (
+	FormatTable = "table"
+	FormatJSON  = "json"
+)
+
+// formats the output of a command, the strings are written as is, the
+// slices of structs or maps as tables with a column per field or key, the
+// structs and maps as tables of key and value
+func Format(v interface{}, format string) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case error:
+		return v.Error()
+	}
+
+	if format == FormatJSON {
+		data, err := json.MarshalIndent(v, "", "  ")
+		if err != nil {
+			return err.Error()
+		}
+		return strings.Replace(string(data), "\n", "\r\n", -1)
+	}
+
+	rv := reflect.Indirect(reflect.ValueOf(v))
+	switch rv.Kind() {
+	case reflect.Slice, reflect.Array:
+		return formatList(rv)
+	case reflect.Struct, reflect.Map:
+		var rows [][]string
+		keys, values := fields(rv)
+		for i := range keys {
+			rows = append(rows, []string{keys[i], values[i]})
+		}
+		return formatTable(rows)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+func formatList(rv reflect.Value) string {
+	if rv.Len() == 0 {
+		return ""
+	}
+	elem := deref(rv.Index(0))
+	if elem.Kind() != reflect.Struct && elem.Kind() != reflect.Map {
+		lines := make([]string, rv.Len())
+		for i := range lines {
+			lines[i] = fmt.Sprint(rv.Index(i).Interface())
+		}
+		return strings.Join(lines, "\r\n")
+	}
+
+	// the columns of all the rows, the map rows may have different keys
+	var header []string
+	columns := make(map[string]int)
+	keys := make([][]string, rv.Len())
+	values := make([][]string, rv.Len())
+	for i := 0; i < rv.Len(); i++ {
+		keys[i], values[i] = fields(deref(rv.Index(i)))
+		for _, key := range keys[i] {
+			if _, ok := columns[key]; !ok {
+				columns[key] = len(header)
+				header = append(header, key)
+			}
+		}
+	}
+	if elem.Kind() == reflect.Map {
+		sort.Strings(header)
+		for j, key := range header {
+			columns[key] = j
+		}
+	}
+
+	rows := [][]string{header}
+	for i := range keys {
+		row := make([]string, len(header))
+		for j, key := range keys[i] {
+			row[columns[key]] = values[i][j]
+		}
+		rows = append(rows, row)
+	}
+	return formatTable(rows)
+}
+
+func deref(rv reflect.Value) reflect.Value {
+	for rv.Kind() == reflect.Ptr || rv.Kind() == reflect.Interface {
+		rv = rv.Elem()
+	}
+	return rv
+}
+
+// the exported fields of a struct or the sorted keys of a map
+func fields(rv reflect.Value) (keys []string, values []string) {
+	switch rv.Kind() {
+	case reflect.Struct:
+		t := rv.Type()
+		for i := 0; i < t.NumField(); i++ {
+			if t.Field(i).PkgPath != "" {
+				continue
+			}
+			keys = append(keys, t.Field(i).Name)
+			values = append(values, fmt.Sprint(rv.Field(i).Interface()))
+		}
+	case reflect.Map:
+		m := make(map[string]string)
+		for _, k := range rv.MapKeys() {
+			key := fmt.Sprint(k.Interface())
+			keys = append(keys, key)
+			m[key] = fmt.Sprint(rv.MapIndex(k).Interface())
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			values = append(values, m[key])
+		}
+	}
+	return
+}
+
+func formatTable(rows [][]string) string {
+	var widths []int
+	for _, row := range rows {
+		for i, cell := range row {
+			if i == len(widths) {
+				widths = append(widths, 0)
+			}
+			widths[i] = max(widths[i], len(cell))
+		}
+	}
+
+	lines := make([]string, len(rows))
+	for i, row := range rows {
+		var line string
+		for j, cell := range row {
+			if j == len(row)-1 {
+				line += cell
+			} else {
+				line += fmt.Sprintf("%-*v  ", widths[j], cell)
+			}
+		}
+		lines[i] = line
+	}
+	return strings.Join(lines, "\r\n")
+}
@@ -0,0 +1,328 @@
+package console
+
+import (
+	"fmt"
+	"github.com/name5566/leaf/log"
+	"github.com/name5566/leaf/network"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// the output of a streaming command, written to the session until the
+// command returns or is cancelled by Ctrl-C or any input
+type Stream struct {
+	conn     *network.TCPConn
+	done     chan struct{}
+	doneOnce sync.Once
+}
+
+func newStream(conn *network.TCPConn) *Stream {
+	return &Stream{conn: conn, done: make(chan struct{})}
+}
+
+func (s *Stream) cancel() {
+	s.doneOnce.Do(func() {
+		close(s.done)
+	})
+}
+
+// closed once the command is cancelled
+func (s *Stream) Done() <-chan struct{} {
+	return s.done
+}
+
+func (s *Stream) Cancelled() bool {
+	select {
+	case <-s.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// lines end with "\r\n"
+func (s *Stream) Write(b []byte) (int, error) {
+	s.conn.Write(b)
+	return len(b), nil
+}
+
+func (s *Stream) Printf(format string, a ...interface{}) {
+	s.conn.Write([]byte(fmt.Sprintf(format, a...)))
+}
+
+// a progress bar redrawn in place, ended by a new line once done
+func (s *Stream) Progress(done, total int) {
+	const width = 30
+	if total <= 0 {
+		return
+	}
+	done = min(max(done, 0), total)
+	n := done * width / total
+	s.Printf("\r[%v%v] %3d%% (%v/%v)", strings.Repeat("#", n), strings.Repeat(" ", width-n), done*100/total, done, total)
+	if done == total {
+		s.Printf("\r\n")
+	}
+}
+
+type streamer interface {
+	stream(s *Stream, args []string)
+}
+
+// f runs in its own goroutine until it returns, it must return soon once
+// s.Done() is closed, f must be goroutine safe and may reach the modules by
+// chanrpc
+// you must call the function before calling console.Init
+// goroutine not safe
+func RegisterStream(name string, help string, f func(s *Stream, args []string)) {
+	for _, c := range commands {
+		if c.name() == name {
+			log.Fatal("command %v is already registered", name)
+		}
+	}
+
+	c := new(StreamCommand)
+	c._name = name
+	c._help = help
+	c.f = f
+	commands = append(commands, c)
+}
+
+type StreamCommand struct {
+	_name string
+	_help string
+	f     func(s *Stream, args []string)
+}
+
+func (c *StreamCommand) name() string {
+	return c._name
+}
+
+func (c *StreamCommand) help() string {
+	return c._help
+}
+
+func (c *StreamCommand) run([]string) string {
+	return "streaming command"
+}
+
+func (c *StreamCommand) stream(s *Stream, args []string) {
+	c.f(s, args)
+}
+
+var (
+	watches      = make(map[string]func() interface{})
+	mutexWatches sync.Mutex
+)
+
+// f returns the rows shown by `watch name` and `top name`, formatted by
+// Format, f must be goroutine safe
+// goroutine safe
+func RegisterWatch(name string, f func() interface{}) {
+	mutexWatches.Lock()
+	defer mutexWatches.Unlock()
+
+	if _, ok := watches[name]; ok {
+		log.Fatal("watch %v is already registered", name)
+	}
+	watches[name] = f
+}
+
+func watchNames() []string {
+	mutexWatches.Lock()
+	defer mutexWatches.Unlock()
+
+	var names []string
+	for name := range watches {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func watch(name string) func() interface{} {
+	mutexWatches.Lock()
+	defer mutexWatches.Unlock()
+
+	return watches[name]
+}
+
+// watch
+type CommandWatch struct{}
+
+func (c *CommandWatch) name() string {
+	return "watch"
+}
+
+func (c *CommandWatch) help() string {
+	return "print a metric periodically, e.g. `watch conns`"
+}
+
+func (c *CommandWatch) usage() string {
+	return "Usage: watch <" + strings.Join(watchNames(), "|") + "> [interval]\r\n" +
+		"  interval - refresh interval (default 1s)"
+}
+
+func (c *CommandWatch) complete(args []string, prefix string) []string {
+	if len(args) == 0 {
+		return watchNames()
+	}
+	return nil
+}
+
+func (c *CommandWatch) run([]string) string {
+	return c.usage()
+}
+
+func (c *CommandWatch) stream(s *Stream, args []string) {
+	watchLoop(s, args, c.usage(), func(name string, output string) {
+		s.Printf("%v %v\r\n%v\r\n\r\n", time.Now().Format("15:04:05"), name, output)
+	})
+}
+
+// top
+type CommandTop struct{}
+
+func (c *CommandTop) name() string {
+	return "top"
+}
+
+func (c *CommandTop) help() string {
+	return "show a metric full screen, e.g. `top modules`"
+}
+
+func (c *CommandTop) usage() string {
+	return "Usage: top <" + strings.Join(watchNames(), "|") + "> [interval]\r\n" +
+		"  interval - refresh interval (default 1s)"
+}
+
+func (c *CommandTop) complete(args []string, prefix string) []string {
+	if len(args) == 0 {
+		return watchNames()
+	}
+	return nil
+}
+
+func (c *CommandTop) run([]string) string {
+	return c.usage()
+}
+
+func (c *CommandTop) stream(s *Stream, args []string) {
+	watchLoop(s, args, c.usage(), func(name string, output string) {
+		// clear the screen
+		s.Printf("\x1b[H\x1b[2J%v %v, Ctrl-C to quit\r\n\r\n%v\r\n", time.Now().Format("15:04:05"), name, output)
+	})
+}
+
+func watchLoop(s *Stream, args []string, usage string, print func(name string, output string)) {
+	if len(args) == 0 || len(args) > 2 {
+		s.Printf("%v\r\n", usage)
+		return
+	}
+	f := watch(args[0])
+	if f == nil {
+		s.Printf("%v not found\r\n%v\r\n", args[0], usage)
+		return
+	}
+	interval := time.Second
+	if len(args) == 2 {
+		d, err := time.ParseDuration(args[1])
+		if err != nil || d <= 0 {
+			s.Printf("invalid interval %v\r\n", args[1])
+			return
+		}
+		interval = d
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		print(args[0], Format(f(), FormatTable))
+		select {
+		case <-s.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// tail
+type CommandTail struct{}
+
+func (c *CommandTail) name() string {
+	return "tail"
+}
+
+func (c *CommandTail) help() string {
+	return "follow the log, `tail log`"
+}
+
+func (c *CommandTail) usage() string {
+	return "Usage: tail log\r\n" +
+		"  log - the lines printed by the log package"
+}
+
+func (c *CommandTail) complete(args []string, prefix string) []string {
+	if len(args) == 0 {
+		return []string{"log"}
+	}
+	return nil
+}
+
+func (c *CommandTail) run([]string) string {
+	return c.usage()
+}
+
+func (c *CommandTail) stream(s *Stream, args []string) {
+	if len(args) != 1 || args[0] != "log" {
+		s.Printf("%v\r\n", c.usage())
+		return
+	}
+
+	// the lines are batched, a slow session drops lines rather than
+	// filling its write channel
+	lines := make(chan string, 1000)
+	var dropped int
+	var mutexDropped sync.Mutex
+	untap := log.Tap(func(line string) {
+		select {
+		case lines <- time.Now().Format("2006/01/02 15:04:05 ") + line:
+		default:
+			mutexDropped.Lock()
+			dropped++
+			mutexDropped.Unlock()
+		}
+	})
+	defer untap()
+
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.Done():
+			return
+		case <-ticker.C:
+		}
+
+		var batch []string
+		for len(batch) < cap(lines) {
+			select {
+			case line := <-lines:
+				batch = append(batch, line)
+				continue
+			default:
+			}
+			break
+		}
+		mutexDropped.Lock()
+		if dropped > 0 {
+			batch = append(batch, fmt.Sprintf("... %v lines dropped", dropped))
+			dropped = 0
+		}
+		mutexDropped.Unlock()
+		if len(batch) > 0 {
+			s.Printf("%v\r\n", strings.Join(batch, "\r\n"))
+		}
+	}
+}
@@ -0,0 +1,177 @@
+package event
+
+import (
+	"fmt"
+	"github.com/name5566/leaf/recordfile"
+	"sort"
+	"time"
+)
+
+const TimeLayout = "2006-01-02 15:04:05"
+
+// a line of the event file, Start and End in TimeLayout for the global
+// events, Duration for the per-player events which start at a time of the
+// player, e.g. its registration
+//
+//	ID	Name	Start	End	Duration	Phases	Announces
+//	1	double exp	2000-01-01 00:00:00	2000-01-08 00:00:00		"[[""signup"",""0s""],[""battle"",""24h""]]"	"[""1h"",""10m""]"
+//	2	newbie			168h	[]	[]
+type Record struct {
+	ID        int
+	Name      string
+	Start     string
+	End       string
+	Duration  string
+	Phases    [][2]string // name and offset from the start, in order
+	Announces []string    // the announcements before the start
+}
+
+type Phase struct {
+	Name   string
+	Offset time.Duration
+}
+
+type Event struct {
+	ID        int
+	Name      string
+	PerPlayer bool
+	Start     time.Time // global events only
+	End       time.Time // global events only
+	Duration  time.Duration
+	Phases    []Phase
+	Announces []time.Duration
+}
+
+// loads the events of a recordfile, the times are in loc
+func Load(filename string, loc *time.Location) ([]*Event, error) {
+	rf, err := recordfile.New(Record{})
+	if err != nil {
+		return nil, err
+	}
+	err = rf.Read(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	events := make([]*Event, rf.NumRecord())
+	ids := make(map[int]bool)
+	for i := range events {
+		events[i], err = NewEvent(rf.Record(i).(*Record), loc)
+		if err != nil {
+			return nil, err
+		}
+		if ids[events[i].ID] {
+			return nil, fmt.Errorf("event %v: duplicate id", events[i].ID)
+		}
+		ids[events[i].ID] = true
+	}
+	return events, nil
+}
+
+func NewEvent(r *Record, loc *time.Location) (*Event, error) {
+	e := new(Event)
+	e.ID = r.ID
+	e.Name = r.Name
+
+	var err error
+	if r.Duration != "" {
+		if r.Start != "" || r.End != "" {
+			return nil, fmt.Errorf("event %v: a per-player event has no start and end", r.ID)
+		}
+		e.PerPlayer = true
+		e.Duration, err = time.ParseDuration(r.Duration)
+		if err != nil {
+			return nil, fmt.Errorf("event %v: invalid duration %v", r.ID, r.Duration)
+		}
+	} else {
+		e.Start, err = time.ParseInLocation(TimeLayout, r.Start, loc)
+		if err != nil {
+			return nil, fmt.Errorf("event %v: invalid start %v", r.ID, r.Start)
+		}
+		e.End, err = time.ParseInLocation(TimeLayout, r.End, loc)
+		if err != nil {
+			return nil, fmt.Errorf("event %v: invalid end %v", r.ID, r.End)
+		}
+		e.Duration = e.End.Sub(e.Start)
+	}
+	if e.Duration <= 0 {
+		return nil, fmt.Errorf("event %v: the event ends before it starts", r.ID)
+	}
+
+	for _, p := range r.Phases {
+		offset, err := time.ParseDuration(p[1])
+		if err != nil {
+			return nil, fmt.Errorf("event %v: invalid offset %v of phase %v", r.ID, p[1], p[0])
+		}
+		if offset < 0 || offset >= e.Duration {
+			return nil, fmt.Errorf("event %v: phase %v out of the event", r.ID, p[0])
+		}
+		if n := len(e.Phases); n > 0 && offset <= e.Phases[n-1].Offset {
+			return nil, fmt.Errorf("event %v: phase %v not in order", r.ID, p[0])
+		}
+		e.Phases = append(e.Phases, Phase{Name: p[0], Offset: offset})
+	}
+
+	for _, a := range r.Announces {
+		d, err := time.ParseDuration(a)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("event %v: invalid announce %v", r.ID, a)
+		}
+		e.Announces = append(e.Announces, d)
+	}
+
+	return e, nil
+}
+
+// the phase at t of the event started at start, "" if none
+func (e *Event) phase(start time.Time, t time.Time) string {
+	name := ""
+	for _, p := range e.Phases {
+		if t.Before(start.Add(p.Offset)) {
+			break
+		}
+		name = p.Name
+	}
+	return name
+}
+
+const (
+	NoticeAnnounce = iota
+	NoticeStart
+	NoticePhase
+	NoticeEnd
+)
+
+// sent to the subscribers, Player is nil for the global events
+type Notice struct {
+	Kind   int
+	Event  *Event
+	Phase  string // NoticePhase only
+	At     time.Time
+	Player interface{}
+}
+
+// the notices of an event started at start, in order
+func (e *Event) notices(start time.Time, player interface{}) []*Notice {
+	var notices []*Notice
+	for _, a := range e.Announces {
+		notices = append(notices, &Notice{Kind: NoticeAnnounce, At: start.Add(-a)})
+	}
+	notices = append(notices, &Notice{Kind: NoticeStart, At: start})
+	for _, p := range e.Phases {
+		notices = append(notices, &Notice{Kind: NoticePhase, Phase: p.Name, At: start.Add(p.Offset)})
+	}
+	notices = append(notices, &Notice{Kind: NoticeEnd, At: start.Add(e.Duration)})
+
+	for _, n := range notices {
+		n.Event = e
+		n.Player = player
+	}
+	sort.SliceStable(notices, func(i, j int) bool {
+		if notices[i].At.Equal(notices[j].At) {
+			return notices[i].Kind < notices[j].Kind
+		}
+		return notices[i].At.Before(notices[j].At)
+	})
+	return notices
+}
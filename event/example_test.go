@@ -0,0 +1,72 @@
+package event_test
+
+import (
+	"fmt"
+	"github.com/name5566/leaf/chanrpc"
+	"github.com/name5566/leaf/event"
+	"time"
+)
+
+func Example() {
+	events, err := event.Load("test.txt", time.UTC)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	// a module
+	server := chanrpc.NewServer(10)
+	server.Register("event", func(args []interface{}) {
+		n := args[0].(*event.Notice)
+		switch n.Kind {
+		case event.NoticeAnnounce:
+			fmt.Println(n.Event.Name, "starts soon")
+		case event.NoticeStart:
+			fmt.Println(n.Event.Name, "starts")
+		case event.NoticePhase:
+			fmt.Println(n.Event.Name, "phase", n.Phase)
+		case event.NoticeEnd:
+			fmt.Println(n.Event.Name, "ends")
+		}
+	})
+
+	now := time.Date(2000, 1, 1, 18, 0, 0, 0, time.UTC)
+	s := event.NewScheduler(events)
+	s.Now = func() time.Time { return now }
+	s.Subscribe(server, "event")
+	s.Start(nil)
+
+	dispatch := func() {
+		for len(server.ChanCall) > 0 {
+			server.Exec(<-server.ChanCall)
+		}
+	}
+
+	fmt.Println(len(s.Current()), len(s.Upcoming(3*time.Hour)))
+
+	now = now.Add(3 * time.Hour)
+	s.Tick()
+	dispatch()
+	for _, st := range s.Current() {
+		fmt.Println(st.Name, st.State, st.Phase)
+	}
+
+	now = now.Add(24 * time.Hour)
+	s.Tick()
+	dispatch()
+
+	// per-player events
+	for _, st := range s.PlayerEvents(now.Add(-time.Hour)) {
+		fmt.Println(st.Name, st.End)
+	}
+
+	// Output:
+	// 0 1
+	// double exp starts soon
+	// double exp starts
+	// double exp phase signup
+	// double exp phase battle
+	// double exp active battle
+	// double exp ends
+	// newbie 2000-01-09 20:00:00 +0000 UTC
+}
@@ -0,0 +1,264 @@
+package event
+
+import (
+	"github.com/name5566/leaf/chanrpc"
+	"github.com/name5566/leaf/console"
+	"github.com/name5566/leaf/log"
+	"github.com/name5566/leaf/timer"
+	"sort"
+	"time"
+)
+
+// *timer.Dispatcher and *module.Skeleton
+type Timers interface {
+	AfterFunc(d time.Duration, cb func()) *timer.Timer
+}
+
+type subscriber struct {
+	server *chanrpc.Server
+	id     interface{}
+}
+
+// the notices left of an event, for all players or one
+type track struct {
+	event   *Event
+	start   time.Time
+	player  interface{}
+	notices []*Notice
+}
+
+// schedules the notices of the events on the timers of a module and sends
+// them to the subscribers
+// one scheduler per goroutine (goroutine not safe)
+type Scheduler struct {
+	// time.Now if nil, overridden in tests
+	Now func() time.Time
+
+	events      []*Event
+	subscribers []subscriber
+	tracks      []*track
+	players     map[interface{}][]*track
+	timers      Timers
+	t           *timer.Timer
+}
+
+func NewScheduler(events []*Event) *Scheduler {
+	s := new(Scheduler)
+	s.events = events
+	s.players = make(map[interface{}][]*track)
+	return s
+}
+
+func (s *Scheduler) now() time.Time {
+	if s.Now != nil {
+		return s.Now()
+	}
+	return time.Now()
+}
+
+func (s *Scheduler) Event(id int) *Event {
+	for _, e := range s.events {
+		if e.ID == id {
+			return e
+		}
+	}
+	return nil
+}
+
+// the notices are sent by server.Go(id, notice), notice is a *Notice
+// you must call the function before calling Start
+func (s *Scheduler) Subscribe(server *chanrpc.Server, id interface{}) {
+	s.subscribers = append(s.subscribers, subscriber{server, id})
+}
+
+// the notices before now are not sent, Current tells the events going on
+func (s *Scheduler) Start(timers Timers) {
+	s.timers = timers
+	now := s.now()
+	for _, e := range s.events {
+		if !e.PerPlayer {
+			s.add(e, e.Start, nil, now)
+		}
+	}
+	s.Tick()
+}
+
+func (s *Scheduler) Stop() {
+	if s.t != nil {
+		s.t.Stop()
+		s.t = nil
+	}
+	s.timers = nil
+}
+
+func (s *Scheduler) add(e *Event, start time.Time, player interface{}, now time.Time) *track {
+	t := &track{event: e, start: start, player: player}
+	for _, n := range e.notices(start, player) {
+		if n.At.After(now) {
+			t.notices = append(t.notices, n)
+		}
+	}
+	if len(t.notices) > 0 {
+		s.tracks = append(s.tracks, t)
+	}
+	return t
+}
+
+// schedules the notices of the per-player events of an online player, the
+// events start at start, e.g. the registration of the player
+func (s *Scheduler) WatchPlayer(player interface{}, start time.Time) {
+	s.UnwatchPlayer(player)
+	now := s.now()
+	for _, e := range s.events {
+		if e.PerPlayer {
+			s.players[player] = append(s.players[player], s.add(e, start, player, now))
+		}
+	}
+	s.Tick()
+}
+
+func (s *Scheduler) UnwatchPlayer(player interface{}) {
+	for _, t := range s.players[player] {
+		t.notices = nil
+	}
+	delete(s.players, player)
+}
+
+// sends the notices due and schedules the next one, called by the timer,
+// call it after changing Now in tests
+func (s *Scheduler) Tick() {
+	now := s.now()
+
+	var due []*Notice
+	tracks := s.tracks[:0]
+	for _, t := range s.tracks {
+		for len(t.notices) > 0 && !t.notices[0].At.After(now) {
+			due = append(due, t.notices[0])
+			t.notices = t.notices[1:]
+		}
+		if len(t.notices) > 0 {
+			tracks = append(tracks, t)
+		}
+	}
+	for i := len(tracks); i < len(s.tracks); i++ {
+		s.tracks[i] = nil
+	}
+	s.tracks = tracks
+
+	sort.SliceStable(due, func(i, j int) bool {
+		return due[i].At.Before(due[j].At)
+	})
+	for _, n := range due {
+		s.notify(n)
+	}
+
+	s.schedule(now)
+}
+
+func (s *Scheduler) notify(n *Notice) {
+	for _, sub := range s.subscribers {
+		sub.server.Go(sub.id, n)
+	}
+}
+
+func (s *Scheduler) schedule(now time.Time) {
+	if s.t != nil {
+		s.t.Stop()
+		s.t = nil
+	}
+	if s.timers == nil || len(s.tracks) == 0 {
+		return
+	}
+
+	next := s.tracks[0].notices[0].At
+	for _, t := range s.tracks[1:] {
+		if t.notices[0].At.Before(next) {
+			next = t.notices[0].At
+		}
+	}
+	s.t = s.timers.AfterFunc(next.Sub(now), func() {
+		s.t = nil
+		s.Tick()
+	})
+}
+
+// an event for the clients and the console
+type Status struct {
+	ID    int
+	Name  string
+	State string // "active" or "upcoming"
+	Phase string
+	Start time.Time
+	End   time.Time
+}
+
+func (s *Scheduler) status(e *Event, start time.Time, now time.Time) *Status {
+	st := &Status{ID: e.ID, Name: e.Name, Start: start, End: start.Add(e.Duration)}
+	if now.Before(start) {
+		st.State = "upcoming"
+	} else {
+		st.State = "active"
+		st.Phase = e.phase(start, now)
+	}
+	return st
+}
+
+// the global events going on, by start time
+func (s *Scheduler) Current() []*Status {
+	return s.list(0)
+}
+
+// the global events going on or starting within d, by start time
+func (s *Scheduler) Upcoming(d time.Duration) []*Status {
+	return s.list(d)
+}
+
+func (s *Scheduler) list(d time.Duration) []*Status {
+	now := s.now()
+	var list []*Status
+	for _, e := range s.events {
+		if e.PerPlayer || !now.Before(e.End) || e.Start.After(now.Add(d)) {
+			continue
+		}
+		list = append(list, s.status(e, e.Start, now))
+	}
+	sort.SliceStable(list, func(i, j int) bool {
+		return list[i].Start.Before(list[j].Start)
+	})
+	return list
+}
+
+// the per-player events going on for a player whose events start at start
+func (s *Scheduler) PlayerEvents(start time.Time) []*Status {
+	now := s.now()
+	var list []*Status
+	for _, e := range s.events {
+		if !e.PerPlayer || now.Before(start) || !now.Before(start.Add(e.Duration)) {
+			continue
+		}
+		list = append(list, s.status(e, start, now))
+	}
+	return list
+}
+
+// registers the console command name listing the events, server must run
+// on the goroutine of the scheduler, e.g. the ChanRPCServer of the module
+// you must call the function before calling console.Init
+// goroutine not safe
+func (s *Scheduler) RegisterCommand(name string, server *chanrpc.Server) {
+	if server == nil {
+		log.Fatal("invalid server")
+	}
+	console.RegisterArgs(name, "list the events going on and upcoming", console.Args{
+		Flags: []*console.Arg{
+			{Name: "within", Kind: console.ArgDuration, Help: "the upcoming events starting within", Default: 24 * time.Hour},
+		},
+	}, func(args []interface{}) interface{} {
+		values := args[0].(console.Values)
+		list := s.Upcoming(values.Duration("within"))
+		if len(list) == 0 {
+			return "no event"
+		}
+		return list
+	}, server)
+}
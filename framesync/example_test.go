@@ -0,0 +1,161 @@
+package framesync_test
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/name5566/leaf/chanrpc"
+	"github.com/name5566/leaf/framesync"
+	"github.com/name5566/leaf/log"
+	"github.com/name5566/leaf/module"
+	"time"
+)
+
+func init() {
+	// keep the output of the examples clean
+	logger, _ := log.New("fatal", "", 0)
+	log.Export(logger)
+}
+
+func Example() {
+	r := new(framesync.Room)
+	r.FrameRate = 10
+	r.Send = func(seat int, frames []*framesync.Frame) {
+		for _, f := range frames {
+			fmt.Printf("seat %v: frame %v, %v inputs\n", seat, f.Index, len(f.Inputs))
+		}
+	}
+
+	r.LatePolicy = framesync.LateNext
+	r.Join(0)
+	r.Input(0, 0, []byte("move"))
+	r.Step()
+
+	// late input, the buffer of the caller is reused
+	input := []byte("jump")
+	r.Input(0, 0, input)
+	copy(input, "xxxx")
+	r.Step()
+
+	// reconnect, no input while offline
+	r.Leave(0)
+	r.Input(0, 2, []byte("fire"))
+	r.Step()
+	r.Step()
+	r.Rejoin(0, 2)
+	r.Step()
+
+	// replay
+	var buf bytes.Buffer
+	r.Replay().Save(&buf)
+	replay, _ := framesync.LoadReplay(&buf)
+	fmt.Println(len(replay.Frames), string(replay.Frames[1].Inputs[0].Data))
+
+	// Output:
+	// seat 0: frame 0, 1 inputs
+	// seat 0: frame 1, 1 inputs
+	// seat 0: frame 2, 0 inputs
+	// seat 0: frame 3, 0 inputs
+	// seat 0: frame 4, 0 inputs
+	// 5 jump
+}
+
+func ExampleRoom_MaxInputs() {
+	r := new(framesync.Room)
+	r.MaxInputs = 2
+	r.Send = func(seat int, frames []*framesync.Frame) {
+		if seat != 0 {
+			return
+		}
+		for _, f := range frames {
+			for _, in := range f.Inputs {
+				fmt.Printf("seat %v: %s\n", in.Seat, in.Data)
+			}
+		}
+	}
+	r.Join(0)
+	r.Join(1)
+
+	// the last inputs of a seat are kept
+	r.Input(0, 0, []byte("a"))
+	r.Input(1, 0, []byte("x"))
+	r.Input(0, 0, []byte("b"))
+	r.Input(0, 0, []byte("c"))
+	r.Step()
+
+	// Output:
+	// seat 1: x
+	// seat 0: b
+	// seat 0: c
+}
+
+func ExampleRoom_History() {
+	r := new(framesync.Room)
+	r.History = 3
+	r.Send = func(seat int, frames []*framesync.Frame) {
+		fmt.Printf("seat %v: frames %v to %v\n", seat, frames[0].Index, frames[len(frames)-1].Index)
+	}
+	for i := 0; i < 5; i++ {
+		r.Step()
+	}
+	fmt.Println(len(r.Replay().Frames), r.Replay().Frames[0].Index)
+
+	// the frames dropped are not sent
+	r.Join(0)
+	r.Step()
+
+	// Output:
+	// 3 2
+	// seat 0: frames 3 to 5
+}
+
+func ExampleRoom_Start() {
+	s := &module.Skeleton{
+		TimerDispatcherLen: 10,
+		ChanRPCServer:      chanrpc.NewServer(10),
+	}
+	s.Init()
+	closeSig := make(chan bool, 1)
+	done := make(chan struct{})
+	go func() {
+		s.Run(closeSig)
+		close(done)
+	}()
+
+	r := new(framesync.Room)
+	r.FrameRate = 100
+	frames := make(chan uint32, 100)
+	r.Send = func(seat int, fs []*framesync.Frame) {
+		for _, f := range fs {
+			frames <- f.Index
+		}
+	}
+	s.RegisterChanRPC("start", func(args []interface{}) {
+		r.Join(0)
+		r.Start(s)
+	})
+	s.RegisterChanRPC("stop", func(args []interface{}) interface{} {
+		r.Stop()
+		return r.Current()
+	})
+
+	s.ChanRPCServer.Go("start")
+	fmt.Println(<-frames, <-frames)
+	current, _ := s.ChanRPCServer.Call1("stop")
+	time.Sleep(50 * time.Millisecond)
+
+	// restarted once stopped
+	s.ChanRPCServer.Go("start")
+	for i := range frames {
+		if i == current.(uint32) {
+			break
+		}
+	}
+	fmt.Println(<-frames == current.(uint32)+1)
+
+	closeSig <- true
+	<-done
+
+	// Output:
+	// 0 1
+	// true
+}
@@ -0,0 +1,31 @@
+package framesync
+
+import (
+	"encoding/json"
+	"io"
+)
+
+type Replay struct {
+	FrameRate int
+	Frames    []*Frame
+}
+
+func (r *Replay) Save(w io.Writer) error {
+	return json.NewEncoder(w).Encode(r)
+}
+
+func LoadReplay(rd io.Reader) (*Replay, error) {
+	r := new(Replay)
+	err := json.NewDecoder(rd).Decode(r)
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// calls f for every frame in order
+func (r *Replay) Range(f func(frame *Frame)) {
+	for _, frame := range r.Frames {
+		f(frame)
+	}
+}
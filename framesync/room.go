@@ -0,0 +1,226 @@
+package framesync
+
+import (
+	"github.com/name5566/leaf/log"
+	"github.com/name5566/leaf/module"
+	"time"
+)
+
+// late-input policies, an input is late if its frame is already sealed
+const (
+	LateDrop = iota // drop the input
+	LateNext        // apply the input to the current frame
+)
+
+type Input struct {
+	Seat int
+	Data []byte
+}
+
+type Frame struct {
+	Index  uint32
+	Inputs []Input
+}
+
+type seat struct {
+	online bool
+	// the index of the next frame to send
+	next uint32
+}
+
+// one room per goroutine (goroutine not safe)
+type Room struct {
+	FrameRate  int
+	LatePolicy int
+	// inputs for frames too far ahead are dropped
+	MaxAhead uint32
+	// max frames per Send on catch-up
+	CatchUpBatch int
+	// inputs kept per seat per frame, the oldest are dropped beyond
+	MaxInputs int
+	// the frames are kept for the catch-up and the replay, the whole
+	// battle by default, History > 0 keeps the last History frames only,
+	// the players missing older frames then never catch up
+	History int
+	// sends the frames in order
+	Send func(seat int, frames []*Frame)
+
+	seats   map[int]*seat
+	frames  []*Frame
+	first   uint32 // the index of frames[0]
+	current uint32
+	pending map[uint32][]Input
+	started bool
+	gen     int
+}
+
+func (r *Room) init() {
+	if r.FrameRate <= 0 {
+		r.FrameRate = 15
+		log.Release("invalid FrameRate, reset to %v", r.FrameRate)
+	}
+	if r.MaxAhead == 0 {
+		r.MaxAhead = uint32(r.FrameRate)
+	}
+	if r.CatchUpBatch <= 0 {
+		r.CatchUpBatch = 64
+	}
+	if r.MaxInputs <= 0 {
+		r.MaxInputs = 8
+	}
+	if r.Send == nil {
+		log.Fatal("Send must not be nil")
+	}
+	if r.seats == nil {
+		r.seats = make(map[int]*seat)
+	}
+	if r.pending == nil {
+		r.pending = make(map[uint32][]Input)
+	}
+}
+
+// the index of the frame collecting the inputs
+func (r *Room) Current() uint32 {
+	return r.current
+}
+
+// the player takes the seat and receives the frames from the first one kept
+func (r *Room) Join(s int) {
+	r.Rejoin(s, r.first)
+}
+
+// the player reconnects, the frames are resent from next, the first
+// frame the player missed
+func (r *Room) Rejoin(s int, next uint32) {
+	r.init()
+	if next > r.current {
+		next = r.current
+	}
+	if next < r.first {
+		log.Error("seat %v rejoins at frame %v, the frames before %v are dropped", s, next, r.first)
+		next = r.first
+	}
+	r.seats[s] = &seat{online: true, next: next}
+}
+
+// the player disconnects, the seat keeps its inputs history
+func (r *Room) Leave(s int) {
+	if st, ok := r.seats[s]; ok {
+		st.online = false
+	}
+}
+
+// the inputs of the seats offline or not taken are dropped, data is copied
+func (r *Room) Input(s int, frame uint32, data []byte) {
+	r.init()
+	if st, ok := r.seats[s]; !ok || !st.online {
+		return
+	}
+
+	if frame < r.current {
+		if r.LatePolicy != LateNext {
+			return
+		}
+		frame = r.current
+	}
+	if frame > r.current+r.MaxAhead {
+		return
+	}
+	inputs := r.pending[frame]
+	n := 0
+	for _, in := range inputs {
+		if in.Seat == s {
+			n++
+		}
+	}
+	if n >= r.MaxInputs {
+		for i, in := range inputs {
+			if in.Seat == s {
+				inputs = append(inputs[:i], inputs[i+1:]...)
+				break
+			}
+		}
+	}
+	r.pending[frame] = append(inputs, Input{Seat: s, Data: append([]byte(nil), data...)})
+}
+
+// seals the current frame and broadcasts it
+func (r *Room) Step() {
+	r.init()
+
+	f := &Frame{Index: r.current, Inputs: r.pending[r.current]}
+	delete(r.pending, r.current)
+	r.frames = append(r.frames, f)
+	r.current++
+	if r.History > 0 && len(r.frames) > r.History {
+		n := len(r.frames) - r.History
+		r.frames = append(r.frames[:0:0], r.frames[n:]...)
+		r.first += uint32(n)
+	}
+
+	for s, st := range r.seats {
+		if !st.online {
+			continue
+		}
+		r.flush(s, st)
+	}
+}
+
+func (r *Room) flush(s int, st *seat) {
+	// the frames dropped by History are skipped
+	if st.next < r.first {
+		st.next = r.first
+	}
+	for st.next < r.current {
+		i := int(st.next - r.first)
+		j := i + r.CatchUpBatch
+		if j > len(r.frames) {
+			j = len(r.frames)
+		}
+		r.Send(s, r.frames[i:j])
+		st.next = r.frames[j-1].Index + 1
+	}
+}
+
+// steps at FrameRate on the skeleton goroutine until Stop is called, the
+// room may be started again once stopped
+func (r *Room) Start(skeleton *module.Skeleton) {
+	r.init()
+	if r.started {
+		return
+	}
+	r.started = true
+	r.gen++
+	gen := r.gen
+
+	d := time.Second / time.Duration(r.FrameRate)
+	next := time.Now().Add(d)
+	var loop func()
+	loop = func() {
+		if r.gen != gen {
+			return
+		}
+		r.Step()
+
+		// keeps the frame rate steady
+		next = next.Add(d)
+		wait := time.Until(next)
+		if wait < 0 {
+			wait = 0
+		}
+		skeleton.AfterFunc(wait, loop)
+	}
+	skeleton.AfterFunc(d, loop)
+}
+
+func (r *Room) Stop() {
+	if r.started {
+		r.started = false
+		r.gen++
+	}
+}
+
+// the input history of the room, the last History frames if History is set
+func (r *Room) Replay() *Replay {
+	return &Replay{FrameRate: r.FrameRate, Frames: r.frames}
+}
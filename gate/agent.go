@@ -1,15 +1,48 @@
 package gate
 
 import (
+	"github.com/name5566/leaf/network"
 	"net"
+	"time"
 )
 
+const (
+	TransportTCP  = "tcp"
+	TransportWS   = "ws"
+	TransportLP   = "lp"
+	TransportQUIC = "quic"
+)
+
+// implemented by the agents of Gate
+//
+// incompatible change: ID, WriteRaw, WriteDatagram, DatagramToken, Attrs and
+// Info were added to the methods of the original Agent, the implementations
+// and the mocks outside this package no longer compile until they add them,
+// embedding an Agent keeps them compiling as the interface grows
 type Agent interface {
+	ID() uint64
 	WriteMsg(msg interface{})
+	// buf holds the preframed data of a message, see Processor.PackRaw
+	WriteRaw(buf *network.Buffer)
+	// unreliable, requires Gate.UDPAddr
+	WriteDatagram(msg interface{})
+	DatagramToken() []byte
 	LocalAddr() net.Addr
 	RemoteAddr() net.Addr
 	Close()
 	Destroy()
 	UserData() interface{}
 	SetUserData(data interface{})
+	Attrs() *Attrs
+	Info() AgentInfo
+}
+
+// a snapshot of the connection facts of an agent
+type AgentInfo struct {
+	ConnectTime time.Time
+	Transport   string
+	TLS         bool
+	BytesIn     uint64
+	BytesOut    uint64
+	Processor   network.Processor
 }
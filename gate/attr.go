@@ -0,0 +1,112 @@
+package gate
+
+import (
+	"sync"
+	"time"
+)
+
+// keys are compared by identity, so two packages using the same name
+// never share an attribute
+type AttrKey struct {
+	name string
+}
+
+func NewAttrKey(name string) *AttrKey {
+	return &AttrKey{name: name}
+}
+
+func (k *AttrKey) String() string {
+	return k.name
+}
+
+// goroutine safe
+type Attrs struct {
+	mutex sync.RWMutex
+	m     map[*AttrKey]interface{}
+}
+
+func (a *Attrs) Lookup(key *AttrKey) (interface{}, bool) {
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+	v, ok := a.m[key]
+	return v, ok
+}
+
+func (a *Attrs) Get(key *AttrKey) interface{} {
+	v, _ := a.Lookup(key)
+	return v
+}
+
+func (a *Attrs) Set(key *AttrKey, value interface{}) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	if a.m == nil {
+		a.m = make(map[*AttrKey]interface{})
+	}
+	a.m[key] = value
+}
+
+// returns the existing value, or sets value and returns nil
+func (a *Attrs) TestAndSet(key *AttrKey, value interface{}) interface{} {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	if v, ok := a.m[key]; ok {
+		return v
+	}
+	if a.m == nil {
+		a.m = make(map[*AttrKey]interface{})
+	}
+	a.m[key] = value
+	return nil
+}
+
+func (a *Attrs) Del(key *AttrKey) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	delete(a.m, key)
+}
+
+func (a *Attrs) Len() int {
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+	return len(a.m)
+}
+
+// f must not call the methods of a
+func (a *Attrs) Range(f func(key *AttrKey, value interface{})) {
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+	for k, v := range a.m {
+		f(k, v)
+	}
+}
+
+func (a *Attrs) String(key *AttrKey) string {
+	v, _ := a.Get(key).(string)
+	return v
+}
+
+func (a *Attrs) Int(key *AttrKey) int {
+	v, _ := a.Get(key).(int)
+	return v
+}
+
+func (a *Attrs) Int64(key *AttrKey) int64 {
+	v, _ := a.Get(key).(int64)
+	return v
+}
+
+func (a *Attrs) Bool(key *AttrKey) bool {
+	v, _ := a.Get(key).(bool)
+	return v
+}
+
+func (a *Attrs) Duration(key *AttrKey) time.Duration {
+	v, _ := a.Get(key).(time.Duration)
+	return v
+}
+
+func (a *Attrs) Time(key *AttrKey) time.Time {
+	v, _ := a.Get(key).(time.Time)
+	return v
+}
@@ -0,0 +1,261 @@
+package gate
+
+import (
+	"fmt"
+	"github.com/name5566/leaf/chanrpc"
+	"github.com/name5566/leaf/console"
+	"github.com/name5566/leaf/log"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// you must call the function before calling console.Init
+// goroutine not safe
+func (gate *Gate) RegisterCommands() {
+	if gate.commandServer != nil {
+		log.Fatal("gate commands are already registered")
+	}
+	gate.commandServer = chanrpc.NewServer(0)
+
+	console.Register("agents", "list the connected agents, try `agents help` for usage", gate.commandAgents, gate.commandServer)
+	console.Register("agent", "show the details of an agent", gate.commandAgent, gate.commandServer)
+	console.Register("kick", "kick an agent with a reason", gate.commandKick, gate.commandServer)
+	console.Register("sysmsg", "send a system message to an agent or all agents", gate.commandSysMsg, gate.commandServer)
+	console.Register("ban", "ban an IP range for a period", gate.commandBan, gate.commandServer)
+	console.Register("unban", "lift a ban", gate.commandUnban, gate.commandServer)
+	console.Register("maintenance", "maintenance mode, try `maintenance` for usage", gate.commandMaintenance, gate.commandServer)
+	console.RegisterWatch("conns", gate.watchConns)
+}
+
+type connStat struct {
+	Agents   int
+	Queued   int
+	BytesIn  uint64
+	BytesOut uint64
+}
+
+// goroutine safe
+func (gate *Gate) watchConns() interface{} {
+	stat := connStat{Queued: gate.QueueNum()}
+	gate.RangeAgents(func(a Agent) bool {
+		info := a.Info()
+		stat.Agents++
+		stat.BytesIn += info.BytesIn
+		stat.BytesOut += info.BytesOut
+		return true
+	})
+	return stat
+}
+
+func stringArgs(args []interface{}) []string {
+	s := make([]string, len(args))
+	for i, arg := range args {
+		s[i] = arg.(string)
+	}
+	return s
+}
+
+func (gate *Gate) agentByArg(arg string) (Agent, error) {
+	id, err := strconv.ParseUint(arg, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid agent id: %v", arg)
+	}
+	a := gate.Agent(id)
+	if a == nil {
+		return nil, fmt.Errorf("agent %v not found", id)
+	}
+	return a, nil
+}
+
+func agentUserID(a Agent) string {
+	if v, ok := a.Attrs().Lookup(AttrUserID); ok {
+		return fmt.Sprint(v)
+	}
+	return "-"
+}
+
+func usageAgents() string {
+	return "Usage: agents [ip=<ip|cidr>] [user=<id>] [transport=<transport>]\r\n" +
+		"  ip        - only agents connected from the address or range\r\n" +
+		"  user      - only agents logged in as the user\r\n" +
+		"  transport - only agents using the transport (tcp, ws, lp, quic)"
+}
+
+func (gate *Gate) commandAgents(_args []interface{}) interface{} {
+	var (
+		ipNet     *net.IPNet
+		userID    string
+		transport string
+	)
+	for _, arg := range stringArgs(_args) {
+		kv := strings.SplitN(arg, "=", 2)
+		if len(kv) != 2 {
+			return usageAgents()
+		}
+		switch kv[0] {
+		case "ip":
+			var err error
+			ipNet, err = parseIPNet(kv[1])
+			if err != nil {
+				return err.Error()
+			}
+		case "user":
+			userID = kv[1]
+		case "transport":
+			transport = kv[1]
+		default:
+			return usageAgents()
+		}
+	}
+
+	now := time.Now()
+	output := fmt.Sprintf("%-8v %-24v %-9v %-12v %v", "ID", "ADDR", "TRANSPORT", "USER", "ONLINE")
+	n := 0
+	gate.RangeAgents(func(a Agent) bool {
+		info := a.Info()
+		user := agentUserID(a)
+		if ipNet != nil {
+			if ip := addrIP(a.RemoteAddr()); ip == nil || !ipNet.Contains(ip) {
+				return true
+			}
+		}
+		if userID != "" && user != userID {
+			return true
+		}
+		if transport != "" && info.Transport != transport {
+			return true
+		}
+
+		output += fmt.Sprintf("\r\n%-8v %-24v %-9v %-12v %v",
+			a.ID(), a.RemoteAddr(), info.Transport, user, now.Sub(info.ConnectTime)/time.Second*time.Second)
+		n++
+		return true
+	})
+	output += fmt.Sprintf("\r\n%v of %v agents", n, gate.AgentNum())
+
+	return output
+}
+
+func (gate *Gate) commandAgent(_args []interface{}) interface{} {
+	args := stringArgs(_args)
+	if len(args) != 1 {
+		return "Usage: agent <id>"
+	}
+	a, err := gate.agentByArg(args[0])
+	if err != nil {
+		return err.Error()
+	}
+
+	info := a.Info()
+	output := fmt.Sprintf("id:          %v\r\n", a.ID())
+	output += fmt.Sprintf("remote addr: %v\r\n", a.RemoteAddr())
+	output += fmt.Sprintf("local addr:  %v\r\n", a.LocalAddr())
+	output += fmt.Sprintf("transport:   %v\r\n", info.Transport)
+	output += fmt.Sprintf("tls:         %v\r\n", info.TLS)
+	output += fmt.Sprintf("connected:   %v (%v)\r\n", info.ConnectTime.Format("2006-01-02 15:04:05"),
+		time.Since(info.ConnectTime)/time.Second*time.Second)
+	output += fmt.Sprintf("bytes in:    %v\r\n", info.BytesIn)
+	output += fmt.Sprintf("bytes out:   %v\r\n", info.BytesOut)
+	output += fmt.Sprintf("user:        %v", agentUserID(a))
+
+	var attrs []string
+	a.Attrs().Range(func(key *AttrKey, value interface{}) {
+		attrs = append(attrs, fmt.Sprintf("  %v = %v", key, value))
+	})
+	sort.Strings(attrs)
+	if len(attrs) > 0 {
+		output += "\r\nattrs:\r\n" + strings.Join(attrs, "\r\n")
+	}
+
+	return output
+}
+
+func (gate *Gate) commandKick(_args []interface{}) interface{} {
+	args := stringArgs(_args)
+	if len(args) == 0 {
+		return "Usage: kick <id> [reason]"
+	}
+	a, err := gate.agentByArg(args[0])
+	if err != nil {
+		return err.Error()
+	}
+
+	reason := strings.Join(args[1:], " ")
+	gate.Kick(a, reason)
+	log.Release("agent %v (%v) kicked from console: %v", a.ID(), a.RemoteAddr(), reason)
+	return ""
+}
+
+func (gate *Gate) commandSysMsg(_args []interface{}) interface{} {
+	args := stringArgs(_args)
+	if len(args) < 2 {
+		return "Usage: sysmsg <id|all> <text>"
+	}
+	if gate.SystemMsg == nil {
+		return "SystemMsg of the gate is not set"
+	}
+
+	text := strings.Join(args[1:], " ")
+	if args[0] == "all" {
+		gate.Broadcast(text)
+		return ""
+	}
+	a, err := gate.agentByArg(args[0])
+	if err != nil {
+		return err.Error()
+	}
+	gate.Notify(a, text)
+	return ""
+}
+
+func (gate *Gate) commandBan(_args []interface{}) interface{} {
+	args := stringArgs(_args)
+	if len(args) == 0 {
+		gate.mutexBans.Lock()
+		defer gate.mutexBans.Unlock()
+
+		gate.unsafePruneBans()
+		output := "Usage: ban <ip|cidr> <duration|forever>"
+		for _, b := range gate.bans {
+			if b.deadline.IsZero() {
+				output += fmt.Sprintf("\r\n%v forever", b.ipNet)
+			} else {
+				output += fmt.Sprintf("\r\n%v until %v", b.ipNet, b.deadline.Format("2006-01-02 15:04:05"))
+			}
+		}
+		return output
+	}
+	if len(args) != 2 {
+		return "Usage: ban <ip|cidr> <duration|forever>"
+	}
+
+	var d time.Duration
+	if args[1] != "forever" {
+		var err error
+		d, err = time.ParseDuration(args[1])
+		if err != nil || d <= 0 {
+			return "invalid duration: " + args[1]
+		}
+	}
+	err := gate.Ban(args[0], d)
+	if err != nil {
+		return err.Error()
+	}
+	log.Release("%v banned from console for %v", args[0], args[1])
+	return ""
+}
+
+func (gate *Gate) commandUnban(_args []interface{}) interface{} {
+	args := stringArgs(_args)
+	if len(args) != 1 {
+		return "Usage: unban <ip|cidr>"
+	}
+	err := gate.Unban(args[0])
+	if err != nil {
+		return err.Error()
+	}
+	return ""
+}
@@ -0,0 +1,151 @@
+package gate
+
+import (
+	"crypto/rand"
+	"github.com/name5566/leaf/log"
+	"github.com/name5566/leaf/network"
+	"net"
+	"reflect"
+	"sync/atomic"
+)
+
+// the datagrams waiting to be handled by an agent, the others are dropped
+const datagramQueueLen = 64
+
+// issues the datagram token of the agent, the game logic sends it to the
+// client over the reliable connection, nil once the agent is closed
+func (a *agent) DatagramToken() []byte {
+	a.gate.mutexDatagram.Lock()
+	defer a.gate.mutexDatagram.Unlock()
+	if a.datagramClosed {
+		return nil
+	}
+	if a.datagramToken != "" {
+		return []byte(a.datagramToken)
+	}
+
+	token := make([]byte, network.DatagramTokenLen)
+	if _, err := rand.Read(token); err != nil {
+		log.Error("datagram token error: %v", err)
+		return nil
+	}
+	if a.gate.datagramAgents == nil {
+		a.gate.datagramAgents = make(map[string]*agent)
+	}
+	a.datagramToken = string(token)
+	a.gate.datagramAgents[a.datagramToken] = a
+	a.datagrams = make(chan []byte, datagramQueueLen)
+	go a.runDatagrams(a.datagrams)
+	return token
+}
+
+func (gate *Gate) releaseDatagramToken(a *agent) {
+	gate.mutexDatagram.Lock()
+	defer gate.mutexDatagram.Unlock()
+	if a.datagramClosed {
+		return
+	}
+	a.datagramClosed = true
+	if a.datagramToken != "" {
+		delete(gate.datagramAgents, a.datagramToken)
+		close(a.datagrams)
+	}
+}
+
+// runs on the goroutine of the UDP server, the first source address of a
+// token is bound to it and the datagrams of the token from the other
+// addresses are dropped, so that the replies could not be taken over
+func (gate *Gate) onDatagram(token string, addr *net.UDPAddr, data []byte) {
+	gate.mutexDatagram.Lock()
+	defer gate.mutexDatagram.Unlock()
+	a := gate.datagramAgents[token]
+	if a == nil {
+		return
+	}
+	if a.datagramAddr == nil {
+		a.datagramAddr = addr
+	} else if !a.datagramAddr.IP.Equal(addr.IP) || a.datagramAddr.Port != addr.Port {
+		log.Debug("datagram of agent %v from %v dropped: bound to %v", a.id, addr, a.datagramAddr)
+		return
+	}
+
+	select {
+	case a.datagrams <- data:
+	default:
+		log.Debug("datagram of agent %v dropped: queue full", a.id)
+	}
+}
+
+// handles the datagrams one at a time with the messages of the agent
+func (a *agent) runDatagrams(datagrams chan []byte) {
+	for data := range datagrams {
+		if a.gate.Queued(a) || a.gate.Agent(a.id) != Agent(a) {
+			continue
+		}
+		atomic.AddUint64(&a.bytesIn, uint64(len(data)))
+
+		a.mutexHandle.Lock()
+		a.handleDatagram(data)
+		a.mutexHandle.Unlock()
+	}
+}
+
+// the datagrams only go through the datagram hooks of the middlewares, a
+// bad datagram is dropped, the connection is closed only on a CloseError
+func (a *agent) handleDatagram(data []byte) {
+	data, err := a.gate.readDatagram(a, data)
+	if err != nil {
+		if err != ErrDrop {
+			log.Debug("read datagram: %v", err)
+		}
+		a.onReadError(err)
+		return
+	}
+
+	if a.gate.Processor != nil {
+		msg, err := a.gate.Processor.Unmarshal(data)
+		if err != nil {
+			log.Debug("unmarshal datagram error: %v", err)
+			return
+		}
+		err = a.gate.Processor.Route(msg, a)
+		if err != nil {
+			log.Debug("route datagram error: %v", err)
+		}
+	}
+}
+
+// falls back to WriteMsg until the client sends its first datagram, the
+// datagrams only go through the WriteDatagram hooks of the middlewares
+func (a *agent) WriteDatagram(msg interface{}) {
+	a.gate.mutexDatagram.Lock()
+	addr := a.datagramAddr
+	a.gate.mutexDatagram.Unlock()
+	if addr == nil || a.gate.udpServer == nil {
+		a.WriteMsg(msg)
+		return
+	}
+
+	if a.gate.Processor != nil {
+		data, err := a.gate.Processor.Marshal(msg)
+		if err != nil {
+			log.Error("marshal datagram %v error: %v", reflect.TypeOf(msg), err)
+			return
+		}
+		data, err = a.gate.writeDatagram(a, data)
+		if err != nil {
+			a.onWriteError(msg, err)
+			return
+		}
+		err = a.gate.udpServer.WriteTo(addr, data...)
+		if err != nil {
+			log.Debug("write datagram %v error: %v", reflect.TypeOf(msg), err)
+			return
+		}
+		var n int
+		for _, b := range data {
+			n += len(b)
+		}
+		atomic.AddUint64(&a.bytesOut, uint64(n))
+	}
+}
@@ -0,0 +1,112 @@
+package gate
+
+import (
+	"github.com/name5566/leaf/log"
+	"github.com/name5566/leaf/network"
+)
+
+// the routed messages waiting for a busy module, the others are dropped
+const eventQueueLen = 64
+
+type event struct {
+	f   func()
+	msg bool
+}
+
+// the agents of the event loops are read by workers shared by every
+// connection, so their NewAgent and CloseAgent calls and their routed
+// messages run in order on a goroutine of their own, a stalled module
+// could then not hold the other connections
+func isEventLoop(conn network.Conn) bool {
+	_, ok := conn.(*network.EpollConn)
+	return ok
+}
+
+// false if f is a message and the queue is full, the calls are never
+// dropped
+func (a *agent) post(f func(), msg bool) bool {
+	a.mutexEvents.Lock()
+	defer a.mutexEvents.Unlock()
+	if msg {
+		if a.eventMsgs >= eventQueueLen {
+			return false
+		}
+		a.eventMsgs++
+	}
+	a.events = append(a.events, event{f, msg})
+	if !a.eventsRunning {
+		a.eventsRunning = true
+		go a.runEvents()
+	}
+	return true
+}
+
+// exits once the queue is empty
+func (a *agent) runEvents() {
+	for {
+		a.mutexEvents.Lock()
+		if len(a.events) == 0 {
+			a.eventsRunning = false
+			a.mutexEvents.Unlock()
+			return
+		}
+		e := a.events[0]
+		a.events[0] = event{}
+		a.events = a.events[1:]
+		if e.msg {
+			a.eventMsgs--
+		}
+		a.mutexEvents.Unlock()
+
+		e.f()
+	}
+}
+
+func (gate *Gate) notifyNewAgent(a *agent) {
+	if gate.AgentChanRPC == nil {
+		return
+	}
+	if a.eventLoop {
+		a.post(func() {
+			gate.AgentChanRPC.Go("NewAgent", a)
+		}, false)
+	} else {
+		gate.AgentChanRPC.Go("NewAgent", a)
+	}
+}
+
+// the agents queued behind are admitted once the module is notified
+func (gate *Gate) notifyCloseAgent(a *agent) {
+	closeAgent := func() {
+		if gate.AgentChanRPC != nil {
+			err := gate.AgentChanRPC.Call0("CloseAgent", a)
+			if err != nil {
+				log.Error("chanrpc error: %v", err)
+			}
+		}
+		gate.admit()
+	}
+	if a.eventLoop {
+		a.post(closeAgent, false)
+	} else {
+		closeAgent()
+	}
+}
+
+func (a *agent) route(msg interface{}) error {
+	if !a.eventLoop {
+		return a.gate.Processor.Route(msg, a)
+	}
+
+	ok := a.post(func() {
+		err := a.gate.Processor.Route(msg, a)
+		if err != nil {
+			log.Debug("route message error: %v", err)
+			a.Close()
+		}
+	}, true)
+	if !ok {
+		log.Debug("message of agent %v dropped: queue full", a.id)
+	}
+	return nil
+}
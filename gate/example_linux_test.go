@@ -0,0 +1,131 @@
+package gate_test
+
+import (
+	"encoding/binary"
+	"fmt"
+	"github.com/name5566/leaf/chanrpc"
+	"github.com/name5566/leaf/gate"
+	"github.com/name5566/leaf/network/json"
+	"net"
+	"reflect"
+	"sync/atomic"
+)
+
+type Work struct {
+	N int
+}
+
+// writes a message with a 2 bytes big endian length
+func writeTCP(conn net.Conn, msg string) {
+	conn.Write(append(binary.BigEndian.AppendUint16(nil, uint16(len(msg))), msg...))
+}
+
+func ExampleGate_eventLoop() {
+	g := newNoticeGate("127.0.0.1:3596")
+	g.TCPEventLoop = true
+	g.EventLoops = 1
+	g.EventWorkers = 1
+	processor := g.Processor.(*json.Processor)
+	processor.Register(&Work{})
+
+	// the module is stalled until the end
+	var newAgents, closeAgents, works int32
+	module := chanrpc.NewServer(0)
+	module.Register("NewAgent", func(args []interface{}) {
+		atomic.AddInt32(&newAgents, 1)
+	})
+	module.Register("CloseAgent", func(args []interface{}) {
+		atomic.AddInt32(&closeAgents, 1)
+	})
+	module.Register(reflect.TypeOf(&Work{}), func(args []interface{}) {
+		atomic.AddInt32(&works, 1)
+	})
+	processor.SetRouter(&Work{}, module)
+	g.AgentChanRPC = module
+
+	// the notices are echoed by the only worker
+	g.Use(&gate.Middleware{
+		Name: "echo",
+		ReadMsg: func(a gate.Agent, msg interface{}) error {
+			if n, ok := msg.(*Notice); ok {
+				a.WriteMsg(&Notice{Text: "echo " + n.Text})
+				return gate.ErrDrop
+			}
+			return nil
+		},
+	})
+
+	closeSig := make(chan bool)
+	done := make(chan struct{})
+	go func() {
+		g.Run(closeSig)
+		close(done)
+	}()
+
+	echo := func(conn net.Conn, text string) {
+		writeTCP(conn, `{"Notice":{"Text":"`+text+`"}}`)
+		fmt.Println(readTCP(conn))
+	}
+	dial := func() net.Conn {
+		conn, err := dialTCP(g.TCPAddr)
+		if err != nil {
+			fmt.Println(err)
+			return nil
+		}
+		return conn
+	}
+
+	// 64 messages wait for the module, the others are dropped
+	a := dial()
+	if a == nil {
+		return
+	}
+	for i := 0; i < 100; i++ {
+		writeTCP(a, fmt.Sprintf(`{"Work":{"N":%v}}`, i))
+	}
+	echo(a, "a")
+
+	b := dial()
+	if b == nil {
+		return
+	}
+	defer b.Close()
+	echo(b, "b")
+
+	// the close of a does not hold the worker either
+	a.Close()
+	c := dial()
+	if c == nil {
+		return
+	}
+	echo(c, "c")
+	c.Close()
+	b.Close()
+
+	// the module resumes
+	resumed := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case ci := <-module.ChanCall:
+				module.Exec(ci)
+			case <-resumed:
+				return
+			}
+		}
+	}()
+	ok := waitFor(func() bool {
+		return atomic.LoadInt32(&closeAgents) == 3
+	})
+	fmt.Println(ok, atomic.LoadInt32(&newAgents), atomic.LoadInt32(&works))
+
+	closeSig <- true
+	<-done
+	close(resumed)
+
+	// Output:
+	// {"Notice":{"Text":"echo a"}} <nil>
+	// {"Notice":{"Text":"echo b"}} <nil>
+	// {"Notice":{"Text":"echo c"}} <nil>
+	// true 3 64
+}
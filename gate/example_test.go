@@ -0,0 +1,750 @@
+package gate_test
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/pem"
+	"fmt"
+	"github.com/name5566/leaf/chanrpc"
+	"github.com/name5566/leaf/conf"
+	"github.com/name5566/leaf/console"
+	"github.com/name5566/leaf/gate"
+	"github.com/name5566/leaf/log"
+	"github.com/name5566/leaf/network"
+	"github.com/name5566/leaf/network/json"
+	"github.com/name5566/leaf/network/quic"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+func init() {
+	// keep the output of the examples clean
+	logger, _ := log.New("fatal", "", 0)
+	log.Export(logger)
+}
+
+// runs g until the returned function is called, the agents are passed to
+// newAgent on the AgentChanRPC goroutine
+func runGate(g *gate.Gate, newAgent func(a gate.Agent)) func() {
+	rpc := chanrpc.NewServer(10)
+	rpc.Register("NewAgent", func(args []interface{}) {
+		newAgent(args[0].(gate.Agent))
+	})
+	rpc.Register("CloseAgent", func(args []interface{}) {})
+	g.AgentChanRPC = rpc
+
+	closeSig := make(chan bool)
+	done := make(chan struct{})
+	go func() {
+		g.Run(closeSig)
+		close(done)
+	}()
+	go func() {
+		for {
+			select {
+			case ci := <-rpc.ChanCall:
+				rpc.Exec(ci)
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	return func() {
+		closeSig <- true
+		<-done
+	}
+}
+
+// opens a long polling session, retried until the gate is listening
+func openLP(url string) (string, error) {
+	var err error
+	for i := 0; i < 50; i++ {
+		var resp *http.Response
+		resp, err = http.Post(url, "", nil)
+		if err != nil {
+			time.Sleep(20 * time.Millisecond)
+			continue
+		}
+		sid, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		return string(sid), nil
+	}
+	return "", err
+}
+
+// opens a tcp connection with the header sent, retried until the gate is
+// listening
+func dialTCP(addr string) (net.Conn, error) {
+	var err error
+	for i := 0; i < 50; i++ {
+		var conn net.Conn
+		conn, err = net.Dial("tcp", addr)
+		if err != nil {
+			time.Sleep(20 * time.Millisecond)
+			continue
+		}
+		conn.Write([]byte("{{{"))
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		return conn, nil
+	}
+	return nil, err
+}
+
+// reads a message with a 2 bytes big endian length
+func readTCP(conn net.Conn) (string, error) {
+	var l [2]byte
+	if _, err := io.ReadFull(conn, l[:]); err != nil {
+		return "", err
+	}
+	b := make([]byte, binary.BigEndian.Uint16(l[:]))
+	if _, err := io.ReadFull(conn, b); err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// polls cond for up to 5 seconds
+func waitFor(cond func() bool) bool {
+	for i := 0; i < 500; i++ {
+		if cond() {
+			return true
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return false
+}
+
+func newNoticeGate(tcpAddr string) *gate.Gate {
+	processor := json.NewProcessor()
+	processor.Register(&Notice{})
+
+	g := new(gate.Gate)
+	g.MaxConnNum = 10
+	g.PendingWriteNum = 10
+	g.MaxMsgLen = 4096
+	g.Processor = processor
+	g.TCPAddr = tcpAddr
+	g.LenMsgLen = 2
+	g.SystemMsg = func(text string) interface{} {
+		return &Notice{Text: text}
+	}
+	return g
+}
+
+func ExampleAgent_WriteRaw() {
+	g := new(gate.Gate)
+	g.MaxConnNum = 10
+	g.PendingWriteNum = 10
+	g.MaxMsgLen = 4096
+	g.WSAddr = "127.0.0.1:3570"
+	g.HTTPTimeout = 2 * time.Second
+	g.LPPath = "/lp"
+	stop := runGate(g, func(a gate.Agent) {
+		// a pooled buffer, reused once released
+		b := []byte("My name is Leaf")
+		buf := network.NewBuffer(b)
+		buf.SetFree(func() {
+			copy(b, strings.Repeat("x", len(b)))
+		})
+		a.WriteRaw(buf)
+		buf.Release()
+	})
+	defer stop()
+
+	url := "http://" + g.WSAddr + g.LPPath
+	sid, err := openLP(url)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	resp, err := http.Get(url + "?sid=" + sid + "&ack=0")
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if len(body) > 4 {
+		fmt.Println(string(body[4:]))
+	}
+
+	// Output:
+	// My name is Leaf
+}
+
+type Notice struct {
+	Text string
+}
+
+func ExampleCloseWith() {
+	processor := json.NewProcessor()
+	processor.Register(&Notice{})
+
+	g := new(gate.Gate)
+	g.MaxConnNum = 10
+	g.PendingWriteNum = 10
+	g.MaxMsgLen = 4096
+	g.Processor = processor
+	g.WSAddr = "127.0.0.1:3573"
+	g.HTTPTimeout = 2 * time.Second
+	g.LPPath = "/lp"
+	g.SystemMsg = func(text string) interface{} {
+		return &Notice{Text: text}
+	}
+	g.Use(&gate.Middleware{
+		Name: "auth",
+		ReadData: func(a gate.Agent, data []byte) ([]byte, error) {
+			return nil, gate.CloseWith("unauthorized")
+		},
+	})
+	stop := runGate(g, func(a gate.Agent) {})
+	defer stop()
+
+	url := "http://" + g.WSAddr + g.LPPath
+	sid, err := openLP(url)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	msg := []byte(`{"Notice":{"Text":"hello"}}`)
+	body := binary.BigEndian.AppendUint32(nil, uint32(len(msg)))
+	resp, err := http.Post(url+"?sid="+sid+"&seq=1", "", bytes.NewReader(append(body, msg...)))
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	resp.Body.Close()
+
+	// the reason is sent before the close
+	resp, err = http.Get(url + "?sid=" + sid + "&ack=0")
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	b, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if len(b) > 4 {
+		fmt.Println(string(b[4:]))
+	}
+
+	// Output:
+	// {"Notice":{"Text":"unauthorized"}}
+}
+
+func ExampleAttrs() {
+	var attrs gate.Attrs
+	name := gate.NewAttrKey("name")
+	level := gate.NewAttrKey("level")
+
+	// the keys are compared by identity
+	other := gate.NewAttrKey("name")
+
+	attrs.Set(name, "leaf")
+	attrs.Set(level, 3)
+	fmt.Println(attrs.String(name), attrs.Int(level), attrs.Get(other), attrs.Len())
+
+	// the wrong type reads as the zero value
+	fmt.Println(attrs.Bool(level), attrs.Duration(name))
+
+	fmt.Println(attrs.TestAndSet(name, "other"), attrs.TestAndSet(other, "other"))
+	v, ok := attrs.Lookup(other)
+	fmt.Println(v, ok)
+
+	attrs.Del(other)
+	_, ok = attrs.Lookup(other)
+	n := 0
+	attrs.Range(func(key *gate.AttrKey, value interface{}) {
+		n++
+	})
+	fmt.Println(ok, n)
+
+	// Output:
+	// leaf 3 <nil> 2
+	// false 0s
+	// leaf <nil>
+	// other true
+	// false 2
+}
+
+// a console session, the prompt ends the output of a command
+type consoleSession struct {
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+func (c *consoleSession) readPrompt() (string, error) {
+	var b []byte
+	for !bytes.HasSuffix(b, []byte(conf.ConsolePrompt)) {
+		c, err := c.reader.ReadByte()
+		if err != nil {
+			return "", err
+		}
+		b = append(b, c)
+	}
+	return string(b[:len(b)-len(conf.ConsolePrompt)]), nil
+}
+
+func (c *consoleSession) run(line string) []string {
+	c.conn.Write([]byte(line + "\r\n"))
+	output, err := c.readPrompt()
+	if err != nil {
+		return []string{err.Error()}
+	}
+	return strings.Split(strings.TrimSuffix(output, "\r\n"), "\r\n")
+}
+
+func ExampleGate_RegisterCommands() {
+	g := newNoticeGate("127.0.0.1:3578")
+	g.RegisterCommands()
+	stop := runGate(g, func(a gate.Agent) {})
+	defer stop()
+
+	conf.ConsolePort = 3577
+	console.Init()
+	defer console.Destroy()
+
+	var clients []net.Conn
+	for i := 0; i < 2; i++ {
+		conn, err := dialTCP(g.TCPAddr)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		defer conn.Close()
+		clients = append(clients, conn)
+		waitFor(func() bool { return g.AgentNum() == i+1 })
+	}
+
+	// the registry
+	var ids []uint64
+	g.RangeAgents(func(a gate.Agent) bool {
+		ids = append(ids, a.ID())
+		return true
+	})
+	fmt.Println(ids, g.AgentNum(), g.Agent(2) != nil, g.Agent(3) == nil)
+
+	conn, err := net.Dial("tcp", "localhost:3577")
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	defer conn.Close()
+	conn.Write([]byte("{{{"))
+	session := &consoleSession{conn: conn, reader: bufio.NewReader(conn)}
+	session.readPrompt()
+
+	output := session.run("agents transport=tcp")
+	fmt.Println(len(output), output[len(output)-1])
+	fmt.Println(session.run("agents transport=ws")[1])
+	fmt.Println(session.run("agent 3"))
+
+	fmt.Println(session.run("kick 1 bye"))
+	fmt.Println(readTCP(clients[0]))
+	_, err = readTCP(clients[0])
+	fmt.Println(err)
+	waitFor(func() bool { return g.AgentNum() == 1 })
+
+	// the agents in the range are kicked
+	fmt.Println(session.run("ban 127.0.0.1 forever"))
+	fmt.Println(readTCP(clients[1]))
+	fmt.Println(session.run("ban 10.0.0.0/8 1h"))
+	g.Ban("192.168.0.1", time.Millisecond)
+	time.Sleep(10 * time.Millisecond)
+
+	// the expired ban is not listed
+	for _, line := range session.run("ban")[1:] {
+		fmt.Println(strings.Fields(line)[0])
+	}
+
+	// the new connections from a banned address are closed
+	banned, err := dialTCP(g.TCPAddr)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	defer banned.Close()
+	_, err = readTCP(banned)
+	fmt.Println(err)
+
+	fmt.Println(session.run("unban 127.0.0.1"))
+	fmt.Println(g.Banned(&net.TCPAddr{IP: net.ParseIP("127.0.0.1")}), g.Banned(&net.TCPAddr{IP: net.ParseIP("10.1.2.3")}))
+
+	// Output:
+	// [1 2] 2 true true
+	// 4 2 of 2 agents
+	// 0 of 2 agents
+	// [agent 3 not found]
+	// []
+	// {"Notice":{"Text":"bye"}} <nil>
+	// EOF
+	// []
+	// {"Notice":{"Text":"banned"}} <nil>
+	// []
+	// 127.0.0.1/32
+	// 10.0.0.0/8
+	// EOF
+	// []
+	// false true
+}
+
+func ExampleGate_ScheduleMaintenance() {
+	g := newNoticeGate("127.0.0.1:3579")
+	stop := runGate(g, func(a gate.Agent) {})
+	defer stop()
+
+	connect := func(n int) net.Conn {
+		conn, err := dialTCP(g.TCPAddr)
+		if err != nil {
+			fmt.Println(err)
+			return nil
+		}
+		if n > 0 {
+			waitFor(func() bool { return g.AgentNum() == n })
+		}
+		return conn
+	}
+	player := connect(1)
+	defer player.Close()
+	admin := connect(2)
+	defer admin.Close()
+	g.AllowMaintenanceUser("admin")
+	g.Agent(2).Attrs().Set(gate.AttrUserID, "admin")
+
+	// the countdown is broadcast, then the agents not allowlisted are kicked
+	g.ScheduleMaintenance(1200 * time.Millisecond)
+	fmt.Println(readTCP(admin))
+	fmt.Println(readTCP(player))
+	fmt.Println(readTCP(player))
+	_, err := readTCP(player)
+	fmt.Println(err)
+	waitFor(func() bool { return g.AgentNum() == 1 })
+	fmt.Println(g.Maintenance(), g.AgentNum())
+
+	// a user is allowlisted, so the new connections are accepted and the
+	// login handler decides
+	guest := connect(2)
+	defer guest.Close()
+	fmt.Println(g.AllowLogin(g.Agent(3), "guest"))
+	fmt.Println(readTCP(guest))
+	fmt.Println(g.AllowLogin(g.Agent(2), "admin"))
+
+	// nobody allowlisted
+	g.ClearMaintenanceAllowlist()
+	rejected := connect(0)
+	defer rejected.Close()
+	fmt.Println(readTCP(rejected))
+	_, err = readTCP(rejected)
+	fmt.Println(err)
+
+	g.AllowMaintenanceIP("127.0.0.0/8")
+	tester := connect(2)
+	defer tester.Close()
+	fmt.Println(g.AgentNum())
+
+	g.SetMaintenance(false)
+	fmt.Println(g.Maintenance())
+
+	// Output:
+	// {"Notice":{"Text":"server maintenance in 1s"}} <nil>
+	// {"Notice":{"Text":"server maintenance in 1s"}} <nil>
+	// {"Notice":{"Text":"server under maintenance"}} <nil>
+	// EOF
+	// true 1
+	// false
+	// {"Notice":{"Text":"server under maintenance"}} <nil>
+	// true
+	// {"Notice":{"Text":"server under maintenance"}} <nil>
+	// EOF
+	// 2
+	// false
+}
+
+type Queue struct {
+	Pos int
+	ETA time.Duration
+}
+
+// reads the messages until one contains s
+func readUntil(conn net.Conn, s string) (string, error) {
+	for {
+		msg, err := readTCP(conn)
+		if err != nil || strings.Contains(msg, s) {
+			return msg, err
+		}
+	}
+}
+
+func ExampleGate_queue() {
+	g := newNoticeGate("127.0.0.1:3580")
+	g.Processor.(*json.Processor).Register(&Queue{})
+	g.MaxConnNum = 1
+	g.QueueLen = 3
+	g.QueueLanes = 2
+	g.QueueInterval = 20 * time.Millisecond
+	g.QueueMsg = func(pos int, eta time.Duration) interface{} {
+		return &Queue{Pos: pos, ETA: eta}
+	}
+	lanes := []int{0, 1, 1, 0}
+	g.QueueLane = func(a gate.Agent) int {
+		lane := lanes[0]
+		lanes = lanes[1:]
+		return lane
+	}
+	stop := runGate(g, func(a gate.Agent) {})
+	defer stop()
+
+	var clients []net.Conn
+	for i := 0; i < 5; i++ {
+		conn, err := dialTCP(g.TCPAddr)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		defer conn.Close()
+		clients = append(clients, conn)
+		if i < 4 {
+			waitFor(func() bool { return g.AgentNum()+g.QueueNum() == i+1 })
+		}
+	}
+
+	// the queue is full
+	_, err := readTCP(clients[4])
+	fmt.Println(err)
+	fmt.Println(g.AgentNum(), g.QueueNum())
+
+	// lane 0 first, no admission yet so no ETA
+	fmt.Println(readUntil(clients[3], `"Pos":1,`))
+	fmt.Println(readUntil(clients[1], `"Pos":2,`))
+	fmt.Println(readUntil(clients[2], `"Pos":3,`))
+
+	// the queued agents allowlisted are admitted in maintenance mode
+	g.AllowMaintenanceIP("127.0.0.1")
+	g.SetMaintenance(true)
+	clients[0].Close()
+	fmt.Println(readUntil(clients[3], `"Pos":0,`))
+	time.Sleep(50 * time.Millisecond)
+	clients[3].Close()
+	fmt.Println(readUntil(clients[1], `"Pos":0,`))
+
+	// the ETA is known from the admissions
+	msg, _ := readUntil(clients[2], `"Pos":1,`)
+	fmt.Println(strings.Contains(msg, `"ETA":0}`))
+
+	// the others are kicked
+	g.ClearMaintenanceAllowlist()
+	g.SetMaintenance(true)
+	fmt.Println(readUntil(clients[2], "Notice"))
+	_, err = readUntil(clients[2], "Notice")
+	fmt.Println(err)
+	waitFor(func() bool { return g.QueueNum() == 0 })
+	fmt.Println(g.AgentNum(), g.QueueNum())
+
+	// Output:
+	// EOF
+	// 1 3
+	// {"Queue":{"Pos":1,"ETA":0}} <nil>
+	// {"Queue":{"Pos":2,"ETA":0}} <nil>
+	// {"Queue":{"Pos":3,"ETA":0}} <nil>
+	// {"Queue":{"Pos":0,"ETA":0}} <nil>
+	// {"Queue":{"Pos":0,"ETA":0}} <nil>
+	// false
+	// {"Notice":{"Text":"server under maintenance"}} <nil>
+	// EOF
+	// 1 0
+}
+
+// writes a self-signed certificate and its key to dir
+func writeCert(dir string) (certFile string, keyFile string, err error) {
+	tlsConfig, err := quic.SelfSignedTLSConfig()
+	if err != nil {
+		return "", "", err
+	}
+	cert := tlsConfig.Certificates[0]
+	key, err := x509.MarshalPKCS8PrivateKey(cert.PrivateKey)
+	if err != nil {
+		return "", "", err
+	}
+
+	certFile = filepath.Join(dir, "cert.pem")
+	keyFile = filepath.Join(dir, "key.pem")
+	err = os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Certificate[0]}), 0600)
+	if err != nil {
+		return "", "", err
+	}
+	err = os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: key}), 0600)
+	if err != nil {
+		return "", "", err
+	}
+	return certFile, keyFile, nil
+}
+
+type quicAgent struct {
+	conn *quic.Conn
+	done chan bool
+}
+
+// the client only waits for the server to speak
+func (a *quicAgent) Run() {
+	data, err := a.conn.ReadMsg()
+	if err == nil {
+		fmt.Println(a.conn.Datagram(), string(data))
+	}
+	a.done <- true
+}
+
+func (a *quicAgent) OnClose() {}
+
+func ExampleGate_quic() {
+	dir, err := os.MkdirTemp("", "leaf")
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	defer os.RemoveAll(dir)
+	certFile, keyFile, err := writeCert(dir)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	for i, datagram := range []bool{false, true} {
+		g := newNoticeGate("")
+		g.QUICAddr = fmt.Sprintf("127.0.0.1:%v", 3582+i)
+		g.QUICDatagram = datagram
+		g.CertFile = certFile
+		g.KeyFile = keyFile
+		stop := runGate(g, func(a gate.Agent) {
+			fmt.Println(a.Info().Transport, a.Info().TLS)
+			a.WriteMsg(&Notice{Text: "welcome"})
+		})
+
+		done := make(chan bool)
+		client := new(quic.Client)
+		client.Addr = g.QUICAddr
+		client.TLSConfig = &tls.Config{InsecureSkipVerify: true}
+		client.Datagram = datagram
+		client.ConnectInterval = 20 * time.Millisecond
+		client.NewAgent = func(conn *quic.Conn) network.Agent {
+			return &quicAgent{conn: conn, done: done}
+		}
+		client.Start()
+		<-done
+		client.Close()
+		stop()
+	}
+
+	// Output:
+	// quic true
+	// false {"Notice":{"Text":"welcome"}}
+	// quic true
+	// true {"Notice":{"Text":"welcome"}}
+}
+
+type Token struct {
+	Token []byte
+}
+
+type Ping struct {
+	Text string
+}
+
+func ExampleAgent_WriteDatagram() {
+	g := newNoticeGate("127.0.0.1:3584")
+	g.UDPAddr = "127.0.0.1:3585"
+	processor := g.Processor.(*json.Processor)
+	processor.Register(&Token{})
+	processor.Register(&Ping{})
+	processor.SetHandler(&Ping{}, func(args []interface{}) {
+		ping := args[0].(*Ping)
+		args[1].(gate.Agent).WriteDatagram(&Ping{Text: "pong " + ping.Text})
+	})
+
+	// a stateful stream middleware never sees the datagrams
+	var streamMsgs, datagrams int32
+	g.Use(&gate.Middleware{
+		Name: "counter",
+		WriteData: func(a gate.Agent, data [][]byte) ([][]byte, error) {
+			atomic.AddInt32(&streamMsgs, 1)
+			return data, nil
+		},
+		WriteDatagram: func(a gate.Agent, data [][]byte) ([][]byte, error) {
+			atomic.AddInt32(&datagrams, 1)
+			return data, nil
+		},
+	})
+	stop := runGate(g, func(a gate.Agent) {
+		a.WriteMsg(&Token{Token: a.DatagramToken()})
+	})
+	defer stop()
+
+	conn, err := dialTCP(g.TCPAddr)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	defer conn.Close()
+	msg, err := readTCP(conn)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	v, err := processor.Unmarshal([]byte(msg))
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	token := v.(*Token).Token
+
+	dial := func() *net.UDPConn {
+		addr, _ := net.ResolveUDPAddr("udp", g.UDPAddr)
+		c, err := net.DialUDP("udp", nil, addr)
+		if err != nil {
+			fmt.Println(err)
+			return nil
+		}
+		return c
+	}
+	ping := func(c *net.UDPConn, data string) {
+		c.Write(append(append([]byte{}, token...), data...))
+		c.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+		b := make([]byte, 1024)
+		n, err := c.Read(b)
+		if err != nil {
+			fmt.Println("no reply")
+			return
+		}
+		fmt.Println(string(b[:n]))
+	}
+
+	client := dial()
+	defer client.Close()
+	ping(client, `{"Ping":{"Text":"1"}}`)
+
+	// a bad datagram is dropped, the connection is kept
+	ping(client, `garbage`)
+	ping(client, `{"Ping":{"Text":"2"}}`)
+
+	// the token is bound to the first address
+	other := dial()
+	defer other.Close()
+	ping(other, `{"Ping":{"Text":"3"}}`)
+
+	fmt.Println(g.AgentNum(), atomic.LoadInt32(&streamMsgs), atomic.LoadInt32(&datagrams))
+
+	// Output:
+	// {"Ping":{"Text":"pong 1"}}
+	// no reply
+	// {"Ping":{"Text":"pong 2"}}
+	// no reply
+	// 1 1 2
+}
@@ -1,11 +1,15 @@
 package gate
 
 import (
+	"container/list"
 	"github.com/name5566/leaf/chanrpc"
 	"github.com/name5566/leaf/log"
 	"github.com/name5566/leaf/network"
+	"github.com/name5566/leaf/network/quic"
 	"net"
 	"reflect"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -16,125 +20,492 @@ type Gate struct {
 	Processor       network.Processor
 	AgentChanRPC    *chanrpc.Server
 
+	// read limits of the websocket and tcp connections, 0 means no limit
+	HandshakeTimeout time.Duration // to read the websocket upgrade or the tcp header
+	ReadTimeout      time.Duration // to read a message once its first byte arrives
+	MinReadRate      int           // bytes per second, extends ReadTimeout
+	MaxBufferedBytes int64         // bytes of the messages being read, a message beyond closes its connection
+
+	// builds the message sent to the clients for notices (kick reasons,
+	// broadcasts), the message must be registered to Processor
+	SystemMsg func(text string) interface{}
+
+	// maintenance, the new connections are rejected unless their IP is
+	// allowlisted, but once a user is allowlisted, every connection is
+	// accepted so that the user could log in, the login handler must then
+	// call AllowLogin, which kicks the users not allowlisted
+	MaintenanceMsg      string        // sent to the rejected clients
+	MaintenanceNotice   string        // countdown format, %v is the time left
+	MaintenanceInterval time.Duration // countdown broadcast interval
+
+	// login queue, connections beyond MaxConnNum wait in the queue
+	QueueLen      int                                          // 0 disables the queue
+	QueueLanes    int                                          // priority lanes, lane 0 is admitted first
+	QueueLane     func(a Agent) int                            // picks the lane of a new agent
+	QueueMsg      func(pos int, eta time.Duration) interface{} // pos is 0 once admitted, eta is 0 if unknown
+	QueueInterval time.Duration
+
 	// websocket
 	WSAddr      string
 	HTTPTimeout time.Duration
 	CertFile    string
 	KeyFile     string
+	LPPath      string // long polling fallback, e.g. "/lp"
 
 	// tcp
 	TCPAddr      string
 	LenMsgLen    int
 	LittleEndian bool
+	// linux only, epoll event loops and a pool of workers serve the tcp
+	// connections instead of two goroutines per connection
+	TCPEventLoop bool
+	EventLoops   int // runtime.NumCPU() by default
+	EventWorkers int // 4 * runtime.NumCPU() by default
+	// listeners sharing TCPAddr by SO_REUSEPORT, 0 or 1 for one listener
+	TCPAcceptShards int
+	TCPSockOpts     network.SockOpts
+
+	// quic, shares CertFile, KeyFile, LenMsgLen and LittleEndian
+	QUICAddr     string
+	QUICDatagram bool // unreliable datagrams instead of a stream
+
+	// udp, unreliable datagrams alongside the reliable connections
+	UDPAddr string
+
+	middlewares   []*Middleware
+	commandServer *chanrpc.Server
+
+	// session registry
+	lastAgentID uint64
+	agents      map[uint64]*agent
+	mutexAgents sync.Mutex
+	bans        []*ban
+	mutexBans   sync.Mutex
+
+	maintenance      maintenance
+	mutexMaintenance sync.Mutex
+	queue            queue
+	mutexQueue       sync.Mutex
+	udpServer        *network.UDPServer
+	datagramAgents   map[string]*agent
+	mutexDatagram    sync.Mutex
 }
 
 func (gate *Gate) Run(closeSig chan bool) {
+	// the servers keep the queued connections too
+	maxConnNum := gate.MaxConnNum
+	if gate.queueEnabled() {
+		maxConnNum = gate.maxConnNum() + gate.QueueLen
+	}
+
+	var readBudget *network.ByteBudget
+	if gate.MaxBufferedBytes > 0 {
+		readBudget = network.NewByteBudget(gate.MaxBufferedBytes)
+	}
+
 	var wsServer *network.WSServer
 	if gate.WSAddr != "" {
 		wsServer = new(network.WSServer)
 		wsServer.Addr = gate.WSAddr
-		wsServer.MaxConnNum = gate.MaxConnNum
+		wsServer.MaxConnNum = maxConnNum
 		wsServer.PendingWriteNum = gate.PendingWriteNum
 		wsServer.MaxMsgLen = gate.MaxMsgLen
 		wsServer.HTTPTimeout = gate.HTTPTimeout
 		wsServer.CertFile = gate.CertFile
 		wsServer.KeyFile = gate.KeyFile
+		wsServer.HandshakeTimeout = gate.HandshakeTimeout
+		wsServer.ReadTimeout = gate.ReadTimeout
+		wsServer.MinReadRate = gate.MinReadRate
+		wsServer.ReadBudget = readBudget
 		wsServer.NewAgent = func(conn *network.WSConn) network.Agent {
-			a := &agent{conn: conn, gate: gate}
-			if gate.AgentChanRPC != nil {
-				gate.AgentChanRPC.Go("NewAgent", a)
-			}
-			return a
+			return gate.newAgent(conn, TransportWS, gate.CertFile != "" || gate.KeyFile != "")
+		}
+		wsServer.LPPath = gate.LPPath
+		wsServer.NewLPAgent = func(conn *network.LPConn) network.Agent {
+			return gate.newAgent(conn, TransportLP, gate.CertFile != "" || gate.KeyFile != "")
 		}
 	}
 
 	var tcpServer *network.TCPServer
-	if gate.TCPAddr != "" {
+	var epollServer *network.EpollServer
+	if gate.TCPAddr != "" && gate.TCPEventLoop {
+		epollServer = new(network.EpollServer)
+		epollServer.Addr = gate.TCPAddr
+		epollServer.MaxConnNum = maxConnNum
+		epollServer.PendingWriteNum = gate.PendingWriteNum
+		epollServer.Loops = gate.EventLoops
+		epollServer.Workers = gate.EventWorkers
+		epollServer.LenMsgLen = gate.LenMsgLen
+		epollServer.MaxMsgLen = gate.MaxMsgLen
+		epollServer.LittleEndian = gate.LittleEndian
+		epollServer.HandshakeTimeout = gate.HandshakeTimeout
+		epollServer.ReadTimeout = gate.ReadTimeout
+		epollServer.MinReadRate = gate.MinReadRate
+		epollServer.ReadBudget = readBudget
+		epollServer.AcceptShards = gate.TCPAcceptShards
+		epollServer.SockOpts = gate.TCPSockOpts
+		epollServer.NewAgent = func(conn *network.EpollConn) network.Agent {
+			return gate.newAgent(conn, TransportTCP, false)
+		}
+	} else if gate.TCPAddr != "" {
 		tcpServer = new(network.TCPServer)
 		tcpServer.Addr = gate.TCPAddr
-		tcpServer.MaxConnNum = gate.MaxConnNum
+		tcpServer.MaxConnNum = maxConnNum
 		tcpServer.PendingWriteNum = gate.PendingWriteNum
 		tcpServer.LenMsgLen = gate.LenMsgLen
 		tcpServer.MaxMsgLen = gate.MaxMsgLen
 		tcpServer.LittleEndian = gate.LittleEndian
+		tcpServer.HandshakeTimeout = gate.HandshakeTimeout
+		tcpServer.ReadTimeout = gate.ReadTimeout
+		tcpServer.MinReadRate = gate.MinReadRate
+		tcpServer.ReadBudget = readBudget
+		tcpServer.AcceptShards = gate.TCPAcceptShards
+		tcpServer.SockOpts = gate.TCPSockOpts
 		tcpServer.NewAgent = func(conn *network.TCPConn) network.Agent {
-			a := &agent{conn: conn, gate: gate}
-			if gate.AgentChanRPC != nil {
-				gate.AgentChanRPC.Go("NewAgent", a)
-			}
-			return a
+			return gate.newAgent(conn, TransportTCP, false)
 		}
 	}
 
+	var quicServer *quic.Server
+	if gate.QUICAddr != "" {
+		quicServer = new(quic.Server)
+		quicServer.Addr = gate.QUICAddr
+		quicServer.MaxConnNum = maxConnNum
+		quicServer.PendingWriteNum = gate.PendingWriteNum
+		quicServer.CertFile = gate.CertFile
+		quicServer.KeyFile = gate.KeyFile
+		quicServer.Datagram = gate.QUICDatagram
+		quicServer.LenMsgLen = gate.LenMsgLen
+		quicServer.MaxMsgLen = gate.MaxMsgLen
+		quicServer.LittleEndian = gate.LittleEndian
+		quicServer.NewAgent = func(conn *quic.Conn) network.Agent {
+			return gate.newAgent(conn, TransportQUIC, true)
+		}
+	}
+
+	if gate.UDPAddr != "" {
+		gate.udpServer = new(network.UDPServer)
+		gate.udpServer.Addr = gate.UDPAddr
+		gate.udpServer.MaxMsgLen = gate.MaxMsgLen
+		gate.udpServer.OnDatagram = gate.onDatagram
+		gate.udpServer.Start()
+	}
 	if wsServer != nil {
 		wsServer.Start()
 	}
 	if tcpServer != nil {
 		tcpServer.Start()
 	}
-	<-closeSig
+	if epollServer != nil {
+		epollServer.Start()
+	}
+	if quicServer != nil {
+		quicServer.Start()
+	}
+
+	var chanCall chan *chanrpc.CallInfo
+	if gate.commandServer != nil {
+		chanCall = gate.commandServer.ChanCall
+	}
+	var chanQueue <-chan time.Time
+	if gate.queueEnabled() {
+		interval := gate.QueueInterval
+		if interval <= 0 {
+			interval = 5 * time.Second
+		}
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		chanQueue = ticker.C
+	}
+loop:
+	for {
+		select {
+		case <-closeSig:
+			break loop
+		case ci := <-chanCall:
+			gate.commandServer.Exec(ci)
+		case <-chanQueue:
+			gate.notifyQueue()
+		}
+	}
+
+	if gate.commandServer != nil {
+		gate.commandServer.Close()
+	}
 	if wsServer != nil {
 		wsServer.Close()
 	}
 	if tcpServer != nil {
 		tcpServer.Close()
 	}
+	if epollServer != nil {
+		epollServer.Close()
+	}
+	if quicServer != nil {
+		quicServer.Close()
+	}
+	if gate.udpServer != nil {
+		gate.udpServer.Close()
+	}
 }
 
 func (gate *Gate) OnDestroy() {}
 
+func (gate *Gate) newAgent(conn network.Conn, transport string, tls bool) *agent {
+	a := new(agent)
+	a.conn = conn
+	a.gate = gate
+	a.connectTime = time.Now()
+	a.transport = transport
+	a.tls = tls
+	a.eventLoop = isEventLoop(conn)
+	if gate.Banned(conn.RemoteAddr()) {
+		log.Debug("reject banned address %v", conn.RemoteAddr())
+		a.rejected = true
+		return a
+	}
+	if gate.maintenanceReject(conn.RemoteAddr()) {
+		log.Debug("reject %v: maintenance", conn.RemoteAddr())
+		a.rejected = true
+		a.rejectReason = gate.maintenanceMsg()
+		return a
+	}
+
+	if !gate.enter(a) {
+		log.Debug("reject %v: login queue full", conn.RemoteAddr())
+		a.rejected = true
+		a.rejectReason = "server is full"
+		return a
+	}
+	if gate.Queued(a) {
+		return a
+	}
+	gate.notifyNewAgent(a)
+	return a
+}
+
 type agent struct {
-	conn     network.Conn
-	gate     *Gate
-	userData interface{}
+	// accessed atomically, keep them 64-bit aligned
+	bytesIn  uint64
+	bytesOut uint64
+
+	id            uint64
+	closeNotified int32
+	rejected      bool
+	rejectReason  string
+	lane          int
+	queueElem     *list.Element
+	queueKicked   bool
+	conn          network.Conn
+	gate          *Gate
+	userData      interface{}
+	attrs         Attrs
+	connectTime   time.Time
+	transport     string
+	tls           bool
+
+	// the messages and the datagrams are handled one at a time
+	mutexHandle    sync.Mutex
+	datagramToken  string
+	datagramAddr   *net.UDPAddr
+	datagramClosed bool
+	datagrams      chan []byte
+
+	// the event loop agents only, see post
+	eventLoop     bool
+	events        []event
+	eventMsgs     int
+	eventsRunning bool
+	mutexEvents   sync.Mutex
 }
 
 func (a *agent) Run() {
+	if !a.OnOpen() {
+		return
+	}
+
 	for {
 		data, err := a.conn.ReadMsg()
 		if err != nil {
 			log.Debug("read message: %v", err)
 			break
 		}
+		if a.OnMsg(data) != nil {
+			break
+		}
+	}
+}
 
-		if a.gate.Processor != nil {
-			msg, err := a.gate.Processor.Unmarshal(data)
-			if err != nil {
-				log.Debug("unmarshal message error: %v", err)
-				break
-			}
-			err = a.gate.Processor.Route(msg, a)
-			if err != nil {
-				log.Debug("route message error: %v", err)
-				break
-			}
+// false for a rejected agent, notified of the reason
+func (a *agent) OnOpen() bool {
+	if a.rejected {
+		if a.rejectReason != "" {
+			a.gate.Notify(a, a.rejectReason)
 		}
+		return false
 	}
+	return true
 }
 
-func (a *agent) OnClose() {
-	if a.gate.AgentChanRPC != nil {
-		err := a.gate.AgentChanRPC.Call0("CloseAgent", a)
+// handles a message read, an error closes the connection
+func (a *agent) OnMsg(data []byte) error {
+	atomic.AddUint64(&a.bytesIn, uint64(len(data)))
+	a.mutexHandle.Lock()
+	defer a.mutexHandle.Unlock()
+	return a.handle(data)
+}
+
+// passes data through the inbound middlewares then routes it
+func (a *agent) handle(data []byte) error {
+	data, err := a.gate.readData(a, data)
+	if err == ErrDrop {
+		return nil
+	} else if err != nil {
+		log.Debug("read message: %v", err)
+		a.onReadError(err)
+		return err
+	}
+
+	if a.gate.Processor != nil {
+		msg, err := a.gate.Processor.Unmarshal(data)
+		if err != nil {
+			log.Debug("unmarshal message error: %v", err)
+			return err
+		}
+		err = a.gate.readMsg(a, msg)
+		if err == ErrDrop {
+			return nil
+		} else if err != nil {
+			log.Debug("read message %v: %v", reflect.TypeOf(msg), err)
+			a.onReadError(err)
+			return err
+		}
+		// the messages of queued agents only go through the
+		// middlewares, e.g. to pick the lane
+		if a.gate.Queued(a) {
+			return nil
+		}
+		err = a.route(msg)
 		if err != nil {
-			log.Error("chanrpc error: %v", err)
+			log.Debug("route message error: %v", err)
+			return err
 		}
 	}
+	return nil
+}
+
+func (a *agent) OnClose() {
+	if a.rejected {
+		return
+	}
+
+	a.gate.releaseDatagramToken(a)
+	if a.gate.leave(a) {
+		// the agents queued behind a kicked one may have free slots
+		a.gate.admit()
+		return
+	}
+
+	a.gate.unregister(a)
+	a.gate.notifyCloseAgent(a)
 }
 
 func (a *agent) WriteMsg(msg interface{}) {
 	if a.gate.Processor != nil {
-		data, err := a.gate.Processor.Marshal(msg)
+		data, ok := a.encode(msg)
+		if !ok {
+			return
+		}
+		err := a.conn.WriteMsg(data...)
 		if err != nil {
-			log.Error("marshal message %v error: %v", reflect.TypeOf(msg), err)
+			log.Error("write message %v error: %v", reflect.TypeOf(msg), err)
 			return
 		}
+		var n int
+		for _, b := range data {
+			n += len(b)
+		}
+		atomic.AddUint64(&a.bytesOut, uint64(n))
+	}
+}
+
+// passes msg through the outbound middlewares and marshals it, ok is false
+// if msg is not to be written
+func (a *agent) encode(msg interface{}) (data [][]byte, ok bool) {
+	err := a.gate.writeMsg(a, msg)
+	if err != nil {
+		a.onWriteError(msg, err)
+		return nil, false
+	}
+	data, err = a.gate.Processor.Marshal(msg)
+	if err != nil {
+		log.Error("marshal message %v error: %v", reflect.TypeOf(msg), err)
+		return nil, false
+	}
+	data, err = a.gate.writeData(a, data)
+	if err != nil {
+		a.onWriteError(msg, err)
+		return nil, false
+	}
+	return data, true
+}
+
+// the message is written with no unmarshal and marshal, only the
+// WriteData hooks of the middlewares are applied
+// buf may be released once the function returns
+func (a *agent) WriteRaw(buf *network.Buffer) {
+	n := buf.Len()
+	conn, raw := a.conn.(network.RawConn)
+	var err error
+	if raw && !a.gate.hasWriteData() {
+		err = conn.WriteRaw(buf)
+	} else {
+		// the connection or the middlewares may keep the data after
+		// the write, so the parts are copied
+		data := [][]byte{buf.Bytes()}
+		if a.gate.hasWriteData() {
+			data, err = a.gate.writeData(a, data)
+			if err != nil {
+				a.onWriteError(buf, err)
+				return
+			}
+		}
 		err = a.conn.WriteMsg(data...)
-		if err != nil {
+	}
+	if err != nil {
+		log.Error("write raw message error: %v", err)
+		return
+	}
+	atomic.AddUint64(&a.bytesOut, uint64(n))
+}
+
+func (a *agent) onReadError(err error) {
+	if e, ok := err.(*CloseError); ok {
+		a.closeWith(e.Reason)
+	}
+}
+
+func (a *agent) onWriteError(msg interface{}, err error) {
+	switch e := err.(type) {
+	case *CloseError:
+		log.Debug("write message %v: %v", reflect.TypeOf(msg), err)
+		a.closeWith(e.Reason)
+	default:
+		if err != ErrDrop {
 			log.Error("write message %v error: %v", reflect.TypeOf(msg), err)
 		}
 	}
 }
 
+// the client is notified of the reason once, the notice may be closed by a
+// middleware too
+func (a *agent) closeWith(reason string) {
+	if reason != "" && atomic.CompareAndSwapInt32(&a.closeNotified, 0, 1) {
+		a.gate.Notify(a, reason)
+	}
+	a.Close()
+}
+
 func (a *agent) LocalAddr() net.Addr {
 	return a.conn.LocalAddr()
 }
@@ -158,3 +529,22 @@ func (a *agent) UserData() interface{} {
 func (a *agent) SetUserData(data interface{}) {
 	a.userData = data
 }
+
+func (a *agent) ID() uint64 {
+	return a.id
+}
+
+func (a *agent) Attrs() *Attrs {
+	return &a.attrs
+}
+
+func (a *agent) Info() AgentInfo {
+	return AgentInfo{
+		ConnectTime: a.connectTime,
+		Transport:   a.transport,
+		TLS:         a.tls,
+		BytesIn:     atomic.LoadUint64(&a.bytesIn),
+		BytesOut:    atomic.LoadUint64(&a.bytesOut),
+		Processor:   a.gate.Processor,
+	}
+}
@@ -0,0 +1,277 @@
+package gate
+
+import (
+	"fmt"
+	"github.com/name5566/leaf/log"
+	"net"
+	"strings"
+	"time"
+)
+
+type maintenance struct {
+	on       bool
+	ips      []*net.IPNet
+	users    map[string]struct{}
+	gen      int
+	timer    *time.Timer
+	deadline time.Time
+}
+
+func (gate *Gate) maintenanceMsg() string {
+	if gate.MaintenanceMsg == "" {
+		return "server under maintenance"
+	}
+	return gate.MaintenanceMsg
+}
+
+// goroutine safe
+func (gate *Gate) Maintenance() bool {
+	gate.mutexMaintenance.Lock()
+	defer gate.mutexMaintenance.Unlock()
+	return gate.maintenance.on
+}
+
+// goroutine safe
+// turning maintenance mode off cancels the countdown
+func (gate *Gate) SetMaintenance(on bool) {
+	gate.mutexMaintenance.Lock()
+
+	gate.maintenance.on = on
+	if !on {
+		gate.unsafeStopCountdown()
+	}
+	gate.mutexMaintenance.Unlock()
+
+	log.Release("maintenance mode: %v", on)
+	gate.admit()
+}
+
+// goroutine safe
+// turns maintenance mode on, broadcasts the time left every
+// MaintenanceInterval and then kicks the agents not allowlisted
+func (gate *Gate) ScheduleMaintenance(d time.Duration) {
+	gate.mutexMaintenance.Lock()
+	gate.maintenance.on = true
+	gate.unsafeStopCountdown()
+	gate.maintenance.deadline = time.Now().Add(d)
+	gen := gate.maintenance.gen
+	gate.mutexMaintenance.Unlock()
+
+	log.Release("maintenance scheduled in %v", d)
+	gate.admit()
+	gate.countdown(gen)
+}
+
+func (gate *Gate) unsafeStopCountdown() {
+	gate.maintenance.gen++
+	if gate.maintenance.timer != nil {
+		gate.maintenance.timer.Stop()
+		gate.maintenance.timer = nil
+	}
+	gate.maintenance.deadline = time.Time{}
+}
+
+func (gate *Gate) countdown(gen int) {
+	gate.mutexMaintenance.Lock()
+	if gen != gate.maintenance.gen {
+		gate.mutexMaintenance.Unlock()
+		return
+	}
+	left := time.Until(gate.maintenance.deadline)
+	if left <= 0 {
+		gate.maintenance.timer = nil
+		gate.maintenance.deadline = time.Time{}
+		gate.mutexMaintenance.Unlock()
+
+		gate.RangeAgents(func(a Agent) bool {
+			if !gate.maintenanceAllowed(a) {
+				gate.Kick(a, gate.maintenanceMsg())
+			}
+			return true
+		})
+		log.Release("maintenance started")
+		return
+	}
+
+	interval := gate.MaintenanceInterval
+	if interval <= 0 {
+		interval = time.Minute
+	}
+	next := left % interval
+	if next == 0 {
+		next = interval
+	}
+	gate.maintenance.timer = time.AfterFunc(next, func() {
+		gate.countdown(gen)
+	})
+	gate.mutexMaintenance.Unlock()
+
+	notice := gate.MaintenanceNotice
+	if notice == "" {
+		notice = "server maintenance in %v"
+	}
+	gate.Broadcast(fmt.Sprintf(notice, (left+time.Second/2)/time.Second*time.Second))
+}
+
+// goroutine safe
+// ip is an IP address or a CIDR range
+func (gate *Gate) AllowMaintenanceIP(ip string) error {
+	ipNet, err := parseIPNet(ip)
+	if err != nil {
+		return err
+	}
+
+	gate.mutexMaintenance.Lock()
+	defer gate.mutexMaintenance.Unlock()
+	for _, n := range gate.maintenance.ips {
+		if n.String() == ipNet.String() {
+			return nil
+		}
+	}
+	gate.maintenance.ips = append(gate.maintenance.ips, ipNet)
+	return nil
+}
+
+// goroutine safe
+// user IDs are compared by their fmt.Sprint form
+func (gate *Gate) AllowMaintenanceUser(userID interface{}) {
+	gate.mutexMaintenance.Lock()
+	defer gate.mutexMaintenance.Unlock()
+	if gate.maintenance.users == nil {
+		gate.maintenance.users = make(map[string]struct{})
+	}
+	gate.maintenance.users[fmt.Sprint(userID)] = struct{}{}
+}
+
+// goroutine safe
+func (gate *Gate) ClearMaintenanceAllowlist() {
+	gate.mutexMaintenance.Lock()
+	defer gate.mutexMaintenance.Unlock()
+	gate.maintenance.ips = nil
+	gate.maintenance.users = nil
+}
+
+func (gate *Gate) maintenanceAllowedIP(addr net.Addr) bool {
+	ip := addrIP(addr)
+	if ip == nil {
+		return false
+	}
+	for _, n := range gate.maintenance.ips {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func (gate *Gate) maintenanceAllowed(a Agent) bool {
+	gate.mutexMaintenance.Lock()
+	defer gate.mutexMaintenance.Unlock()
+	if gate.maintenanceAllowedIP(a.RemoteAddr()) {
+		return true
+	}
+	if userID, ok := a.Attrs().Lookup(AttrUserID); ok {
+		_, ok = gate.maintenance.users[fmt.Sprint(userID)]
+		return ok
+	}
+	return false
+}
+
+// called on new connections, connections from unknown IPs are kept only
+// when some users are allowlisted, so that they could log in
+func (gate *Gate) maintenanceReject(addr net.Addr) bool {
+	gate.mutexMaintenance.Lock()
+	defer gate.mutexMaintenance.Unlock()
+	return gate.maintenance.on && len(gate.maintenance.users) == 0 &&
+		!gate.maintenanceAllowedIP(addr)
+}
+
+// goroutine safe
+// the login handler calls the function once the user is authenticated,
+// in maintenance mode, agents not allowlisted get MaintenanceMsg and are
+// closed
+func (gate *Gate) AllowLogin(a Agent, userID interface{}) bool {
+	if !gate.Maintenance() {
+		return true
+	}
+
+	gate.mutexMaintenance.Lock()
+	_, ok := gate.maintenance.users[fmt.Sprint(userID)]
+	ok = ok || gate.maintenanceAllowedIP(a.RemoteAddr())
+	gate.mutexMaintenance.Unlock()
+
+	if !ok {
+		gate.Kick(a, gate.maintenanceMsg())
+	}
+	return ok
+}
+
+func usageMaintenance() string {
+	return "Usage: maintenance on|off|status|schedule <duration>|allow ip|user <value>|clear\r\n" +
+		"  on       - reject new connections\r\n" +
+		"  off      - accept new connections and cancel the countdown\r\n" +
+		"  status   - show the maintenance mode and the allowlist\r\n" +
+		"  schedule - turn on, broadcast a countdown, then kick everyone\r\n" +
+		"  allow    - allowlist an IP (or CIDR range) or a user ID\r\n" +
+		"  clear    - clear the allowlist"
+}
+
+func (gate *Gate) commandMaintenance(_args []interface{}) interface{} {
+	args := stringArgs(_args)
+	if len(args) == 0 {
+		return usageMaintenance()
+	}
+
+	switch args[0] {
+	case "on":
+		gate.SetMaintenance(true)
+	case "off":
+		gate.SetMaintenance(false)
+	case "status":
+		gate.mutexMaintenance.Lock()
+		defer gate.mutexMaintenance.Unlock()
+
+		output := fmt.Sprintf("maintenance: %v", gate.maintenance.on)
+		if !gate.maintenance.deadline.IsZero() {
+			output += fmt.Sprintf("\r\nkick in: %v", time.Until(gate.maintenance.deadline)/time.Second*time.Second)
+		}
+		var ips, users []string
+		for _, n := range gate.maintenance.ips {
+			ips = append(ips, n.String())
+		}
+		for u := range gate.maintenance.users {
+			users = append(users, u)
+		}
+		output += "\r\nallowed ips: " + strings.Join(ips, " ")
+		output += "\r\nallowed users: " + strings.Join(users, " ")
+		return output
+	case "schedule":
+		if len(args) != 2 {
+			return usageMaintenance()
+		}
+		d, err := time.ParseDuration(args[1])
+		if err != nil {
+			return err.Error()
+		}
+		gate.ScheduleMaintenance(d)
+	case "allow":
+		if len(args) != 3 {
+			return usageMaintenance()
+		}
+		switch args[1] {
+		case "ip":
+			if err := gate.AllowMaintenanceIP(args[2]); err != nil {
+				return err.Error()
+			}
+		case "user":
+			gate.AllowMaintenanceUser(args[2])
+		default:
+			return usageMaintenance()
+		}
+	case "clear":
+		gate.ClearMaintenanceAllowlist()
+	default:
+		return usageMaintenance()
+	}
+	return ""
+}
@@ -0,0 +1,145 @@
+package gate
+
+import (
+	"errors"
+)
+
+// returned by a middleware to silently discard the current message
+var ErrDrop = errors.New("message dropped")
+
+// returned by a middleware to close the connection
+type CloseError struct {
+	Reason string
+}
+
+func (e *CloseError) Error() string {
+	return "connection closed: " + e.Reason
+}
+
+func CloseWith(reason string) error {
+	return &CloseError{Reason: reason}
+}
+
+// every hook is optional
+//
+// inbound hooks run in registration order, outbound hooks run in reverse
+// registration order, so a middleware registered first sees inbound data
+// first and outbound data last (e.g. decrypt/encrypt)
+type Middleware struct {
+	Name string
+	// raw data read from the connection, before unmarshaling
+	ReadData func(a Agent, data []byte) ([]byte, error)
+	// unmarshaled message, before routing
+	ReadMsg func(a Agent, msg interface{}) error
+	// message passed to Agent.WriteMsg, before marshaling
+	WriteMsg func(a Agent, msg interface{}) error
+	// marshaled data, before writing to the connection
+	WriteData func(a Agent, data [][]byte) ([][]byte, error)
+
+	// the datagrams of Gate.UDPAddr may be lost or reordered, so they only
+	// go through these hooks, not the ones above
+	// raw datagram read, before unmarshaling
+	ReadDatagram func(a Agent, data []byte) ([]byte, error)
+	// marshaled datagram, before writing
+	WriteDatagram func(a Agent, data [][]byte) ([][]byte, error)
+}
+
+// you must call the function before calling Run
+func (gate *Gate) Use(m *Middleware) {
+	if m == nil {
+		panic("middleware must not be nil")
+	}
+	gate.middlewares = append(gate.middlewares, m)
+}
+
+func (gate *Gate) readData(a Agent, data []byte) ([]byte, error) {
+	var err error
+	for _, m := range gate.middlewares {
+		if m.ReadData == nil {
+			continue
+		}
+		data, err = m.ReadData(a, data)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return data, nil
+}
+
+func (gate *Gate) readMsg(a Agent, msg interface{}) error {
+	for _, m := range gate.middlewares {
+		if m.ReadMsg == nil {
+			continue
+		}
+		if err := m.ReadMsg(a, msg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (gate *Gate) writeMsg(a Agent, msg interface{}) error {
+	for i := len(gate.middlewares) - 1; i >= 0; i-- {
+		m := gate.middlewares[i]
+		if m.WriteMsg == nil {
+			continue
+		}
+		if err := m.WriteMsg(a, msg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (gate *Gate) hasWriteData() bool {
+	for _, m := range gate.middlewares {
+		if m.WriteData != nil {
+			return true
+		}
+	}
+	return false
+}
+
+func (gate *Gate) writeData(a Agent, data [][]byte) ([][]byte, error) {
+	var err error
+	for i := len(gate.middlewares) - 1; i >= 0; i-- {
+		m := gate.middlewares[i]
+		if m.WriteData == nil {
+			continue
+		}
+		data, err = m.WriteData(a, data)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return data, nil
+}
+
+func (gate *Gate) readDatagram(a Agent, data []byte) ([]byte, error) {
+	var err error
+	for _, m := range gate.middlewares {
+		if m.ReadDatagram == nil {
+			continue
+		}
+		data, err = m.ReadDatagram(a, data)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return data, nil
+}
+
+func (gate *Gate) writeDatagram(a Agent, data [][]byte) ([][]byte, error) {
+	var err error
+	for i := len(gate.middlewares) - 1; i >= 0; i-- {
+		m := gate.middlewares[i]
+		if m.WriteDatagram == nil {
+			continue
+		}
+		data, err = m.WriteDatagram(a, data)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return data, nil
+}
@@ -0,0 +1,216 @@
+package gate
+
+import (
+	"container/list"
+	"github.com/name5566/leaf/log"
+	"time"
+)
+
+type queue struct {
+	lanes     []*list.List
+	len       int
+	lastAdmit time.Time
+	avgAdmit  time.Duration
+}
+
+func (gate *Gate) queueEnabled() bool {
+	return gate.QueueLen > 0
+}
+
+func (gate *Gate) queueLanes() int {
+	if gate.QueueLanes <= 0 {
+		return 1
+	}
+	return gate.QueueLanes
+}
+
+func (gate *Gate) maxConnNum() int {
+	if gate.MaxConnNum <= 0 {
+		return 100
+	}
+	return gate.MaxConnNum
+}
+
+// registers the agent, or puts it into the queue when the gate is full
+// returns false if the queue is full too
+func (gate *Gate) enter(a *agent) bool {
+	if !gate.queueEnabled() {
+		gate.register(a)
+		return true
+	}
+
+	// the callback may call Queued, QueueNum or SetQueueLane
+	lane := 0
+	if gate.QueueLane != nil {
+		lane = gate.QueueLane(a)
+	}
+
+	gate.mutexQueue.Lock()
+	defer gate.mutexQueue.Unlock()
+
+	if gate.queue.len == 0 && gate.AgentNum() < gate.maxConnNum() {
+		gate.register(a)
+		return true
+	}
+	if gate.queue.len >= gate.QueueLen {
+		return false
+	}
+
+	if gate.queue.lanes == nil {
+		gate.queue.lanes = make([]*list.List, gate.queueLanes())
+		for i := range gate.queue.lanes {
+			gate.queue.lanes[i] = list.New()
+		}
+	}
+	a.lane = gate.clampLane(lane)
+	a.queueElem = gate.queue.lanes[a.lane].PushBack(a)
+	gate.queue.len++
+	return true
+}
+
+func (gate *Gate) clampLane(lane int) int {
+	if lane < 0 {
+		return 0
+	}
+	if n := gate.queueLanes(); lane >= n {
+		return n - 1
+	}
+	return lane
+}
+
+// removes a queued agent, returns false if the agent is not queued
+func (gate *Gate) leave(a *agent) bool {
+	gate.mutexQueue.Lock()
+	defer gate.mutexQueue.Unlock()
+	if a.queueElem == nil {
+		return false
+	}
+
+	gate.queue.lanes[a.lane].Remove(a.queueElem)
+	a.queueElem = nil
+	gate.queue.len--
+	return true
+}
+
+// goroutine safe
+func (gate *Gate) Queued(a Agent) bool {
+	_a, ok := a.(*agent)
+	if !ok {
+		return false
+	}
+
+	gate.mutexQueue.Lock()
+	defer gate.mutexQueue.Unlock()
+	return _a.queueElem != nil
+}
+
+// goroutine safe
+func (gate *Gate) QueueNum() int {
+	gate.mutexQueue.Lock()
+	defer gate.mutexQueue.Unlock()
+	return gate.queue.len
+}
+
+// goroutine safe
+// moves a queued agent to the back of another lane, lane 0 is admitted first
+func (gate *Gate) SetQueueLane(a Agent, lane int) {
+	_a, ok := a.(*agent)
+	if !ok {
+		return
+	}
+
+	gate.mutexQueue.Lock()
+	defer gate.mutexQueue.Unlock()
+	lane = gate.clampLane(lane)
+	if _a.queueElem == nil || _a.lane == lane {
+		return
+	}
+
+	gate.queue.lanes[_a.lane].Remove(_a.queueElem)
+	_a.lane = lane
+	_a.queueElem = gate.queue.lanes[lane].PushBack(_a)
+}
+
+// admits the queued agents while there are free slots, in maintenance
+// mode, the queued agents which would be rejected on connecting are kicked
+// and leave the queue on closing
+func (gate *Gate) admit() {
+	if !gate.queueEnabled() {
+		return
+	}
+
+	var admitted, kicked []*agent
+	gate.mutexQueue.Lock()
+	for _, l := range gate.queue.lanes {
+		for e := l.Front(); e != nil; {
+			next := e.Next()
+			a := e.Value.(*agent)
+			if gate.maintenanceReject(a.RemoteAddr()) {
+				if !a.queueKicked {
+					a.queueKicked = true
+					kicked = append(kicked, a)
+				}
+			} else if gate.AgentNum() < gate.maxConnNum() {
+				l.Remove(e)
+				a.queueElem = nil
+				gate.queue.len--
+				gate.register(a)
+				admitted = append(admitted, a)
+			}
+			e = next
+		}
+	}
+
+	// the agents of a batch are admitted at once, the average is per agent
+	if len(admitted) > 0 {
+		now := time.Now()
+		if !gate.queue.lastAdmit.IsZero() {
+			d := now.Sub(gate.queue.lastAdmit) / time.Duration(len(admitted))
+			if gate.queue.avgAdmit == 0 {
+				gate.queue.avgAdmit = d
+			} else {
+				gate.queue.avgAdmit = (gate.queue.avgAdmit*7 + d) / 8
+			}
+		}
+		gate.queue.lastAdmit = now
+	}
+	gate.mutexQueue.Unlock()
+
+	for _, a := range kicked {
+		gate.Kick(a, gate.maintenanceMsg())
+	}
+	for _, a := range admitted {
+		log.Debug("agent %v admitted from the queue", a.id)
+		if gate.QueueMsg != nil {
+			a.WriteMsg(gate.QueueMsg(0, 0))
+		}
+		gate.notifyNewAgent(a)
+	}
+}
+
+// sends the position and the estimated waiting time to the queued agents
+func (gate *Gate) notifyQueue() {
+	if gate.QueueMsg == nil {
+		return
+	}
+
+	type notice struct {
+		a   *agent
+		pos int
+	}
+	var notices []notice
+	gate.mutexQueue.Lock()
+	pos := 0
+	for _, l := range gate.queue.lanes {
+		for e := l.Front(); e != nil; e = e.Next() {
+			pos++
+			notices = append(notices, notice{e.Value.(*agent), pos})
+		}
+	}
+	avgAdmit := gate.queue.avgAdmit
+	gate.mutexQueue.Unlock()
+
+	for _, n := range notices {
+		n.a.WriteMsg(gate.QueueMsg(n.pos, time.Duration(n.pos)*avgAdmit))
+	}
+}
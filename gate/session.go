@@ -0,0 +1,209 @@
+package gate
+
+import (
+	"net"
+	"sort"
+	"time"
+)
+
+// set by the game logic once an agent is logged in, used by the admin
+// commands to find the agents of a user
+var AttrUserID = NewAttrKey("userID")
+
+type ban struct {
+	ipNet    *net.IPNet
+	deadline time.Time
+}
+
+func (gate *Gate) register(a *agent) {
+	gate.mutexAgents.Lock()
+	gate.lastAgentID++
+	a.id = gate.lastAgentID
+	if gate.agents == nil {
+		gate.agents = make(map[uint64]*agent)
+	}
+	gate.agents[a.id] = a
+	gate.mutexAgents.Unlock()
+}
+
+func (gate *Gate) unregister(a *agent) {
+	gate.mutexAgents.Lock()
+	delete(gate.agents, a.id)
+	gate.mutexAgents.Unlock()
+}
+
+// goroutine safe
+func (gate *Gate) Agent(id uint64) Agent {
+	gate.mutexAgents.Lock()
+	defer gate.mutexAgents.Unlock()
+	if a, ok := gate.agents[id]; ok {
+		return a
+	}
+	return nil
+}
+
+// goroutine safe
+func (gate *Gate) AgentNum() int {
+	gate.mutexAgents.Lock()
+	defer gate.mutexAgents.Unlock()
+	return len(gate.agents)
+}
+
+// goroutine safe
+// the agents are ordered by id, f returns false to stop the iteration
+func (gate *Gate) RangeAgents(f func(a Agent) bool) {
+	for _, a := range gate.sortedAgents() {
+		if !f(a) {
+			return
+		}
+	}
+}
+
+func (gate *Gate) sortedAgents() []*agent {
+	gate.mutexAgents.Lock()
+	agents := make([]*agent, 0, len(gate.agents))
+	for _, a := range gate.agents {
+		agents = append(agents, a)
+	}
+	gate.mutexAgents.Unlock()
+
+	sort.Slice(agents, func(i, j int) bool {
+		return agents[i].id < agents[j].id
+	})
+	return agents
+}
+
+// goroutine safe
+func (gate *Gate) Notify(a Agent, text string) {
+	if gate.SystemMsg == nil {
+		return
+	}
+	a.WriteMsg(gate.SystemMsg(text))
+}
+
+// goroutine safe
+func (gate *Gate) Broadcast(text string) {
+	gate.RangeAgents(func(a Agent) bool {
+		gate.Notify(a, text)
+		return true
+	})
+}
+
+// goroutine safe
+func (gate *Gate) Kick(a Agent, reason string) {
+	if reason != "" {
+		gate.Notify(a, reason)
+	}
+	a.Close()
+}
+
+// goroutine safe
+// ip is an IP address or a CIDR range, d <= 0 bans forever
+func (gate *Gate) Ban(ip string, d time.Duration) error {
+	ipNet, err := parseIPNet(ip)
+	if err != nil {
+		return err
+	}
+
+	b := &ban{ipNet: ipNet}
+	if d > 0 {
+		b.deadline = time.Now().Add(d)
+	}
+
+	gate.mutexBans.Lock()
+	gate.unsafeDelBan(ipNet.String())
+	gate.bans = append(gate.bans, b)
+	gate.mutexBans.Unlock()
+
+	gate.RangeAgents(func(a Agent) bool {
+		if ip := addrIP(a.RemoteAddr()); ip != nil && ipNet.Contains(ip) {
+			gate.Kick(a, "banned")
+		}
+		return true
+	})
+	return nil
+}
+
+// goroutine safe
+func (gate *Gate) Unban(ip string) error {
+	ipNet, err := parseIPNet(ip)
+	if err != nil {
+		return err
+	}
+
+	gate.mutexBans.Lock()
+	gate.unsafeDelBan(ipNet.String())
+	gate.mutexBans.Unlock()
+	return nil
+}
+
+func (gate *Gate) unsafeDelBan(ipNet string) {
+	for i := 0; i < len(gate.bans); i++ {
+		if gate.bans[i].ipNet.String() == ipNet {
+			gate.bans = append(gate.bans[:i], gate.bans[i+1:]...)
+			i--
+		}
+	}
+}
+
+// goroutine safe
+func (gate *Gate) Banned(addr net.Addr) bool {
+	ip := addrIP(addr)
+	if ip == nil {
+		return false
+	}
+
+	gate.mutexBans.Lock()
+	defer gate.mutexBans.Unlock()
+
+	gate.unsafePruneBans()
+	for _, b := range gate.bans {
+		if b.ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// removes the expired bans
+func (gate *Gate) unsafePruneBans() {
+	now := time.Now()
+	for i := 0; i < len(gate.bans); i++ {
+		if b := gate.bans[i]; !b.deadline.IsZero() && now.After(b.deadline) {
+			gate.bans = append(gate.bans[:i], gate.bans[i+1:]...)
+			i--
+		}
+	}
+}
+
+func parseIPNet(s string) (*net.IPNet, error) {
+	if ip := net.ParseIP(s); ip != nil {
+		bits := 8 * net.IPv6len
+		if ip4 := ip.To4(); ip4 != nil {
+			ip = ip4
+			bits = 8 * net.IPv4len
+		}
+		return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, nil
+	}
+
+	_, ipNet, err := net.ParseCIDR(s)
+	return ipNet, err
+}
+
+func addrIP(addr net.Addr) net.IP {
+	if addr == nil {
+		return nil
+	}
+	switch addr := addr.(type) {
+	case *net.TCPAddr:
+		return addr.IP
+	case *net.UDPAddr:
+		return addr.IP
+	}
+
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return nil
+	}
+	return net.ParseIP(host)
+}
@@ -0,0 +1,80 @@
+package idl_test
+
+import (
+	"fmt"
+	"github.com/name5566/leaf/idl"
+	"strings"
+)
+
+func Example() {
+	file, err := idl.Parse(strings.NewReader(`
+package msg "example.com/server/msg"
+processor json
+module game "example.com/server/game"
+
+// greets the server
+message Hello = 0 c2s game {
+	Name string
+}
+
+message Notice = 1 s2c {
+	Text string ` + "`json:\"text\"`" + `
+}
+`))
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	g := new(idl.Generator)
+	outs, err := g.Generate(file)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	for _, out := range outs {
+		fmt.Println(out.Name, out.Stub)
+	}
+	fmt.Print(string(outs[1].Data))
+
+	// Output:
+	// msg/msg.gen.go false
+	// gate/router.gen.go false
+	// game/internal/handler.gen.go false
+	// game/internal/msghandler.go true
+	// // Code generated by leaf gen. DO NOT EDIT.
+	//
+	// package gate
+	//
+	// import (
+	// 	"example.com/server/game"
+	// 	"example.com/server/msg"
+	// )
+	//
+	// func init() {
+	// 	msg.Processor.SetRouter(&msg.Hello{}, game.ChanRPC)
+	// }
+}
+
+func ExampleParse() {
+	file, err := idl.Parse(strings.NewReader(`
+package msg "example.com/server/msg"
+processor json
+
+message Score = 0 s2c {
+	Points  int   ` + "`json:\"p\"`" + `
+	Ints    []int ` + "`json:\"i,omitempty\"`" + `
+}
+`))
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	for _, f := range file.Messages[0].Fields {
+		fmt.Println(f.Name, f.Type, f.Tag)
+	}
+
+	// Output:
+	// Points int `json:"p"`
+	// Ints []int `json:"i,omitempty"`
+}
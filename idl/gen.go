@@ -0,0 +1,251 @@
+package idl
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"path"
+	"sort"
+	"strings"
+)
+
+const header = "// Code generated by leaf gen. DO NOT EDIT.\n\n"
+
+// a generated file, the stubs are meant to be edited and are written only
+// once
+type Output struct {
+	Name string // relative to the project directory
+	Data []byte
+	Stub bool
+}
+
+type Generator struct {
+	LeafPath    string // import path of Leaf
+	GatePackage string // the package routing the messages, "gate" by default
+}
+
+// emits the message types and their registration (msg/msg.gen.go), the
+// routing (gate/router.gen.go) and the typed handlers of each module
+// (game/internal/handler.gen.go and the stubs game/internal/msghandler.go)
+func (g *Generator) Generate(file *File) ([]*Output, error) {
+	if g.LeafPath == "" {
+		g.LeafPath = "github.com/name5566/leaf"
+	}
+	if g.GatePackage == "" {
+		g.GatePackage = "gate"
+	}
+
+	var outs []*Output
+	add := func(name string, w *writer, stub bool) error {
+		data, err := w.bytes()
+		if err != nil {
+			return fmt.Errorf("%v: %v", name, err)
+		}
+		outs = append(outs, &Output{name, data, stub})
+		return nil
+	}
+
+	err := add(path.Join(file.Package, file.Package+".gen.go"), g.genMsg(file), false)
+	if err != nil {
+		return nil, err
+	}
+	err = add(path.Join(g.GatePackage, "router.gen.go"), g.genRouter(file), false)
+	if err != nil {
+		return nil, err
+	}
+	for _, m := range file.Modules {
+		msgs := file.handled(m.Name)
+		if len(msgs) == 0 {
+			continue
+		}
+		err = add(path.Join(m.Name, "internal", "handler.gen.go"), g.genHandlers(file, msgs), false)
+		if err != nil {
+			return nil, err
+		}
+		err = add(path.Join(m.Name, "internal", "msghandler.go"), g.genStubs(file, msgs), true)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return outs, nil
+}
+
+// the messages in id order
+func (file *File) sorted() []*Message {
+	msgs := append([]*Message(nil), file.Messages...)
+	sort.Slice(msgs, func(i, j int) bool {
+		return msgs[i].ID < msgs[j].ID
+	})
+	return msgs
+}
+
+func (file *File) handled(module string) []*Message {
+	var msgs []*Message
+	for _, m := range file.sorted() {
+		if m.Dir == ClientToServer && m.Module == module {
+			msgs = append(msgs, m)
+		}
+	}
+	return msgs
+}
+
+// the type of m seen from package pkg
+func (file *File) typeOf(m *Message, pkg string) string {
+	if m.Type != "" {
+		return m.Type
+	}
+	if pkg == file.Package {
+		return m.Name
+	}
+	return file.Package + "." + m.Name
+}
+
+// adds the import of the type of m
+func (file *File) importOf(w *writer, m *Message) {
+	if m.Type == "" {
+		w.imp("", file.Path)
+		return
+	}
+	i := file.imp(m.Type[:strings.Index(m.Type, ".")])
+	w.imp(i.Name, i.Path)
+}
+
+func (g *Generator) genMsg(file *File) *writer {
+	w := newWriter(file.Package)
+	w.imp("", path.Join(g.LeafPath, "network", file.Processor))
+	msgs := file.sorted()
+
+	w.p("var Processor = %v.NewProcessor()\n\n", file.Processor)
+
+	if file.Processor == "protobuf" {
+		w.p("const (\n")
+		for _, m := range msgs {
+			w.p("ID%v uint16 = %v\n", m.Name, m.ID)
+		}
+		w.p(")\n\n")
+	}
+
+	w.p("func init() {\n")
+	for _, m := range msgs {
+		if m.Type != "" {
+			file.importOf(w, m)
+		}
+		w.p("Processor.Register(&%v{})\n", file.typeOf(m, file.Package))
+	}
+	w.p("}\n")
+
+	for _, m := range msgs {
+		if m.Type != "" {
+			continue
+		}
+		w.p("\n")
+		for _, line := range m.Doc {
+			w.p("// %v\n", line)
+		}
+		w.p("type %v struct {\n", m.Name)
+		for _, f := range m.Fields {
+			w.p("%v %v %v\n", f.Name, f.Type, f.Tag)
+		}
+		w.p("}\n")
+	}
+	return w
+}
+
+func (g *Generator) genRouter(file *File) *writer {
+	w := newWriter(path.Base(g.GatePackage))
+
+	w.p("func init() {\n")
+	for _, m := range file.sorted() {
+		if m.Dir != ClientToServer {
+			continue
+		}
+		file.importOf(w, m)
+		w.imp("", file.Path)
+		w.imp(m.Module, file.module(m.Module).Path)
+		w.p("%v.Processor.SetRouter(&%v{}, %v.ChanRPC)\n",
+			file.Package, file.typeOf(m, ""), m.Module)
+	}
+	w.p("}\n")
+	return w
+}
+
+func (g *Generator) genHandlers(file *File, msgs []*Message) *writer {
+	w := newWriter("internal")
+	w.imp("", path.Join(g.LeafPath, "gate"))
+	w.imp("", "reflect")
+
+	w.p("func init() {\n")
+	for _, m := range msgs {
+		file.importOf(w, m)
+		t := file.typeOf(m, "")
+		w.p("skeleton.RegisterChanRPC(reflect.TypeOf(&%v{}), func(args []interface{}) {\n", t)
+		w.p("handle%v(args[0].(*%v), args[1].(gate.Agent))\n", m.Name, t)
+		w.p("})\n")
+	}
+	w.p("}\n")
+	return w
+}
+
+func (g *Generator) genStubs(file *File, msgs []*Message) *writer {
+	w := newWriter("internal")
+	w.header = false
+	w.imp("", path.Join(g.LeafPath, "gate"))
+
+	for i, m := range msgs {
+		file.importOf(w, m)
+		if i > 0 {
+			w.p("\n")
+		}
+		for _, line := range m.Doc {
+			w.p("// %v\n", line)
+		}
+		w.p("func handle%v(m *%v, a gate.Agent) {\n\n}\n", m.Name, file.typeOf(m, ""))
+	}
+	return w
+}
+
+type writer struct {
+	pkg     string
+	header  bool
+	imports map[string]string
+	body    bytes.Buffer
+}
+
+func newWriter(pkg string) *writer {
+	return &writer{pkg: pkg, header: true, imports: make(map[string]string)}
+}
+
+func (w *writer) imp(name, path string) {
+	w.imports[path] = name
+}
+
+func (w *writer) p(format string, args ...interface{}) {
+	fmt.Fprintf(&w.body, format, args...)
+}
+
+func (w *writer) bytes() ([]byte, error) {
+	var buf bytes.Buffer
+	if w.header {
+		buf.WriteString(header)
+	}
+	fmt.Fprintf(&buf, "package %v\n\n", w.pkg)
+
+	var paths []string
+	for p := range w.imports {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	buf.WriteString("import (\n")
+	for _, p := range paths {
+		if name := w.imports[p]; name != "" && name != path.Base(p) {
+			fmt.Fprintf(&buf, "%v %q\n", name, p)
+		} else {
+			fmt.Fprintf(&buf, "%q\n", p)
+		}
+	}
+	buf.WriteString(")\n\n")
+	buf.Write(w.body.Bytes())
+
+	return format.Source(buf.Bytes())
+}
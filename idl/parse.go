@@ -0,0 +1,307 @@
+package idl
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+const (
+	ClientToServer = "c2s"
+	ServerToClient = "s2c"
+)
+
+// a message definition file, e.g.
+//
+//	package msg "example.com/server/msg"
+//	processor json
+//	module game "example.com/server/game"
+//	import pb "example.com/server/pb"
+//
+//	// greets the server
+//	message Hello = 0 c2s game {
+//		Name string
+//	}
+//	message Move = 1 c2s game pb.Move
+//	message Notice = 2 s2c {
+//		Text string
+//	}
+type File struct {
+	Package   string
+	Path      string // import path of Package
+	Processor string // json or protobuf
+	Modules   []*Module
+	Imports   []*Import
+	Messages  []*Message
+}
+
+type Module struct {
+	Name string
+	Path string
+}
+
+type Import struct {
+	Name string
+	Path string
+}
+
+type Message struct {
+	Doc    []string
+	Name   string
+	ID     int
+	Dir    string // c2s or s2c
+	Module string // the module handling a c2s message
+	Type   string // a type of an import, instead of Fields
+	Fields []*Field
+}
+
+type Field struct {
+	Name string
+	Type string
+	Tag  string
+}
+
+func ParseFile(name string) (*File, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	file, err := Parse(f)
+	if err != nil {
+		return nil, fmt.Errorf("%v:%v", name, err)
+	}
+	return file, nil
+}
+
+func Parse(r io.Reader) (*File, error) {
+	file := new(File)
+	var doc []string
+	var msg *Message
+
+	scanner := bufio.NewScanner(r)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+
+		// comment
+		if strings.HasPrefix(text, "//") {
+			doc = append(doc, strings.TrimSpace(strings.TrimPrefix(text, "//")))
+			continue
+		}
+		if text == "" {
+			doc = nil
+			continue
+		}
+
+		// fields
+		if msg != nil {
+			if text == "}" {
+				msg = nil
+				continue
+			}
+			field, err := parseField(text)
+			if err != nil {
+				return nil, fmt.Errorf("%v: %v", line, err)
+			}
+			msg.Fields = append(msg.Fields, field)
+			continue
+		}
+
+		words := strings.Fields(text)
+		var err error
+		switch words[0] {
+		case "package":
+			if len(words) != 3 {
+				err = fmt.Errorf("usage: package <name> <path>")
+				break
+			}
+			file.Package = words[1]
+			file.Path, err = strconv.Unquote(words[2])
+		case "processor":
+			if len(words) != 2 {
+				err = fmt.Errorf("usage: processor json|protobuf")
+				break
+			}
+			file.Processor = words[1]
+		case "module", "import":
+			if len(words) != 3 {
+				err = fmt.Errorf("usage: %v <name> <path>", words[0])
+				break
+			}
+			var path string
+			path, err = strconv.Unquote(words[2])
+			if words[0] == "module" {
+				file.Modules = append(file.Modules, &Module{words[1], path})
+			} else {
+				file.Imports = append(file.Imports, &Import{words[1], path})
+			}
+		case "message":
+			var m *Message
+			m, err = parseMessage(words)
+			if err != nil {
+				break
+			}
+			m.Doc = doc
+			file.Messages = append(file.Messages, m)
+			if words[len(words)-1] == "{" {
+				msg = m
+			}
+		default:
+			err = fmt.Errorf("unknown statement %v", words[0])
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%v: %v", line, err)
+		}
+		doc = nil
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if msg != nil {
+		return nil, fmt.Errorf("%v: message %v not closed", line, msg.Name)
+	}
+
+	err := file.check()
+	if err != nil {
+		return nil, err
+	}
+	return file, nil
+}
+
+// message <name> = <id> c2s|s2c [module] [type|{]
+func parseMessage(words []string) (*Message, error) {
+	if len(words) < 5 || words[2] != "=" {
+		return nil, fmt.Errorf("usage: message <name> = <id> c2s|s2c [module] <type>|{")
+	}
+
+	m := new(Message)
+	m.Name = words[1]
+	id, err := strconv.Atoi(words[3])
+	if err != nil || id < 0 {
+		return nil, fmt.Errorf("invalid id %v of message %v", words[3], m.Name)
+	}
+	m.ID = id
+	m.Dir = words[4]
+
+	rest := words[5:]
+	if m.Dir == ClientToServer && len(rest) > 0 && rest[0] != "{" {
+		m.Module = rest[0]
+		rest = rest[1:]
+	}
+	if len(rest) != 1 {
+		return nil, fmt.Errorf("message %v: type or { expected", m.Name)
+	}
+	if rest[0] != "{" {
+		m.Type = rest[0]
+	}
+	return m, nil
+}
+
+// <name> <type> [tag]
+func parseField(text string) (*Field, error) {
+	words := strings.Fields(text)
+	if len(words) < 2 {
+		return nil, fmt.Errorf("usage: <name> <type> [tag]")
+	}
+
+	field := &Field{Name: words[0], Type: words[1]}
+	if len(words) > 2 {
+		// the rest after the name and the type
+		tag := strings.TrimSpace(text)
+		for i := 0; i < 2; i++ {
+			tag = strings.TrimLeftFunc(tag[strings.IndexFunc(tag, unicode.IsSpace):], unicode.IsSpace)
+		}
+		tag = strings.TrimSpace(tag)
+		if !strings.HasPrefix(tag, "`") || !strings.HasSuffix(tag, "`") {
+			return nil, fmt.Errorf("field %v: invalid tag %v", field.Name, tag)
+		}
+		field.Tag = tag
+	}
+	return field, nil
+}
+
+func (file *File) check() error {
+	if file.Package == "" {
+		return fmt.Errorf("package required")
+	}
+	switch file.Processor {
+	case "json", "protobuf":
+	default:
+		return fmt.Errorf("unknown processor %q", file.Processor)
+	}
+
+	names := make(map[string]bool)
+	ids := make(map[int]string)
+	for _, m := range file.Messages {
+		if names[m.Name] {
+			return fmt.Errorf("message %v is already defined", m.Name)
+		}
+		names[m.Name] = true
+		if other, ok := ids[m.ID]; ok {
+			return fmt.Errorf("messages %v and %v have the same id %v", other, m.Name, m.ID)
+		}
+		ids[m.ID] = m.Name
+
+		switch m.Dir {
+		case ClientToServer:
+			if m.Module == "" {
+				return fmt.Errorf("message %v: module required", m.Name)
+			}
+			if file.module(m.Module) == nil {
+				return fmt.Errorf("message %v: unknown module %v", m.Name, m.Module)
+			}
+		case ServerToClient:
+		default:
+			return fmt.Errorf("message %v: unknown direction %v", m.Name, m.Dir)
+		}
+
+		if m.Type != "" {
+			i := strings.Index(m.Type, ".")
+			if i < 0 || file.imp(m.Type[:i]) == nil {
+				return fmt.Errorf("message %v: unknown import of %v", m.Name, m.Type)
+			}
+			if m.Type[i+1:] != m.Name {
+				// the processors identify the messages by type name
+				return fmt.Errorf("message %v: type %v has another name", m.Name, m.Type)
+			}
+		} else if file.Processor == "protobuf" {
+			return fmt.Errorf("message %v: protobuf messages must reference proto types", m.Name)
+		}
+	}
+
+	// the protobuf ids follow the registration order
+	if file.Processor == "protobuf" {
+		for id := 0; id < len(file.Messages); id++ {
+			if _, ok := ids[id]; !ok {
+				return fmt.Errorf("message id %v missing, protobuf ids start at 0 with no gap", id)
+			}
+		}
+	}
+
+	return nil
+}
+
+func (file *File) module(name string) *Module {
+	for _, m := range file.Modules {
+		if m.Name == name {
+			return m
+		}
+	}
+	return nil
+}
+
+func (file *File) imp(name string) *Import {
+	for _, i := range file.Imports {
+		if i.Name == name {
+			return i
+		}
+	}
+	return nil
+}
@@ -33,6 +33,7 @@ func Run(mods ...module.Module) {
 	cluster.Init()
 
 	// console
+	module.RegisterCommands()
 	console.Init()
 
 	// close
@@ -7,6 +7,8 @@ import (
 	"os"
 	"path"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -100,7 +102,9 @@ func (logger *Logger) doPrintf(level int, printLevel string, format string, a ..
 	}
 
 	format = printLevel + format
-	logger.baseLogger.Output(3, fmt.Sprintf(format, a...))
+	line := fmt.Sprintf(format, a...)
+	logger.baseLogger.Output(3, line)
+	tap(line)
 
 	if level == fatalLevel {
 		os.Exit(1)
@@ -151,3 +155,49 @@ func Fatal(format string, a ...interface{}) {
 func Close() {
 	gLogger.Close()
 }
+
+type logTap struct {
+	id int
+	f  func(line string)
+}
+
+var (
+	// a []logTap copied on write, the printing goroutines take no lock
+	taps      atomic.Value
+	lastTap   int
+	mutexTaps sync.Mutex
+)
+
+// f receives the printed lines with their level, e.g. for the console
+// `tail log`, f must not block
+// goroutine safe
+func Tap(f func(line string)) (untap func()) {
+	mutexTaps.Lock()
+	defer mutexTaps.Unlock()
+
+	lastTap++
+	id := lastTap
+	old, _ := taps.Load().([]logTap)
+	taps.Store(append(old[:len(old):len(old)], logTap{id, f}))
+
+	return func() {
+		mutexTaps.Lock()
+		defer mutexTaps.Unlock()
+
+		old, _ := taps.Load().([]logTap)
+		var list []logTap
+		for _, t := range old {
+			if t.id != id {
+				list = append(list, t)
+			}
+		}
+		taps.Store(list)
+	}
+}
+
+func tap(line string) {
+	list, _ := taps.Load().([]logTap)
+	for _, t := range list {
+		t.f(line)
+	}
+}
@@ -0,0 +1,209 @@
+package module
+
+import (
+	"errors"
+	"fmt"
+	"github.com/name5566/leaf/chanrpc"
+	"github.com/name5566/leaf/conf"
+	"github.com/name5566/leaf/log"
+	"github.com/name5566/leaf/timer"
+	"runtime"
+	"time"
+)
+
+var (
+	ErrCoTimeout = errors.New("coroutine timeout")
+	ErrCoClosed  = errors.New("coroutine closed")
+)
+
+// how a coroutine is resumed
+const (
+	coResume = iota
+	coTimeout
+	coClose
+)
+
+// a handler written as straight-line code, it runs on its own goroutine
+// but only while the skeleton goroutine waits for it, so it is serialized
+// with the other handlers of the module as if it ran on the skeleton
+// goroutine, the module state may be used between the waits
+type Co struct {
+	// each wait longer than Timeout panics with ErrCoTimeout, 0 for no
+	// timeout, needs TimerDispatcherLen
+	Timeout time.Duration
+
+	s      *Skeleton
+	resume chan int
+	yield  chan struct{}
+	seq    int
+	ret    interface{}
+	err    error
+	done   bool
+}
+
+// runs f as a coroutine until its first wait, a panic or a timeout in f is
+// recovered and logged, the waits still pending when the module closes
+// panic with ErrCoClosed so that the deferred calls of f run
+// you must call the function on the skeleton goroutine
+func (s *Skeleton) Async(f func(co *Co)) {
+	co := new(Co)
+	co.s = s
+	co.resume = make(chan int)
+	co.yield = make(chan struct{})
+	if s.cos == nil {
+		s.cos = make(map[*Co]struct{})
+	}
+	s.cos[co] = struct{}{}
+
+	go func() {
+		defer func() {
+			r := recover()
+			if r == ErrCoClosed {
+				r = nil
+			}
+			if r != nil {
+				if conf.LenStackBuf > 0 {
+					buf := make([]byte, conf.LenStackBuf)
+					l := runtime.Stack(buf, false)
+					log.Error("%v: %s", r, buf[:l])
+				} else {
+					log.Error("%v", r)
+				}
+			}
+			co.done = true
+			co.yield <- struct{}{}
+		}()
+
+		<-co.resume
+		f(co)
+	}()
+	co.switchTo(coResume)
+}
+
+// on the skeleton goroutine, runs the coroutine until its next wait
+func (co *Co) switchTo(how int) {
+	co.resume <- how
+	<-co.yield
+	if co.done {
+		delete(co.s.cos, co)
+	}
+}
+
+// on the skeleton goroutine at the close of the module, the coroutines
+// still waiting are ended
+func (s *Skeleton) closeCos() {
+	for len(s.cos) > 0 {
+		for co := range s.cos {
+			co.switchTo(coClose)
+			break
+		}
+	}
+}
+
+// on the coroutine goroutine, start begins the work and done ends it on
+// the skeleton goroutine or at once within start
+func (co *Co) wait(start func(done func(ret interface{}, err error))) (interface{}, error) {
+	co.seq++
+	seq := co.seq
+	var t *timer.Timer
+	waiting, finished := false, false
+	finish := func(how int, ret interface{}, err error) {
+		// a late end after a timeout
+		if co.seq != seq {
+			return
+		}
+		co.seq++
+		if t != nil {
+			t.Stop()
+		}
+		co.ret, co.err = ret, err
+		if !waiting {
+			finished = true
+			return
+		}
+		co.switchTo(how)
+	}
+
+	start(func(ret interface{}, err error) {
+		finish(coResume, ret, err)
+	})
+	if !finished {
+		if co.Timeout > 0 {
+			t = co.s.AfterFunc(co.Timeout, func() {
+				finish(coTimeout, nil, nil)
+			})
+		}
+		waiting = true
+		co.yield <- struct{}{}
+		switch <-co.resume {
+		case coTimeout:
+			panic(ErrCoTimeout)
+		case coClose:
+			co.seq++
+			if t != nil {
+				t.Stop()
+			}
+			panic(ErrCoClosed)
+		}
+	}
+
+	ret, err := co.ret, co.err
+	co.ret, co.err = nil, nil
+	return ret, err
+}
+
+// runs f by Skeleton.Go and waits for it, a panic in f is logged and
+// returned as an error
+func (co *Co) Go(f func()) error {
+	_, err := co.wait(func(done func(interface{}, error)) {
+		var err error
+		co.s.Go(func() {
+			defer func() {
+				if r := recover(); r != nil {
+					err = fmt.Errorf("%v", r)
+					panic(r)
+				}
+			}()
+			f()
+		}, func() {
+			done(nil, err)
+		})
+	})
+	return err
+}
+
+func (co *Co) Sleep(d time.Duration) {
+	co.wait(func(done func(interface{}, error)) {
+		co.s.AfterFunc(d, func() {
+			done(nil, nil)
+		})
+	})
+}
+
+// calls another module by Skeleton.AsynCall and waits for the return
+func (co *Co) Call0(server *chanrpc.Server, id interface{}, args ...interface{}) error {
+	_, err := co.wait(func(done func(interface{}, error)) {
+		co.s.AsynCall(server, id, append(args[:len(args):len(args)], func(err error) {
+			done(nil, err)
+		})...)
+	})
+	return err
+}
+
+func (co *Co) Call1(server *chanrpc.Server, id interface{}, args ...interface{}) (interface{}, error) {
+	return co.wait(func(done func(interface{}, error)) {
+		co.s.AsynCall(server, id, append(args[:len(args):len(args)], func(ret interface{}, err error) {
+			done(ret, err)
+		})...)
+	})
+}
+
+func (co *Co) CallN(server *chanrpc.Server, id interface{}, args ...interface{}) ([]interface{}, error) {
+	ret, err := co.wait(func(done func(interface{}, error)) {
+		co.s.AsynCall(server, id, append(args[:len(args):len(args)], func(ret []interface{}, err error) {
+			done(ret, err)
+		})...)
+	})
+	rets, _ := ret.([]interface{})
+	return rets, err
+}
@@ -0,0 +1,175 @@
+package module_test
+
+import (
+	"fmt"
+	"github.com/name5566/leaf/chanrpc"
+	"github.com/name5566/leaf/log"
+	"github.com/name5566/leaf/module"
+	"time"
+)
+
+func init() {
+	// keep the output of the examples clean
+	logger, _ := log.New("fatal", "", 0)
+	log.Export(logger)
+}
+
+func newSkeleton() *module.Skeleton {
+	s := &module.Skeleton{
+		GoLen:              10,
+		TimerDispatcherLen: 10,
+		AsynCallLen:        10,
+		ChanRPCServer:      chanrpc.NewServer(10),
+	}
+	s.Init()
+	return s
+}
+
+// runs s until the returned function is called
+func run(s *module.Skeleton) func() {
+	closeSig := make(chan bool, 1)
+	done := make(chan struct{})
+	go func() {
+		s.Run(closeSig)
+		close(done)
+	}()
+	return func() {
+		closeSig <- true
+		<-done
+	}
+}
+
+func ExampleSkeleton_Async() {
+	// another module
+	remote := chanrpc.NewServer(10)
+	remote.Register("add", func(args []interface{}) interface{} {
+		return args[0].(int) + args[1].(int)
+	})
+	remote.Register("slow", func(args []interface{}) {
+		time.Sleep(100 * time.Millisecond)
+	})
+	go func() {
+		for ci := range remote.ChanCall {
+			remote.Exec(ci)
+		}
+	}()
+
+	s := newSkeleton()
+	stop := run(s)
+	defer stop()
+
+	done := make(chan struct{})
+	s.RegisterChanRPC("start", func(args []interface{}) {
+		s.Async(func(co *module.Co) {
+			defer close(done)
+
+			sum, err := co.Call1(remote, "add", 1, 2)
+			fmt.Println(sum, err)
+
+			co.Sleep(10 * time.Millisecond)
+			fmt.Println("slept")
+
+			err = co.Go(func() {
+				panic("boom")
+			})
+			fmt.Println(err)
+
+			defer func() {
+				fmt.Println(recover())
+			}()
+			co.Timeout = 20 * time.Millisecond
+			co.Call0(remote, "slow")
+			fmt.Println("not printed")
+		})
+	})
+	s.ChanRPCServer.Go("start")
+	<-done
+
+	// a panic in a coroutine leaves the module running
+	s.RegisterChanRPC("panic", func(args []interface{}) {
+		s.Async(func(co *module.Co) {
+			panic("boom")
+		})
+	})
+	s.RegisterChanRPC("ping", func(args []interface{}) interface{} {
+		return "pong"
+	})
+	s.ChanRPCServer.Call0("panic")
+	fmt.Println(s.ChanRPCServer.Call1("ping"))
+
+	// Output:
+	// 3 <nil>
+	// slept
+	// boom
+	// coroutine timeout
+	// pong <nil>
+}
+
+func ExampleSkeleton_Async_close() {
+	s := newSkeleton()
+	stop := run(s)
+
+	s.RegisterChanRPC("start", func(args []interface{}) {
+		s.Async(func(co *module.Co) {
+			defer func() {
+				fmt.Println("released:", recover())
+			}()
+			co.Sleep(time.Hour)
+		})
+	})
+	s.ChanRPCServer.Call0("start")
+
+	// the coroutine waiting is ended with the module
+	stop()
+
+	// Output:
+	// released: coroutine closed
+}
+
+func ExampleSkeleton_RegisterSource() {
+	s := newSkeleton()
+
+	events := make(chan int)
+	closed := make(chan struct{})
+	s.RegisterSource(events, func(v interface{}, ok bool) {
+		fmt.Println("event", v, ok)
+		if !ok {
+			close(closed)
+		}
+	})
+	pending := make(chan string, 2)
+	s.RegisterSource(pending, func(v interface{}, ok bool) {
+		fmt.Println("pending", v)
+	})
+	// a source registered on the skeleton goroutine
+	later := make(chan int)
+	s.RegisterChanRPC("later", func(args []interface{}) {
+		s.RegisterSource(later, func(v interface{}, ok bool) {
+			fmt.Println("later", v)
+		})
+	})
+	stop := run(s)
+
+	events <- 1
+	events <- 2
+	close(events)
+	<-closed
+
+	s.ChanRPCServer.Call0("later")
+	later <- 3
+	later <- 4
+
+	// the values buffered at the close are handled before Run returns
+	pending <- "a"
+	pending <- "b"
+	stop()
+
+	// Output:
+	// event 1 true
+	// event 2 true
+	// event <nil> false
+	// later 3
+	// later 4
+	// pending a
+	// pending b
+}
@@ -2,8 +2,12 @@ package module
 
 import (
 	"github.com/name5566/leaf/conf"
+	"github.com/name5566/leaf/console"
 	"github.com/name5566/leaf/log"
+	"reflect"
 	"runtime"
+	"sort"
+	"strings"
 	"sync"
 )
 
@@ -21,6 +25,56 @@ type module struct {
 
 var mods []*module
 
+// a snapshot of the queues of a module, the modules with no Skeleton only
+// have a name
+type Stat struct {
+	Name    string
+	ChanRPC int    // calls waiting
+	Go      int    // callbacks waiting
+	Timer   int    // timers waiting
+	Events  uint64 // handled since the start
+}
+
+type statter interface {
+	stat() Stat
+}
+
+// registers the watch `modules` of the console, e.g. `top modules`
+// you must call the function before calling console.Init
+func RegisterCommands() {
+	console.RegisterWatch("modules", func() interface{} {
+		return Stats()
+	})
+}
+
+// the busiest modules first
+// goroutine safe once the modules are initialized
+func Stats() []Stat {
+	stats := make([]Stat, len(mods))
+	for i, m := range mods {
+		if s, ok := m.mi.(statter); ok {
+			stats[i] = s.stat()
+		}
+		stats[i].Name = moduleName(m.mi)
+	}
+	sort.SliceStable(stats, func(i, j int) bool {
+		return stats[i].ChanRPC+stats[i].Go+stats[i].Timer > stats[j].ChanRPC+stats[j].Go+stats[j].Timer
+	})
+	return stats
+}
+
+// the package of the module, e.g. server/game for server/game/internal.Module
+func moduleName(mi Module) string {
+	t := reflect.TypeOf(mi)
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.PkgPath() == "" {
+		return t.String()
+	}
+	return strings.TrimSuffix(t.PkgPath(), "/internal")
+}
+
 func Register(mi Module) {
 	m := new(module)
 	m.mi = mi
@@ -2,9 +2,15 @@ package module
 
 import (
 	"github.com/name5566/leaf/chanrpc"
+	"github.com/name5566/leaf/conf"
 	"github.com/name5566/leaf/console"
 	"github.com/name5566/leaf/go"
+	"github.com/name5566/leaf/log"
 	"github.com/name5566/leaf/timer"
+	"reflect"
+	"runtime"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -18,6 +24,32 @@ type Skeleton struct {
 	client             *chanrpc.Client
 	server             *chanrpc.Server
 	commandServer      *chanrpc.Server
+	events             uint64
+	cos                map[*Co]struct{}
+
+	// the sources are selected by a forwarding goroutine, Run selects the
+	// built-in channels with no reflection
+	mutexSources   sync.Mutex
+	sources        []*source
+	sourceEvents   chan sourceEvent
+	sourcesChanged chan struct{}
+	stopSources    chan struct{}
+	sourcesDone    chan struct{}
+	pendingEvent   *sourceEvent
+	running        bool
+}
+
+// a channel of the module handled on the skeleton goroutine
+type source struct {
+	ch reflect.Value
+	f  func(v interface{}, ok bool)
+}
+
+// a value received from a source, ok false once it is closed
+type sourceEvent struct {
+	src *source
+	v   interface{}
+	ok  bool
 }
 
 func (s *Skeleton) Init() {
@@ -40,18 +72,18 @@ func (s *Skeleton) Init() {
 		s.server = chanrpc.NewServer(0)
 	}
 	s.commandServer = chanrpc.NewServer(0)
+	s.sourceEvents = make(chan sourceEvent)
+	s.sourcesChanged = make(chan struct{}, 1)
 }
 
 func (s *Skeleton) Run(closeSig chan bool) {
+	s.running = true
+	s.forwardSources()
+
 	for {
 		select {
 		case <-closeSig:
-			s.commandServer.Close()
-			s.server.Close()
-			for !s.g.Idle() || !s.client.Idle() {
-				s.g.Close()
-				s.client.Close()
-			}
+			s.close()
 			return
 		case ri := <-s.client.ChanAsynRet:
 			s.client.Cb(ri)
@@ -63,7 +95,162 @@ func (s *Skeleton) Run(closeSig chan bool) {
 			s.g.Cb(cb)
 		case t := <-s.dispatcher.ChanTimer:
 			t.Cb()
+		case e := <-s.sourceEvents:
+			e.src.call(e.v, e.ok)
+		}
+		atomic.AddUint64(&s.events, 1)
+	}
+}
+
+func (s *Skeleton) close() {
+	s.commandServer.Close()
+	s.server.Close()
+	if s.stopSources != nil {
+		close(s.stopSources)
+		<-s.sourcesDone
+		if e := s.pendingEvent; e != nil {
+			s.pendingEvent = nil
+			e.src.call(e.v, e.ok)
+		}
+	}
+	// the values already in the sources are handled, not the later ones
+	for _, src := range s.sources {
+		for n := src.ch.Len(); n > 0; n-- {
+			v, ok := src.ch.TryRecv()
+			if !ok {
+				break
+			}
+			src.call(v.Interface(), true)
+		}
+	}
+	for !s.g.Idle() || !s.client.Idle() {
+		s.g.Close()
+		s.client.Close()
+	}
+	s.closeCos()
+}
+
+// starts the forwarding goroutine once there is a source
+func (s *Skeleton) forwardSources() {
+	s.mutexSources.Lock()
+	n := len(s.sources)
+	s.mutexSources.Unlock()
+	if !s.running || s.stopSources != nil || n == 0 {
+		return
+	}
+	s.stopSources = make(chan struct{})
+	s.sourcesDone = make(chan struct{})
+	go s.forward(s.stopSources, s.sourcesDone)
+}
+
+// hands the values of the sources one at a time to Run, a value received
+// but not handed at the close is left in pendingEvent
+func (s *Skeleton) forward(stop chan struct{}, done chan struct{}) {
+	defer close(done)
+
+	var sources []*source
+	var cases []reflect.SelectCase
+	for {
+		if cases == nil {
+			s.mutexSources.Lock()
+			sources = append([]*source(nil), s.sources...)
+			s.mutexSources.Unlock()
+
+			cases = []reflect.SelectCase{
+				{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(stop)},
+				{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(s.sourcesChanged)},
+			}
+			for _, src := range sources {
+				cases = append(cases, reflect.SelectCase{Dir: reflect.SelectRecv, Chan: src.ch})
+			}
+		}
+
+		chosen, v, ok := reflect.Select(cases)
+		switch chosen {
+		case 0:
+			return
+		case 1:
+			cases = nil
+			continue
+		}
+
+		e := sourceEvent{src: sources[chosen-2], ok: ok}
+		if ok {
+			e.v = v.Interface()
+		} else {
+			// a closed source is removed
+			s.removeSource(e.src)
+			cases = nil
+		}
+		select {
+		case s.sourceEvents <- e:
+		case <-stop:
+			s.pendingEvent = &e
+			return
+		}
+	}
+}
+
+func (s *Skeleton) removeSource(src *source) {
+	s.mutexSources.Lock()
+	defer s.mutexSources.Unlock()
+
+	for i, _src := range s.sources {
+		if _src == src {
+			s.sources = append(s.sources[:i:i], s.sources[i+1:]...)
+			return
+		}
+	}
+}
+
+func (src *source) call(v interface{}, ok bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			if conf.LenStackBuf > 0 {
+				buf := make([]byte, conf.LenStackBuf)
+				l := runtime.Stack(buf, false)
+				log.Error("%v: %s", r, buf[:l])
+			} else {
+				log.Error("%v", r)
+			}
 		}
+	}()
+
+	src.f(v, ok)
+}
+
+// f is called on the skeleton goroutine with each value received from ch,
+// and once with ok false when ch is closed, then ch is removed, the values
+// buffered in ch at the close of the module are handled before Run returns
+// you must call the function before Run, e.g. in OnInit, or on the skeleton
+// goroutine
+func (s *Skeleton) RegisterSource(ch interface{}, f func(v interface{}, ok bool)) {
+	v := reflect.ValueOf(ch)
+	if v.Kind() != reflect.Chan || v.Type().ChanDir()&reflect.RecvDir == 0 {
+		panic("invalid source channel")
+	}
+	if f == nil {
+		panic("invalid source function")
+	}
+
+	s.mutexSources.Lock()
+	s.sources = append(s.sources, &source{ch: v, f: f})
+	s.mutexSources.Unlock()
+
+	select {
+	case s.sourcesChanged <- struct{}{}:
+	default:
+	}
+	s.forwardSources()
+}
+
+// goroutine safe
+func (s *Skeleton) stat() Stat {
+	return Stat{
+		ChanRPC: len(s.server.ChanCall),
+		Go:      len(s.g.ChanCb),
+		Timer:   len(s.dispatcher.ChanTimer),
+		Events:  atomic.LoadUint64(&s.events),
 	}
 }
 
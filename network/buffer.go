@@ -0,0 +1,64 @@
+package network
+
+import (
+	"sync/atomic"
+)
+
+// a reference-counted message shared by several connections, e.g. when
+// forwarding or broadcasting, the parts are written with no copy
+// goroutine safe
+type Buffer struct {
+	refs  int32
+	parts [][]byte
+	free  func()
+}
+
+// the buffer holds one reference, the parts must not be modified by the
+// others goroutines
+func NewBuffer(parts ...[]byte) *Buffer {
+	return &Buffer{refs: 1, parts: parts}
+}
+
+// f is called once the last reference is released, e.g. to return the
+// parts to a pool
+func (buf *Buffer) SetFree(f func()) {
+	buf.free = f
+}
+
+func (buf *Buffer) Parts() [][]byte {
+	return buf.parts
+}
+
+// a copy of the parts merged, it is still valid after Release
+func (buf *Buffer) Bytes() []byte {
+	b := make([]byte, 0, buf.Len())
+	for _, p := range buf.parts {
+		b = append(b, p...)
+	}
+	return b
+}
+
+func (buf *Buffer) Len() int {
+	var l int
+	for _, p := range buf.parts {
+		l += len(p)
+	}
+	return l
+}
+
+func (buf *Buffer) Retain() *Buffer {
+	if atomic.AddInt32(&buf.refs, 1) <= 1 {
+		panic("retain a released buffer")
+	}
+	return buf
+}
+
+func (buf *Buffer) Release() {
+	refs := atomic.AddInt32(&buf.refs, -1)
+	if refs < 0 {
+		panic("release a released buffer")
+	}
+	if refs == 0 && buf.free != nil {
+		buf.free()
+	}
+}
@@ -12,3 +12,8 @@ type Conn interface {
 	Close()
 	Destroy()
 }
+
+// implemented by the conns able to write a shared buffer with no copy
+type RawConn interface {
+	WriteRaw(buf *Buffer) error
+}
@@ -0,0 +1,777 @@
+//go:build linux
+
+package network
+
+import (
+	"errors"
+	"github.com/name5566/leaf/conf"
+	"github.com/name5566/leaf/log"
+	"io"
+	"net"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+type epollLoop struct {
+	epfd  int
+	wake  [2]int
+	mutex sync.Mutex
+	conns map[int]*EpollConn
+}
+
+func newEpollLoop() (*epollLoop, error) {
+	epfd, err := syscall.EpollCreate1(syscall.EPOLL_CLOEXEC)
+	if err != nil {
+		return nil, err
+	}
+
+	l := new(epollLoop)
+	l.epfd = epfd
+	l.conns = make(map[int]*EpollConn)
+	err = syscall.Pipe2(l.wake[:], syscall.O_NONBLOCK|syscall.O_CLOEXEC)
+	if err != nil {
+		syscall.Close(epfd)
+		return nil, err
+	}
+	ev := syscall.EpollEvent{Events: syscall.EPOLLIN, Fd: int32(l.wake[0])}
+	err = syscall.EpollCtl(epfd, syscall.EPOLL_CTL_ADD, l.wake[0], &ev)
+	if err != nil {
+		l.close()
+		return nil, err
+	}
+	return l, nil
+}
+
+func (l *epollLoop) close() {
+	syscall.Close(l.wake[0])
+	syscall.Close(l.wake[1])
+	syscall.Close(l.epfd)
+}
+
+func (l *epollLoop) add(c *EpollConn) error {
+	l.mutex.Lock()
+	l.conns[c.fd] = c
+	l.mutex.Unlock()
+
+	ev := syscall.EpollEvent{Events: syscall.EPOLLIN | syscall.EPOLLRDHUP | syscall.EPOLLONESHOT, Fd: int32(c.fd)}
+	err := syscall.EpollCtl(l.epfd, syscall.EPOLL_CTL_ADD, c.fd, &ev)
+	if err != nil {
+		l.remove(c.fd)
+	}
+	return err
+}
+
+// before the fd is closed, a later fd may have the same number
+func (l *epollLoop) remove(fd int) {
+	syscall.EpollCtl(l.epfd, syscall.EPOLL_CTL_DEL, fd, nil)
+	l.mutex.Lock()
+	delete(l.conns, fd)
+	l.mutex.Unlock()
+}
+
+func (l *epollLoop) run() {
+	defer l.close()
+
+	events := make([]syscall.EpollEvent, 256)
+	for {
+		n, err := syscall.EpollWait(l.epfd, events, -1)
+		if err == syscall.EINTR {
+			continue
+		} else if err != nil {
+			log.Error("epoll wait error: %v", err)
+			return
+		}
+
+		for i := 0; i < n; i++ {
+			fd := int(events[i].Fd)
+			if fd == l.wake[0] {
+				return
+			}
+			l.mutex.Lock()
+			c := l.conns[fd]
+			l.mutex.Unlock()
+			if c != nil {
+				c.onEvent(events[i].Events)
+			}
+		}
+	}
+}
+
+func (l *epollLoop) stop() {
+	syscall.Write(l.wake[1], []byte{0})
+}
+
+func (server *EpollServer) Start() {
+	server.init()
+
+	for _, l := range server.loops {
+		server.wgLoops.Add(1)
+		go func(l *epollLoop) {
+			defer server.wgLoops.Done()
+			l.run()
+		}(l)
+	}
+	for i := 0; i < server.Workers; i++ {
+		server.wgWorkers.Add(1)
+		go server.work()
+	}
+	go server.sweep()
+	for _, ln := range server.lns {
+		server.wgLn.Add(1)
+		go server.run(ln)
+	}
+}
+
+func (server *EpollServer) init() {
+	lns, err := listen(server.Addr, server.AcceptShards)
+	if err != nil {
+		log.Fatal("%v", err)
+	}
+
+	if server.MaxConnNum <= 0 {
+		server.MaxConnNum = 100
+		log.Release("invalid MaxConnNum, reset to %v", server.MaxConnNum)
+	}
+	if server.PendingWriteNum <= 0 {
+		server.PendingWriteNum = 100
+		log.Release("invalid PendingWriteNum, reset to %v", server.PendingWriteNum)
+	}
+	if server.HandshakeTimeout <= 0 {
+		server.HandshakeTimeout = 3 * time.Second
+	}
+	if server.Loops <= 0 {
+		server.Loops = runtime.NumCPU()
+	}
+	if server.Workers <= 0 {
+		server.Workers = 4 * runtime.NumCPU()
+	}
+	if server.NewAgent == nil {
+		log.Fatal("NewAgent must not be nil")
+	}
+
+	server.lns = lns
+	server.conns = make(map[*EpollConn]struct{})
+	server.reads.init()
+	server.closeSig = make(chan struct{})
+	for i := 0; i < server.Loops; i++ {
+		l, err := newEpollLoop()
+		if err != nil {
+			log.Fatal("epoll error: %v", err)
+		}
+		server.loops = append(server.loops, l)
+	}
+
+	// msg parser
+	msgParser := NewMsgParser()
+	msgParser.SetMsgLen(server.LenMsgLen, server.MinMsgLen, server.MaxMsgLen)
+	msgParser.SetByteOrder(server.LittleEndian)
+	server.msgParser = msgParser
+}
+
+func (server *EpollServer) run(ln net.Listener) {
+	defer server.wgLn.Done()
+
+	var tempDelay time.Duration
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				if tempDelay == 0 {
+					tempDelay = 5 * time.Millisecond
+				} else {
+					tempDelay *= 2
+				}
+				if max := 1 * time.Second; tempDelay > max {
+					tempDelay = max
+				}
+				log.Release("accept error: %v; retrying in %v", err, tempDelay)
+				time.Sleep(tempDelay)
+				continue
+			}
+			return
+		}
+		tempDelay = 0
+		log.Debug(conn.RemoteAddr().String())
+		err = server.SockOpts.Apply(conn)
+		if err != nil {
+			log.Error("socket options error: %v", err)
+		}
+
+		server.add(conn)
+	}
+}
+
+// the fd of conn is duplicated for the event loop, conn is closed
+func (server *EpollServer) add(conn net.Conn) {
+	server.mutexConns.Lock()
+	if server.conns == nil || len(server.conns) >= server.MaxConnNum {
+		server.mutexConns.Unlock()
+		conn.Close()
+		log.Debug("too many connections")
+		return
+	}
+
+	fd, err := dupFd(conn)
+	conn.Close()
+	if err != nil {
+		server.mutexConns.Unlock()
+		log.Error("dup fd error: %v", err)
+		return
+	}
+
+	c := new(EpollConn)
+	c.fd = fd
+	c.server = server
+	c.loop = server.loops[server.nextLoop%len(server.loops)]
+	server.nextLoop++
+	c.localAddr = conn.LocalAddr()
+	c.remoteAddr = conn.RemoteAddr()
+	atomic.StoreInt64(&c.deadline, time.Now().Add(server.HandshakeTimeout).UnixNano())
+	server.conns[c] = struct{}{}
+	server.wgConns.Add(1)
+	server.mutexConns.Unlock()
+
+	err = c.loop.add(c)
+	if err != nil {
+		log.Error("epoll add error: %v", err)
+		syscall.Close(fd)
+		server.remove(c)
+	}
+}
+
+func dupFd(conn net.Conn) (int, error) {
+	sc, ok := conn.(syscall.Conn)
+	if !ok {
+		return 0, errors.New("not a socket")
+	}
+	rc, err := sc.SyscallConn()
+	if err != nil {
+		return 0, err
+	}
+
+	var fd int
+	var errDup error
+	err = rc.Control(func(s uintptr) {
+		fd, errDup = syscall.Dup(int(s))
+	})
+	if err != nil {
+		return 0, err
+	}
+	if errDup != nil {
+		return 0, errDup
+	}
+	syscall.CloseOnExec(fd)
+	err = syscall.SetNonblock(fd, true)
+	if err != nil {
+		syscall.Close(fd)
+		return 0, err
+	}
+	return fd, nil
+}
+
+func (server *EpollServer) remove(c *EpollConn) {
+	server.mutexConns.Lock()
+	delete(server.conns, c)
+	server.mutexConns.Unlock()
+	server.wgConns.Done()
+}
+
+func (server *EpollServer) work() {
+	defer server.wgWorkers.Done()
+
+	buf := make([]byte, 64*1024)
+	for {
+		c, ok := server.reads.pop()
+		if !ok {
+			return
+		}
+		c.read(buf)
+	}
+}
+
+// closes the connections past their handshake or read deadline
+func (server *EpollServer) sweep() {
+	interval := time.Second
+	for _, d := range []time.Duration{server.HandshakeTimeout, server.ReadTimeout} {
+		if d > 0 && d/4 < interval {
+			interval = max(d/4, 10*time.Millisecond)
+		}
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-server.closeSig:
+			return
+		case <-ticker.C:
+		}
+
+		now := time.Now().UnixNano()
+		var expired []*EpollConn
+		server.mutexConns.Lock()
+		for c := range server.conns {
+			if d := atomic.LoadInt64(&c.deadline); d != 0 && d < now {
+				expired = append(expired, c)
+			}
+		}
+		server.mutexConns.Unlock()
+
+		for _, c := range expired {
+			log.Debug("close conn %v: read timeout", c.remoteAddr)
+			c.Destroy()
+		}
+	}
+}
+
+func (server *EpollServer) Close() {
+	for _, ln := range server.lns {
+		ln.Close()
+	}
+	server.wgLn.Wait()
+
+	server.mutexConns.Lock()
+	var conns []*EpollConn
+	for c := range server.conns {
+		conns = append(conns, c)
+	}
+	server.conns = nil
+	server.mutexConns.Unlock()
+	for _, c := range conns {
+		c.Destroy()
+	}
+	server.wgConns.Wait()
+
+	close(server.closeSig)
+	for _, l := range server.loops {
+		l.stop()
+	}
+	server.wgLoops.Wait()
+	server.reads.close()
+	server.wgWorkers.Wait()
+}
+
+// a connection of EpollServer, its messages are read by the workers and
+// written at once or by its event loop once the socket is writable
+type EpollConn struct {
+	// accessed atomically, keep it 64-bit aligned
+	deadline int64 // unix nano, 0 for none
+
+	mutex      sync.Mutex
+	fd         int
+	server     *EpollServer
+	loop       *epollLoop
+	localAddr  net.Addr
+	remoteAddr net.Addr
+
+	// owned by the worker reading
+	agent     Agent
+	event     EventAgent
+	handshook bool
+	rejected  bool
+	partial   []byte
+	msgStart  time.Time
+	budget    int64
+	msgs      chan []byte // for Run, nil for an EventAgent
+	runDone   chan struct{}
+	torn      chan struct{}
+
+	// guarded by mutex
+	reading   bool
+	pending   [][]byte
+	closeFlag bool
+	killed    bool
+	destroyed bool
+}
+
+// on the event loop
+func (c *EpollConn) onEvent(events uint32) {
+	c.mutex.Lock()
+	if c.destroyed {
+		c.mutex.Unlock()
+		return
+	}
+	if events&syscall.EPOLLOUT != 0 {
+		c.flush()
+	}
+	read := !c.reading && events&(syscall.EPOLLIN|syscall.EPOLLRDHUP|syscall.EPOLLHUP|syscall.EPOLLERR) != 0
+	if read {
+		c.reading = true
+	}
+	c.arm()
+	c.mutex.Unlock()
+
+	if read {
+		c.server.reads.push(c)
+	}
+}
+
+// the events are one-shot, the worker reading rearms them once done
+// must be called with the mutex held
+func (c *EpollConn) arm() {
+	if c.destroyed || c.reading && len(c.pending) == 0 {
+		return
+	}
+
+	events := uint32(syscall.EPOLLRDHUP | syscall.EPOLLONESHOT)
+	if !c.reading {
+		events |= syscall.EPOLLIN
+	}
+	if len(c.pending) > 0 {
+		events |= syscall.EPOLLOUT
+	}
+	ev := syscall.EpollEvent{Events: events, Fd: int32(c.fd)}
+	syscall.EpollCtl(c.loop.epfd, syscall.EPOLL_CTL_MOD, c.fd, &ev)
+}
+
+// on a worker
+func (c *EpollConn) read(buf []byte) {
+	defer func() {
+		if r := recover(); r != nil {
+			if conf.LenStackBuf > 0 {
+				buf := make([]byte, conf.LenStackBuf)
+				l := runtime.Stack(buf, false)
+				log.Error("%v: %s", r, buf[:l])
+			} else {
+				log.Error("%v", r)
+			}
+			c.teardown()
+		}
+	}()
+
+	err := c.readAll(buf)
+	if err != nil {
+		if err != io.EOF {
+			log.Debug("read message: %v", err)
+		}
+		c.teardown()
+		return
+	}
+
+	c.mutex.Lock()
+	c.reading = false
+	c.arm()
+	c.mutex.Unlock()
+}
+
+// nil once the socket has no more data for now
+func (c *EpollConn) readAll(buf []byte) error {
+	// the other connections of the loop get their turn
+	for i := 0; i < 16; i++ {
+		n, err := syscall.Read(c.fd, buf)
+		if err == syscall.EINTR {
+			continue
+		} else if err == syscall.EAGAIN {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if n == 0 {
+			return io.EOF
+		}
+
+		err = c.process(buf[:n])
+		if err != nil {
+			return err
+		}
+		if n < len(buf) {
+			return nil
+		}
+	}
+	return nil
+}
+
+func (c *EpollConn) process(data []byte) error {
+	if !c.handshook {
+		n := min(3-len(c.partial), len(data))
+		c.partial = append(c.partial, data[:n]...)
+		data = data[n:]
+		if len(c.partial) < 3 {
+			return nil
+		}
+		if string(c.partial) != "{{{" {
+			return errors.New("invalid header")
+		}
+		c.partial = nil
+		c.handshook = true
+		atomic.StoreInt64(&c.deadline, 0)
+		if !c.open() {
+			c.rejected = true
+			c.Close()
+		}
+	}
+	if c.rejected {
+		return nil
+	}
+
+	b := data
+	if len(c.partial) > 0 {
+		c.partial = append(c.partial, data...)
+		b = c.partial
+	}
+
+	p := c.server.msgParser
+	var msgLen uint32
+	for {
+		var ok bool
+		var err error
+		msgLen, ok, err = p.peekLen(b)
+		if err != nil {
+			return err
+		}
+		if !ok || len(b) < p.lenMsgLen+int(msgLen) {
+			break
+		}
+
+		msg := make([]byte, msgLen)
+		copy(msg, b[p.lenMsgLen:])
+		b = b[p.lenMsgLen+int(msgLen):]
+		c.releaseBudget()
+		c.msgStart = time.Time{}
+		err = c.deliver(msg)
+		if err != nil {
+			return err
+		}
+	}
+
+	// the start of a message
+	if len(b) == 0 {
+		c.partial = nil
+		atomic.StoreInt64(&c.deadline, 0)
+		return nil
+	}
+	c.partial = append([]byte(nil), b...)
+	if budget := c.server.ReadBudget; budget != nil && c.budget == 0 && len(b) >= p.lenMsgLen {
+		if !budget.Acquire(int64(msgLen)) {
+			return errors.New("too many bytes in flight")
+		}
+		c.budget = int64(msgLen)
+	}
+	if c.msgStart.IsZero() {
+		c.msgStart = time.Now()
+	}
+	if c.server.ReadTimeout > 0 {
+		deadline := c.msgStart.Add(c.server.ReadTimeout)
+		if c.server.MinReadRate > 0 {
+			deadline = deadline.Add(time.Duration(len(c.partial)) * time.Second / time.Duration(c.server.MinReadRate))
+		}
+		atomic.StoreInt64(&c.deadline, deadline.UnixNano())
+	}
+	return nil
+}
+
+func (c *EpollConn) releaseBudget() {
+	if c.budget > 0 {
+		c.server.ReadBudget.Release(c.budget)
+		c.budget = 0
+	}
+}
+
+// false if the agent rejects the connection
+func (c *EpollConn) open() bool {
+	c.agent = c.server.NewAgent(c)
+	if a, ok := c.agent.(EventAgent); ok {
+		c.event = a
+		return a.OnOpen()
+	}
+
+	c.msgs = make(chan []byte, c.server.PendingWriteNum)
+	c.runDone = make(chan struct{})
+	c.torn = make(chan struct{})
+	go func() {
+		c.agent.Run()
+		c.Close()
+		close(c.runDone)
+
+		<-c.torn
+		c.agent.OnClose()
+		c.server.remove(c)
+	}()
+	return true
+}
+
+// the worker must not wait for Run, a connection whose agent lags behind
+// by PendingWriteNum messages is closed
+func (c *EpollConn) deliver(msg []byte) error {
+	if c.event != nil {
+		return c.event.OnMsg(msg)
+	}
+
+	select {
+	case c.msgs <- msg:
+		return nil
+	case <-c.runDone:
+		return io.EOF
+	default:
+		return errors.New("too many pending messages")
+	}
+}
+
+// on the worker reading, the agent is closed once
+func (c *EpollConn) teardown() {
+	c.mutex.Lock()
+	if c.destroyed {
+		c.mutex.Unlock()
+		return
+	}
+	c.destroyed = true
+	c.closeFlag = true
+	c.pending = nil
+	c.loop.remove(c.fd)
+	syscall.Close(c.fd)
+	c.mutex.Unlock()
+
+	c.releaseBudget()
+	atomic.StoreInt64(&c.deadline, 0)
+	if c.msgs != nil {
+		// the goroutine of Run closes the agent once Run returns, the
+		// worker does not wait for it
+		close(c.msgs)
+		close(c.torn)
+		return
+	}
+	if c.agent != nil {
+		c.agent.OnClose()
+	}
+	c.server.remove(c)
+}
+
+// the worker reading sees the end of the connection and tears it down
+// must be called with the mutex held
+func (c *EpollConn) kill() {
+	if c.destroyed || c.killed {
+		return
+	}
+	c.killed = true
+	c.closeFlag = true
+	c.pending = nil
+	syscall.Shutdown(c.fd, syscall.SHUT_RDWR)
+}
+
+// must be called with the mutex held
+func (c *EpollConn) flush() {
+	for len(c.pending) > 0 {
+		b := c.pending[0]
+		n, err := syscall.Write(c.fd, b)
+		if n == len(b) {
+			c.pending[0] = nil
+			c.pending = c.pending[1:]
+		} else if n > 0 {
+			c.pending[0] = b[n:]
+		}
+		if err == syscall.EAGAIN {
+			return
+		} else if err != nil && err != syscall.EINTR {
+			c.kill()
+			return
+		}
+	}
+	c.pending = nil
+	if c.closeFlag {
+		c.kill()
+	}
+}
+
+// must be called with the mutex held
+func (c *EpollConn) doWrite(b []byte) {
+	for len(c.pending) == 0 && len(b) > 0 {
+		n, err := syscall.Write(c.fd, b)
+		if n > 0 {
+			b = b[n:]
+		}
+		if err == syscall.EAGAIN {
+			break
+		} else if err != nil && err != syscall.EINTR {
+			c.kill()
+			return
+		}
+	}
+	if len(b) == 0 {
+		return
+	}
+
+	if len(c.pending) >= c.server.PendingWriteNum {
+		log.Debug("close conn: channel full")
+		c.doDestroy()
+		return
+	}
+	c.pending = append(c.pending, b)
+	if len(c.pending) == 1 {
+		c.arm()
+	}
+}
+
+// b must not be modified by the others goroutines
+func (c *EpollConn) Write(b []byte) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.closeFlag || b == nil {
+		return
+	}
+
+	c.doWrite(b)
+}
+
+// the messages go to OnMsg for an EventAgent
+func (c *EpollConn) ReadMsg() ([]byte, error) {
+	if c.msgs == nil {
+		return nil, errors.New("the messages go to OnMsg")
+	}
+
+	msg, ok := <-c.msgs
+	if !ok {
+		return nil, io.EOF
+	}
+	return msg, nil
+}
+
+func (c *EpollConn) WriteMsg(args ...[]byte) error {
+	msg, err := c.server.msgParser.Pack(args...)
+	if err != nil {
+		return err
+	}
+
+	c.Write(msg)
+	return nil
+}
+
+func (c *EpollConn) LocalAddr() net.Addr {
+	return c.localAddr
+}
+
+func (c *EpollConn) RemoteAddr() net.Addr {
+	return c.remoteAddr
+}
+
+// the connection is closed once the pending messages are written
+func (c *EpollConn) Close() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.closeFlag {
+		return
+	}
+
+	c.closeFlag = true
+	if len(c.pending) == 0 {
+		c.kill()
+	}
+}
+
+// must be called with the mutex held
+func (c *EpollConn) doDestroy() {
+	if c.destroyed {
+		return
+	}
+	syscall.SetsockoptLinger(c.fd, syscall.SOL_SOCKET, syscall.SO_LINGER, &syscall.Linger{Onoff: 1, Linger: 0})
+	c.kill()
+}
+
+func (c *EpollConn) Destroy() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.doDestroy()
+}
@@ -0,0 +1,42 @@
+//go:build !linux
+
+package network
+
+import (
+	"errors"
+	"github.com/name5566/leaf/log"
+	"net"
+)
+
+type epollLoop struct{}
+
+func (server *EpollServer) Start() {
+	log.Fatal("EpollServer is only supported on linux")
+}
+
+func (server *EpollServer) Close() {}
+
+// linux only
+type EpollConn struct{}
+
+func (c *EpollConn) ReadMsg() ([]byte, error) {
+	return nil, errors.New("EpollConn is only supported on linux")
+}
+
+func (c *EpollConn) WriteMsg(args ...[]byte) error {
+	return errors.New("EpollConn is only supported on linux")
+}
+
+func (c *EpollConn) Write(b []byte) {}
+
+func (c *EpollConn) LocalAddr() net.Addr {
+	return nil
+}
+
+func (c *EpollConn) RemoteAddr() net.Addr {
+	return nil
+}
+
+func (c *EpollConn) Close() {}
+
+func (c *EpollConn) Destroy() {}
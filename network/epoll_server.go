@@ -0,0 +1,111 @@
+package network
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+// implemented by the agents of EpollServer, the worker reading the
+// connection calls OnMsg with each message in order instead of running Run
+// on a goroutine, the other agents still run Run on a goroutine
+type EventAgent interface {
+	Agent
+	// called once the connection header is read, false closes the
+	// connection once the pending messages are written
+	OnOpen() bool
+	// an error closes the connection, the workers are shared by the
+	// connections so OnMsg should not block for long, e.g. a full chanrpc
+	// server holds the worker until it drains, the gate agents hand their
+	// chanrpc calls over to a goroutine of their own
+	OnMsg(data []byte) error
+}
+
+// a tcp server with epoll event loops and a pool of workers in place of the
+// reader and writer goroutines of each connection, for the servers holding
+// many idle connections, linux only
+type EpollServer struct {
+	Addr            string
+	MaxConnNum      int
+	PendingWriteNum int
+	NewAgent        func(*EpollConn) Agent
+
+	Loops   int // epoll instances, runtime.NumCPU() by default
+	Workers int // goroutines reading the connections, 4 * runtime.NumCPU() by default
+
+	// read limits, ReadTimeout 0 means no limit
+	HandshakeTimeout time.Duration // to read the connection header
+	ReadTimeout      time.Duration // to read a message once its first byte arrives
+	MinReadRate      int           // bytes per second, extends ReadTimeout
+	ReadBudget       *ByteBudget   // bytes of the messages being read, a message beyond closes its connection
+
+	// listeners sharing Addr by SO_REUSEPORT, each with its own accept
+	// goroutine, 0 or 1 for one listener
+	AcceptShards int
+	// options of the accepted connections
+	SockOpts SockOpts
+
+	lns        []net.Listener
+	loops      []*epollLoop
+	nextLoop   int
+	reads      readQueue
+	conns      map[*EpollConn]struct{}
+	mutexConns sync.Mutex
+	closeSig   chan struct{}
+	wgLn       sync.WaitGroup
+	wgConns    sync.WaitGroup
+	wgLoops    sync.WaitGroup
+	wgWorkers  sync.WaitGroup
+
+	// msg parser
+	LenMsgLen    int
+	MinMsgLen    uint32
+	MaxMsgLen    uint32
+	LittleEndian bool
+	msgParser    *MsgParser
+}
+
+// the connections waiting for a worker to read them, never blocks the event
+// loops, a connection is queued once at most so the queue is bounded by
+// MaxConnNum
+type readQueue struct {
+	mutex  sync.Mutex
+	cond   sync.Cond
+	conns  []*EpollConn
+	closed bool
+}
+
+func (q *readQueue) init() {
+	q.cond.L = &q.mutex
+}
+
+func (q *readQueue) push(c *EpollConn) {
+	q.mutex.Lock()
+	q.conns = append(q.conns, c)
+	q.mutex.Unlock()
+	q.cond.Signal()
+}
+
+// false once the queue is closed and empty
+func (q *readQueue) pop() (*EpollConn, bool) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	for len(q.conns) == 0 && !q.closed {
+		q.cond.Wait()
+	}
+	if len(q.conns) == 0 {
+		return nil, false
+	}
+
+	c := q.conns[0]
+	q.conns[0] = nil
+	q.conns = q.conns[1:]
+	return c, true
+}
+
+func (q *readQueue) close() {
+	q.mutex.Lock()
+	q.closed = true
+	q.mutex.Unlock()
+	q.cond.Broadcast()
+}
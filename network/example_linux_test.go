@@ -0,0 +1,222 @@
+package network_test
+
+import (
+	"fmt"
+	"github.com/name5566/leaf/network"
+	"golang.org/x/sys/unix"
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+type eventAgent struct {
+	conn   *network.EpollConn
+	closed *int32
+}
+
+func (a *eventAgent) Run() {}
+
+func (a *eventAgent) OnClose() {
+	atomic.AddInt32(a.closed, 1)
+}
+
+func (a *eventAgent) OnOpen() bool {
+	return true
+}
+
+func (a *eventAgent) OnMsg(data []byte) error {
+	return a.conn.WriteMsg(data)
+}
+
+func ExampleEpollServer() {
+	var closed int32
+	server := new(network.EpollServer)
+	server.Addr = "127.0.0.1:3571"
+	server.MaxConnNum = 10
+	server.PendingWriteNum = 10
+	server.LenMsgLen = 2
+	server.HandshakeTimeout = 100 * time.Millisecond
+	server.NewAgent = func(conn *network.EpollConn) network.Agent {
+		return &eventAgent{conn: conn, closed: &closed}
+	}
+	server.Start()
+
+	// the header and the message split over several reads
+	conn, err := net.Dial("tcp", server.Addr)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	defer conn.Close()
+	msg := frame("My name is Leaf")
+	for _, b := range [][]byte{[]byte("{{"), append([]byte("{"), msg[:1]...), msg[1:6], msg[6:]} {
+		conn.Write(b)
+		time.Sleep(10 * time.Millisecond)
+	}
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	fmt.Println(readFrame(conn))
+
+	// no header
+	idle, err := net.Dial("tcp", server.Addr)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	defer idle.Close()
+	idle.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, err = readFrame(idle)
+	fmt.Println("idle:", err)
+
+	// the live connections are closed
+	server.Close()
+	_, err = readFrame(conn)
+	fmt.Println("closed:", err, atomic.LoadInt32(&closed))
+
+	// Output:
+	// My name is Leaf <nil>
+	// idle: EOF
+	// closed: EOF 1
+}
+
+type runAgent struct {
+	conn    *network.EpollConn
+	release chan struct{}
+	closed  chan struct{}
+}
+
+func (a *runAgent) Run() {
+	<-a.release
+	for {
+		if _, err := a.conn.ReadMsg(); err != nil {
+			return
+		}
+	}
+}
+
+func (a *runAgent) OnClose() {
+	close(a.closed)
+}
+
+func ExampleEpollServer_run() {
+	a := &runAgent{release: make(chan struct{}), closed: make(chan struct{})}
+	server := new(network.EpollServer)
+	server.Addr = "127.0.0.1:3572"
+	server.MaxConnNum = 10
+	server.PendingWriteNum = 2
+	server.LenMsgLen = 2
+	server.Workers = 1
+	server.NewAgent = func(conn *network.EpollConn) network.Agent {
+		a.conn = conn
+		return a
+	}
+	server.Start()
+	defer server.Close()
+
+	// Run lags behind, the connection is closed and the worker goes on
+	conn, err := net.Dial("tcp", server.Addr)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	defer conn.Close()
+	b := []byte("{{{")
+	for i := 0; i < 4; i++ {
+		b = append(b, frame("hello")...)
+	}
+	conn.Write(b)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, err = readFrame(conn)
+	fmt.Println(err)
+
+	close(a.release)
+	<-a.closed
+	fmt.Println("agent closed")
+
+	// Output:
+	// EOF
+	// agent closed
+}
+
+// the options of conn read back from the system
+func sockOpts(conn net.Conn) (s string) {
+	rc, err := conn.(*net.TCPConn).SyscallConn()
+	if err != nil {
+		return err.Error()
+	}
+	rc.Control(func(fd uintptr) {
+		opt := func(level, name int) int {
+			v, err := unix.GetsockoptInt(int(fd), level, name)
+			if err != nil {
+				return -1
+			}
+			return v
+		}
+		linger, err := unix.GetsockoptLinger(int(fd), unix.SOL_SOCKET, unix.SO_LINGER)
+		if err != nil {
+			s = err.Error()
+			return
+		}
+		s = fmt.Sprintf("nodelay %v rcvbuf %v sndbuf %v keepalive %v idle %v linger %v %v",
+			opt(unix.IPPROTO_TCP, unix.TCP_NODELAY),
+			opt(unix.SOL_SOCKET, unix.SO_RCVBUF),
+			opt(unix.SOL_SOCKET, unix.SO_SNDBUF),
+			opt(unix.SOL_SOCKET, unix.SO_KEEPALIVE),
+			opt(unix.IPPROTO_TCP, unix.TCP_KEEPIDLE),
+			linger.Onoff, linger.Linger)
+	})
+	return
+}
+
+func ExampleSockOpts_Apply() {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	defer conn.Close()
+
+	// linux doubles the buffer sizes, the linger is rounded up to seconds
+	opts := network.SockOpts{
+		Delay:       true,
+		ReadBuffer:  4096,
+		WriteBuffer: 8192,
+		KeepAlive:   time.Minute,
+		Linger:      1500 * time.Millisecond,
+	}
+	fmt.Println(opts.Apply(conn))
+	fmt.Println(sockOpts(conn))
+
+	opts.KeepAlive = -1
+	opts.Linger = -1
+	fmt.Println(opts.Apply(conn))
+	fmt.Println(sockOpts(conn))
+
+	// the conns other than TCP are left as is
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+	defer c2.Close()
+	fmt.Println(opts.Apply(c1))
+
+	// Output:
+	// <nil>
+	// nodelay 0 rcvbuf 8192 sndbuf 16384 keepalive 1 idle 60 linger 1 2
+	// <nil>
+	// nodelay 0 rcvbuf 8192 sndbuf 16384 keepalive 0 idle 60 linger 1 0
+	// <nil>
+}
@@ -0,0 +1,472 @@
+package network_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"github.com/gorilla/websocket"
+	"github.com/name5566/leaf/log"
+	"github.com/name5566/leaf/network"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+func init() {
+	// keep the output of the examples clean
+	logger, _ := log.New("fatal", "", 0)
+	log.Export(logger)
+}
+
+// a message with a 2 bytes big endian length
+func frame(msg string) []byte {
+	b := make([]byte, 2+len(msg))
+	binary.BigEndian.PutUint16(b, uint16(len(msg)))
+	copy(b[2:], msg)
+	return b
+}
+
+func readFrame(conn net.Conn) (string, error) {
+	var l [2]byte
+	if _, err := io.ReadFull(conn, l[:]); err != nil {
+		return "", err
+	}
+	b := make([]byte, binary.BigEndian.Uint16(l[:]))
+	if _, err := io.ReadFull(conn, b); err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+type echoAgent struct {
+	conn   network.Conn
+	closed chan struct{}
+}
+
+func (a *echoAgent) Run() {
+	for {
+		data, err := a.conn.ReadMsg()
+		if err != nil {
+			return
+		}
+		a.conn.WriteMsg(data)
+	}
+}
+
+func (a *echoAgent) OnClose() {
+	close(a.closed)
+}
+
+// the messages of a long polling request or response
+func lpBody(msgs ...string) io.Reader {
+	var b []byte
+	for _, msg := range msgs {
+		b = binary.BigEndian.AppendUint32(b, uint32(len(msg)))
+		b = append(b, msg...)
+	}
+	return bytes.NewReader(b)
+}
+
+func lpPoll(url string) string {
+	resp, err := http.Get(url)
+	if err != nil {
+		return err.Error()
+	}
+	defer resp.Body.Close()
+	b, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != 200 {
+		return resp.Status
+	}
+
+	var msgs []string
+	for len(b) >= 4 {
+		l := binary.BigEndian.Uint32(b)
+		msgs = append(msgs, string(b[4:4+l]))
+		b = b[4+l:]
+	}
+	return "X-Seq " + resp.Header.Get("X-Seq") + ": " + strings.Join(msgs, " ")
+}
+
+func ExampleLPConn() {
+	a := &echoAgent{closed: make(chan struct{})}
+	server := new(network.WSServer)
+	server.Addr = "127.0.0.1:3574"
+	server.MaxConnNum = 10
+	server.PendingWriteNum = 10
+	server.MaxMsgLen = 4096
+	server.HTTPTimeout = time.Second
+	server.LPPath = "/lp"
+	server.LPSessionTimeout = 300 * time.Millisecond
+	server.NewAgent = func(conn *network.WSConn) network.Agent {
+		return &echoAgent{conn: conn, closed: make(chan struct{})}
+	}
+	server.NewLPAgent = func(conn *network.LPConn) network.Agent {
+		a.conn = conn
+		return a
+	}
+	server.Start()
+	defer server.Close()
+
+	url := "http://" + server.Addr + server.LPPath
+	resp, err := http.Post(url, "", nil)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	sid, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	url += "?sid=" + string(sid)
+
+	// the messages are sent and received in batches
+	http.Post(url+"&seq=1", "", lpBody("a", "b"))
+	time.Sleep(50 * time.Millisecond)
+	fmt.Println(lpPoll(url + "&ack=0"))
+
+	// resent until acked
+	fmt.Println(lpPoll(url + "&ack=0"))
+
+	// the messages already received are skipped
+	http.Post(url+"&seq=2", "", lpBody("b", "c"))
+	time.Sleep(50 * time.Millisecond)
+	fmt.Println(lpPoll(url + "&ack=2"))
+
+	// the session times out with no request
+	time.Sleep(500 * time.Millisecond)
+	<-a.closed
+	fmt.Println(lpPoll(url + "&ack=3"))
+
+	// Output:
+	// X-Seq 1: a b
+	// X-Seq 1: a b
+	// X-Seq 3: c
+	// 410 Gone
+}
+
+func ExampleWSServer_maxConnNum() {
+	server := new(network.WSServer)
+	server.Addr = "127.0.0.1:3575"
+	server.MaxConnNum = 1
+	server.PendingWriteNum = 10
+	server.MaxMsgLen = 4096
+	server.HTTPTimeout = time.Second
+	server.LPPath = "/lp"
+	server.NewAgent = func(conn *network.WSConn) network.Agent {
+		return &echoAgent{conn: conn, closed: make(chan struct{})}
+	}
+	server.NewLPAgent = func(conn *network.LPConn) network.Agent {
+		return &echoAgent{conn: conn, closed: make(chan struct{})}
+	}
+	server.Start()
+	defer server.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws://"+server.Addr, nil)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	defer conn.Close()
+	time.Sleep(50 * time.Millisecond)
+
+	// the long polling sessions count too
+	resp, err := http.Post("http://"+server.Addr+server.LPPath, "", nil)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	resp.Body.Close()
+	fmt.Println(resp.Status)
+
+	// Output:
+	// 503 Service Unavailable
+}
+
+func ExampleTCPServer_readTimeout() {
+	server := new(network.TCPServer)
+	server.Addr = "127.0.0.1:3586"
+	server.MaxConnNum = 10
+	server.PendingWriteNum = 10
+	server.LenMsgLen = 2
+	server.HandshakeTimeout = 50 * time.Millisecond
+	server.ReadTimeout = 50 * time.Millisecond
+	server.MinReadRate = 100
+	server.NewAgent = func(conn *network.TCPConn) network.Agent {
+		return &echoAgent{conn: conn, closed: make(chan struct{})}
+	}
+	server.Start()
+	defer server.Close()
+
+	dial := func(header bool) net.Conn {
+		conn, err := net.Dial("tcp", server.Addr)
+		if err != nil {
+			fmt.Println(err)
+			return nil
+		}
+		if header {
+			conn.Write([]byte("{{{"))
+		}
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		return conn
+	}
+
+	// no header
+	conn := dial(false)
+	defer conn.Close()
+	_, err := readFrame(conn)
+	fmt.Println("handshake:", err != nil)
+
+	// idle between the messages
+	conn = dial(true)
+	defer conn.Close()
+	time.Sleep(100 * time.Millisecond)
+	conn.Write(frame("idle"))
+	fmt.Println(readFrame(conn))
+
+	// slow but above MinReadRate, ReadTimeout alone would close it
+	conn = dial(true)
+	defer conn.Close()
+	for _, b := range frame("0123456789") {
+		conn.Write([]byte{b})
+		time.Sleep(5 * time.Millisecond)
+	}
+	fmt.Println(readFrame(conn))
+
+	// a message stalled
+	conn = dial(true)
+	defer conn.Close()
+	conn.Write(frame("0123456789")[:3])
+	_, err = readFrame(conn)
+	fmt.Println("stalled:", err != nil)
+
+	// Output:
+	// handshake: true
+	// idle <nil>
+	// 0123456789 <nil>
+	// stalled: true
+}
+
+func ExampleByteBudget() {
+	budget := network.NewByteBudget(16)
+	server := new(network.TCPServer)
+	server.Addr = "127.0.0.1:3587"
+	server.MaxConnNum = 10
+	server.PendingWriteNum = 10
+	server.LenMsgLen = 2
+	server.ReadBudget = budget
+	server.NewAgent = func(conn *network.TCPConn) network.Agent {
+		return &echoAgent{conn: conn, closed: make(chan struct{})}
+	}
+	server.Start()
+	defer server.Close()
+
+	dial := func() net.Conn {
+		conn, err := net.Dial("tcp", server.Addr)
+		if err != nil {
+			fmt.Println(err)
+			return nil
+		}
+		conn.Write([]byte("{{{"))
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		return conn
+	}
+
+	// 10 bytes held by a message being read
+	slow := dial()
+	defer slow.Close()
+	msg := frame("0123456789")
+	slow.Write(msg[:4])
+	for budget.Used() != 10 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// beyond the budget, the connection is closed
+	conn := dial()
+	defer conn.Close()
+	conn.Write(frame("abcdefghij"))
+	_, err := readFrame(conn)
+	fmt.Println("closed:", err != nil)
+
+	slow.Write(msg[4:])
+	fmt.Println(readFrame(slow))
+	fmt.Println(budget.Used())
+
+	// Output:
+	// closed: true
+	// 0123456789 <nil>
+	// 0
+}
+
+func ExampleWSServer_readBudget() {
+	server := new(network.WSServer)
+	server.Addr = "127.0.0.1:3588"
+	server.MaxConnNum = 10
+	server.PendingWriteNum = 10
+	server.MaxMsgLen = 4096
+	server.HTTPTimeout = time.Second
+	server.ReadTimeout = time.Second
+	server.ReadBudget = network.NewByteBudget(8)
+	server.NewAgent = func(conn *network.WSConn) network.Agent {
+		return &echoAgent{conn: conn, closed: make(chan struct{})}
+	}
+	server.Start()
+	defer server.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws://"+server.Addr, nil)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	conn.WriteMessage(websocket.BinaryMessage, []byte("leaf"))
+	_, b, err := conn.ReadMessage()
+	fmt.Println(string(b), err)
+
+	conn.WriteMessage(websocket.BinaryMessage, []byte("My name is Leaf"))
+	_, _, err = conn.ReadMessage()
+	fmt.Println(err != nil, server.ReadBudget.Used())
+
+	// Output:
+	// leaf <nil>
+	// true 0
+}
+
+type connAgent struct {
+	conn network.Conn
+}
+
+func (a *connAgent) Run() {
+	for {
+		if _, err := a.conn.ReadMsg(); err != nil {
+			return
+		}
+	}
+}
+
+func (a *connAgent) OnClose() {}
+
+func ExampleBuffer() {
+	conns := make(chan network.Conn, 10)
+	tcpServer := new(network.TCPServer)
+	tcpServer.Addr = "127.0.0.1:3589"
+	tcpServer.MaxConnNum = 10
+	tcpServer.PendingWriteNum = 10
+	tcpServer.LenMsgLen = 2
+	tcpServer.NewAgent = func(conn *network.TCPConn) network.Agent {
+		conns <- conn
+		return &connAgent{conn: conn}
+	}
+	tcpServer.Start()
+	defer tcpServer.Close()
+
+	wsServer := new(network.WSServer)
+	wsServer.Addr = "127.0.0.1:3590"
+	wsServer.MaxConnNum = 10
+	wsServer.PendingWriteNum = 10
+	wsServer.MaxMsgLen = 4096
+	wsServer.HTTPTimeout = time.Second
+	wsServer.NewAgent = func(conn *network.WSConn) network.Agent {
+		conns <- conn
+		return &connAgent{conn: conn}
+	}
+	wsServer.Start()
+	defer wsServer.Close()
+
+	var tcpClients []net.Conn
+	for i := 0; i < 3; i++ {
+		conn, err := net.Dial("tcp", tcpServer.Addr)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		defer conn.Close()
+		conn.Write([]byte("{{{"))
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		tcpClients = append(tcpClients, conn)
+	}
+	var wsClients []*websocket.Conn
+	for i := 0; i < 2; i++ {
+		conn, _, err := websocket.DefaultDialer.Dial("ws://"+wsServer.Addr, nil)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		defer conn.Close()
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		wsClients = append(wsClients, conn)
+	}
+	var serverConns []network.Conn
+	for i := 0; i < 5; i++ {
+		serverConns = append(serverConns, <-conns)
+	}
+
+	// the parts are spoiled once freed, a write after the release would
+	// show up at the clients
+	var frees int32
+	freed := make(chan struct{})
+	buf := network.NewBuffer([]byte("hello "), []byte("leaf"))
+	buf.SetFree(func() {
+		if atomic.AddInt32(&frees, 1) == 1 {
+			close(freed)
+		}
+		for _, p := range buf.Parts() {
+			for i := range p {
+				p[i] = 'x'
+			}
+		}
+	})
+
+	// a connection destroyed with the buffer pending still releases it
+	doomed, err := net.Dial("tcp", tcpServer.Addr)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	defer doomed.Close()
+	doomed.Write([]byte("{{{"))
+	doomedConn := <-conns
+
+	for _, conn := range serverConns {
+		if err := conn.(network.RawConn).WriteRaw(buf); err != nil {
+			fmt.Println(err)
+		}
+	}
+	doomedConn.(network.RawConn).WriteRaw(buf)
+	doomedConn.Destroy()
+	buf.Release()
+
+	for _, conn := range tcpClients {
+		fmt.Println(readFrame(conn))
+	}
+	for _, conn := range wsClients {
+		_, b, err := conn.ReadMessage()
+		fmt.Println(string(b), err)
+	}
+
+	select {
+	case <-freed:
+	case <-time.After(5 * time.Second):
+	}
+	time.Sleep(50 * time.Millisecond)
+	fmt.Println("frees:", atomic.LoadInt32(&frees))
+
+	// a write after the release is a misuse
+	func() {
+		defer func() {
+			fmt.Println(recover())
+		}()
+		serverConns[0].(network.RawConn).WriteRaw(buf)
+	}()
+
+	// Output:
+	// hello leaf <nil>
+	// hello leaf <nil>
+	// hello leaf <nil>
+	// hello leaf <nil>
+	// hello leaf <nil>
+	// frees: 1
+	// retain a released buffer
+}
@@ -7,6 +7,7 @@ import (
 	"github.com/name5566/leaf/chanrpc"
 	"github.com/name5566/leaf/log"
 	"reflect"
+	"sort"
 )
 
 type Processor struct {
@@ -171,3 +172,43 @@ func (p *Processor) Marshal(msg interface{}) ([][]byte, error) {
 	data, err := json.Marshal(m)
 	return [][]byte{data}, err
 }
+
+// goroutine safe
+// the messages are ranged in id order
+func (p *Processor) Range(f func(id string, t reflect.Type)) {
+	ids := make([]string, 0, len(p.msgInfo))
+	for id := range p.msgInfo {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		f(id, p.msgInfo[id].msgType)
+	}
+}
+
+// goroutine safe
+func (p *Processor) MsgRouter(msgID string) *chanrpc.Server {
+	i, ok := p.msgInfo[msgID]
+	if !ok {
+		return nil
+	}
+	return i.msgRouter
+}
+
+// goroutine safe
+// frames the raw data of a message with no copy, e.g. to forward it
+func (p *Processor) PackRaw(msgID string, data json.RawMessage) ([][]byte, error) {
+	if _, ok := p.msgInfo[msgID]; !ok {
+		return nil, fmt.Errorf("message %v not registered", msgID)
+	}
+
+	id, err := json.Marshal(msgID)
+	if err != nil {
+		return nil, err
+	}
+	head := make([]byte, 0, len(id)+2)
+	head = append(head, '{')
+	head = append(head, id...)
+	head = append(head, ':')
+	return [][]byte{head, data, []byte("}")}, nil
+}
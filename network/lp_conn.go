@@ -0,0 +1,216 @@
+package network
+
+import (
+	"errors"
+	"github.com/name5566/leaf/log"
+	"net"
+	"sync"
+	"time"
+)
+
+// a long polling session
+type LPConn struct {
+	sync.Mutex
+	id              string
+	localAddr       net.Addr
+	remoteAddr      net.Addr
+	pendingWriteNum int
+	maxMsgLen       uint32
+	sessionTimeout  time.Duration
+	timer           *time.Timer
+	onEnd           func()
+
+	// upstream
+	readChan    chan []byte
+	nextReadSeq uint64
+
+	// downstream, pending[0] is numbered firstSeq
+	pending  [][]byte
+	firstSeq uint64
+	changed  chan struct{}
+
+	closeFlag bool
+	endFlag   bool
+	endChan   chan struct{}
+}
+
+func newLPConn(id string, localAddr, remoteAddr net.Addr, pendingWriteNum int, maxMsgLen uint32, sessionTimeout time.Duration) *LPConn {
+	lpConn := new(LPConn)
+	lpConn.id = id
+	lpConn.localAddr = localAddr
+	lpConn.remoteAddr = remoteAddr
+	lpConn.pendingWriteNum = pendingWriteNum
+	lpConn.maxMsgLen = maxMsgLen
+	lpConn.sessionTimeout = sessionTimeout
+	lpConn.readChan = make(chan []byte, pendingWriteNum)
+	lpConn.nextReadSeq = 1
+	lpConn.firstSeq = 1
+	lpConn.changed = make(chan struct{})
+	lpConn.endChan = make(chan struct{})
+	lpConn.timer = time.AfterFunc(sessionTimeout, func() {
+		log.Debug("long polling session %v timeout", id)
+		lpConn.Destroy()
+	})
+
+	return lpConn
+}
+
+func (lpConn *LPConn) ID() string {
+	return lpConn.id
+}
+
+// called on every request of the session
+func (lpConn *LPConn) touch() {
+	lpConn.timer.Reset(lpConn.sessionTimeout)
+}
+
+func (lpConn *LPConn) doEnd() {
+	if lpConn.endFlag {
+		return
+	}
+	lpConn.endFlag = true
+	lpConn.closeFlag = true
+	lpConn.pending = nil
+	lpConn.timer.Stop()
+	close(lpConn.endChan)
+	close(lpConn.changed)
+
+	if lpConn.onEnd != nil {
+		go lpConn.onEnd()
+	}
+}
+
+func (lpConn *LPConn) Destroy() {
+	lpConn.Lock()
+	defer lpConn.Unlock()
+
+	lpConn.doEnd()
+}
+
+// the pending messages are still delivered to the next poll
+func (lpConn *LPConn) Close() {
+	lpConn.Lock()
+	defer lpConn.Unlock()
+	if lpConn.closeFlag {
+		return
+	}
+
+	lpConn.closeFlag = true
+	if len(lpConn.pending) == 0 {
+		lpConn.doEnd()
+	}
+}
+
+func (lpConn *LPConn) LocalAddr() net.Addr {
+	return lpConn.localAddr
+}
+
+func (lpConn *LPConn) RemoteAddr() net.Addr {
+	return lpConn.remoteAddr
+}
+
+// goroutine not safe
+func (lpConn *LPConn) ReadMsg() ([]byte, error) {
+	select {
+	case b := <-lpConn.readChan:
+		return b, nil
+	case <-lpConn.endChan:
+		return nil, errors.New("long polling session closed")
+	}
+}
+
+// args must not be modified by the others goroutines
+func (lpConn *LPConn) WriteMsg(args ...[]byte) error {
+	lpConn.Lock()
+	defer lpConn.Unlock()
+	if lpConn.closeFlag {
+		return nil
+	}
+
+	// get len
+	var msgLen uint32
+	for i := 0; i < len(args); i++ {
+		msgLen += uint32(len(args[i]))
+	}
+
+	// check len
+	if msgLen > lpConn.maxMsgLen {
+		return errors.New("message too long")
+	} else if msgLen < 1 {
+		return errors.New("message too short")
+	}
+
+	if len(lpConn.pending) >= lpConn.pendingWriteNum {
+		log.Debug("close conn: channel full")
+		lpConn.doEnd()
+		return nil
+	}
+
+	// merge the args
+	msg := args[0]
+	if len(args) > 1 {
+		msg = make([]byte, msgLen)
+		l := 0
+		for i := 0; i < len(args); i++ {
+			copy(msg[l:], args[i])
+			l += len(args[i])
+		}
+	}
+	lpConn.pending = append(lpConn.pending, msg)
+
+	// wake up the polls
+	close(lpConn.changed)
+	lpConn.changed = make(chan struct{})
+
+	return nil
+}
+
+// msgs[0] is numbered seq, the messages already received are skipped
+func (lpConn *LPConn) push(seq uint64, msgs [][]byte) error {
+	lpConn.Lock()
+	defer lpConn.Unlock()
+	if lpConn.closeFlag {
+		return errors.New("long polling session closed")
+	}
+	if seq > lpConn.nextReadSeq {
+		return errors.New("message sequence gap")
+	}
+
+	for i, msg := range msgs {
+		if seq+uint64(i) < lpConn.nextReadSeq {
+			continue
+		}
+		select {
+		case lpConn.readChan <- msg:
+			lpConn.nextReadSeq++
+		default:
+			return errors.New("too many pending messages")
+		}
+	}
+	return nil
+}
+
+// drops the messages numbered ack or less, then returns the others
+// ok is false once the session ends
+func (lpConn *LPConn) poll(ack uint64) (seq uint64, msgs [][]byte, changed chan struct{}, ok bool) {
+	lpConn.Lock()
+	defer lpConn.Unlock()
+	if lpConn.endFlag {
+		return
+	}
+
+	if ack >= lpConn.firstSeq {
+		n := ack - lpConn.firstSeq + 1
+		if n > uint64(len(lpConn.pending)) {
+			n = uint64(len(lpConn.pending))
+		}
+		lpConn.pending = lpConn.pending[n:]
+		lpConn.firstSeq += n
+	}
+	if lpConn.closeFlag && len(lpConn.pending) == 0 {
+		lpConn.doEnd()
+		return
+	}
+
+	return lpConn.firstSeq, lpConn.pending, lpConn.changed, true
+}
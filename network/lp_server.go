@@ -0,0 +1,248 @@
+package network
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"github.com/name5566/leaf/log"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// HTTP long polling, a fallback of websocket
+//
+// POST   path            open a session, the response body is the session id
+// POST   path?sid=&seq=  send messages, seq is the number of the first one
+// GET    path?sid=&ack=  receive the messages numbered after ack (X-Seq)
+// DELETE path?sid=       close the session
+//
+// the messages of a request or a response body are framed as
+// -------------------------
+// | len (uint32) | data |
+// -------------------------
+// the numbers start from 1, X-Seq is the number of the first message of a
+// response, the messages are resent until acked
+type LPHandler struct {
+	connNum         *connNum
+	pendingWriteNum int
+	maxMsgLen       uint32
+	pollTimeout     time.Duration
+	sessionTimeout  time.Duration
+	newAgent        func(*LPConn) Agent
+	conns           map[string]*LPConn
+	mutexConns      sync.Mutex
+	wg              sync.WaitGroup
+}
+
+func (handler *LPHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Expose-Headers", "X-Seq")
+
+	sid := r.URL.Query().Get("sid")
+	if sid == "" {
+		if r.Method != "POST" {
+			http.Error(w, "Method not allowed", 405)
+			return
+		}
+		handler.open(w, r)
+		return
+	}
+
+	handler.mutexConns.Lock()
+	lpConn := handler.conns[sid]
+	handler.mutexConns.Unlock()
+	if lpConn == nil {
+		http.Error(w, "Session not found", 410)
+		return
+	}
+	lpConn.touch()
+
+	switch r.Method {
+	case "POST":
+		handler.send(w, r, lpConn)
+	case "GET":
+		handler.poll(w, r, lpConn)
+	case "DELETE":
+		lpConn.Close()
+	default:
+		http.Error(w, "Method not allowed", 405)
+	}
+}
+
+func newSessionID() (string, error) {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b[:]), nil
+}
+
+func requestAddrs(r *http.Request) (net.Addr, net.Addr) {
+	localAddr, _ := r.Context().Value(http.LocalAddrContextKey).(net.Addr)
+	remoteAddr, err := net.ResolveTCPAddr("tcp", r.RemoteAddr)
+	if err != nil {
+		return localAddr, nil
+	}
+	return localAddr, remoteAddr
+}
+
+func (handler *LPHandler) open(w http.ResponseWriter, r *http.Request) {
+	sid, err := newSessionID()
+	if err != nil {
+		log.Error("long polling session id error: %v", err)
+		http.Error(w, "Internal server error", 500)
+		return
+	}
+	localAddr, remoteAddr := requestAddrs(r)
+
+	handler.mutexConns.Lock()
+	if handler.conns == nil {
+		handler.mutexConns.Unlock()
+		http.Error(w, "Service unavailable", 503)
+		return
+	}
+	if !handler.connNum.add() {
+		handler.mutexConns.Unlock()
+		http.Error(w, "Service unavailable", 503)
+		log.Debug("too many connections")
+		return
+	}
+	lpConn := newLPConn(sid, localAddr, remoteAddr, handler.pendingWriteNum, handler.maxMsgLen, handler.sessionTimeout)
+	lpConn.onEnd = func() {
+		handler.mutexConns.Lock()
+		if handler.conns != nil {
+			delete(handler.conns, sid)
+		}
+		handler.mutexConns.Unlock()
+		handler.connNum.done()
+	}
+	handler.conns[sid] = lpConn
+	handler.wg.Add(1)
+	handler.mutexConns.Unlock()
+
+	go func() {
+		agent := handler.newAgent(lpConn)
+		agent.Run()
+
+		// cleanup
+		lpConn.Close()
+		agent.OnClose()
+
+		handler.wg.Done()
+	}()
+
+	io.WriteString(w, sid)
+}
+
+func (handler *LPHandler) send(w http.ResponseWriter, r *http.Request, lpConn *LPConn) {
+	seq, err := strconv.ParseUint(r.URL.Query().Get("seq"), 10, 64)
+	if err != nil || seq == 0 {
+		http.Error(w, "Invalid seq", 400)
+		return
+	}
+
+	limit := int64(handler.maxMsgLen+4) * int64(handler.pendingWriteNum)
+	body, err := io.ReadAll(io.LimitReader(r.Body, limit+1))
+	if err != nil {
+		return
+	}
+	if int64(len(body)) > limit {
+		http.Error(w, "Request entity too large", 413)
+		return
+	}
+
+	msgs, err := handler.unframe(body)
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	err = lpConn.push(seq, msgs)
+	if err != nil {
+		http.Error(w, err.Error(), 409)
+		return
+	}
+}
+
+func (handler *LPHandler) unframe(body []byte) ([][]byte, error) {
+	var msgs [][]byte
+	for len(body) > 0 {
+		if len(body) < 4 {
+			return nil, errors.New("invalid message framing")
+		}
+		msgLen := binary.BigEndian.Uint32(body)
+		body = body[4:]
+		if msgLen > handler.maxMsgLen {
+			return nil, errors.New("message too long")
+		} else if msgLen < 1 {
+			return nil, errors.New("message too short")
+		}
+		if uint32(len(body)) < msgLen {
+			return nil, errors.New("invalid message framing")
+		}
+		msgs = append(msgs, body[:msgLen])
+		body = body[msgLen:]
+	}
+	return msgs, nil
+}
+
+func (handler *LPHandler) poll(w http.ResponseWriter, r *http.Request, lpConn *LPConn) {
+	var ack uint64
+	if s := r.URL.Query().Get("ack"); s != "" {
+		var err error
+		ack, err = strconv.ParseUint(s, 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid ack", 400)
+			return
+		}
+	}
+
+	timeout := time.NewTimer(handler.pollTimeout)
+	defer timeout.Stop()
+	for {
+		seq, msgs, changed, ok := lpConn.poll(ack)
+		if !ok {
+			http.Error(w, "Session closed", 410)
+			return
+		}
+		if len(msgs) > 0 {
+			var buf bytes.Buffer
+			var l [4]byte
+			for _, msg := range msgs {
+				binary.BigEndian.PutUint32(l[:], uint32(len(msg)))
+				buf.Write(l[:])
+				buf.Write(msg)
+			}
+			w.Header().Set("Content-Type", "application/octet-stream")
+			w.Header().Set("X-Seq", strconv.FormatUint(seq, 10))
+			w.Write(buf.Bytes())
+			return
+		}
+
+		select {
+		case <-changed:
+		case <-timeout.C:
+			w.WriteHeader(204)
+			return
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+func (handler *LPHandler) close() {
+	handler.mutexConns.Lock()
+	conns := handler.conns
+	handler.conns = nil
+	handler.mutexConns.Unlock()
+
+	for _, lpConn := range conns {
+		lpConn.Destroy()
+	}
+	handler.wg.Wait()
+}
@@ -178,9 +178,33 @@ func (p *Processor) Marshal(msg interface{}) ([][]byte, error) {
 	return [][]byte{id, data}, err
 }
 
+// goroutine safe
+// frames the raw data of a message with no copy, e.g. to forward it
+func (p *Processor) PackRaw(id uint16, data []byte) ([][]byte, error) {
+	if id >= uint16(len(p.msgInfo)) {
+		return nil, fmt.Errorf("message id %v not registered", id)
+	}
+
+	_id := make([]byte, 2)
+	if p.littleEndian {
+		binary.LittleEndian.PutUint16(_id, id)
+	} else {
+		binary.BigEndian.PutUint16(_id, id)
+	}
+	return [][]byte{_id, data}, nil
+}
+
 // goroutine safe
 func (p *Processor) Range(f func(id uint16, t reflect.Type)) {
 	for id, i := range p.msgInfo {
 		f(uint16(id), i.msgType)
 	}
 }
+
+// goroutine safe
+func (p *Processor) MsgRouter(id uint16) *chanrpc.Server {
+	if id >= uint16(len(p.msgInfo)) {
+		return nil
+	}
+	return p.msgInfo[id].msgRouter
+}
@@ -0,0 +1,186 @@
+package quic
+
+import (
+	"context"
+	"crypto/tls"
+	"github.com/name5566/leaf/log"
+	"github.com/name5566/leaf/network"
+	quicgo "github.com/quic-go/quic-go"
+	"sync"
+	"time"
+)
+
+type Client struct {
+	sync.Mutex
+	Addr             string
+	ConnNum          int
+	ConnectInterval  time.Duration
+	PendingWriteNum  int
+	HandshakeTimeout time.Duration
+	IdleTimeout      time.Duration
+	TLSConfig        *tls.Config
+	Datagram         bool
+	AutoReconnect    bool
+	NewAgent         func(*Conn) network.Agent
+	conns            ConnSet
+	wg               sync.WaitGroup
+	closeFlag        bool
+
+	// msg parser
+	LenMsgLen    int
+	MinMsgLen    uint32
+	MaxMsgLen    uint32
+	LittleEndian bool
+	msgParser    *network.MsgParser
+}
+
+func (client *Client) Start() {
+	client.init()
+
+	for i := 0; i < client.ConnNum; i++ {
+		client.wg.Add(1)
+		go client.connect()
+	}
+}
+
+func (client *Client) init() {
+	client.Lock()
+	defer client.Unlock()
+
+	if client.ConnNum <= 0 {
+		client.ConnNum = 1
+		log.Release("invalid ConnNum, reset to %v", client.ConnNum)
+	}
+	if client.ConnectInterval <= 0 {
+		client.ConnectInterval = 3 * time.Second
+		log.Release("invalid ConnectInterval, reset to %v", client.ConnectInterval)
+	}
+	if client.PendingWriteNum <= 0 {
+		client.PendingWriteNum = 100
+		log.Release("invalid PendingWriteNum, reset to %v", client.PendingWriteNum)
+	}
+	if client.MaxMsgLen <= 0 {
+		client.MaxMsgLen = 4096
+		log.Release("invalid MaxMsgLen, reset to %v", client.MaxMsgLen)
+	}
+	if client.Datagram && client.MaxMsgLen > MaxDatagramLen {
+		client.MaxMsgLen = MaxDatagramLen
+		log.Release("MaxMsgLen above the datagram limit, reset to %v", client.MaxMsgLen)
+	}
+	if client.HandshakeTimeout <= 0 {
+		client.HandshakeTimeout = 10 * time.Second
+		log.Release("invalid HandshakeTimeout, reset to %v", client.HandshakeTimeout)
+	}
+	if client.IdleTimeout <= 0 {
+		client.IdleTimeout = 30 * time.Second
+		log.Release("invalid IdleTimeout, reset to %v", client.IdleTimeout)
+	}
+	if client.TLSConfig == nil {
+		client.TLSConfig = new(tls.Config)
+	}
+	if client.NewAgent == nil {
+		log.Fatal("NewAgent must not be nil")
+	}
+	if client.conns != nil {
+		log.Fatal("client is running")
+	}
+
+	client.TLSConfig = client.TLSConfig.Clone()
+	client.TLSConfig.NextProtos = []string{nextProto}
+	client.conns = make(ConnSet)
+	client.closeFlag = false
+
+	// msg parser
+	msgParser := network.NewMsgParser()
+	msgParser.SetMsgLen(client.LenMsgLen, client.MinMsgLen, client.MaxMsgLen)
+	msgParser.SetByteOrder(client.LittleEndian)
+	client.msgParser = msgParser
+}
+
+func (client *Client) dial() (*quicgo.Conn, *quicgo.Stream) {
+	for {
+		conn, stream, err := client.dialOnce()
+		if err == nil || client.closeFlag {
+			return conn, stream
+		}
+
+		log.Release("connect to %v error: %v", client.Addr, err)
+		time.Sleep(client.ConnectInterval)
+		continue
+	}
+}
+
+func (client *Client) dialOnce() (*quicgo.Conn, *quicgo.Stream, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), client.HandshakeTimeout)
+	defer cancel()
+
+	conn, err := quicgo.DialAddr(ctx, client.Addr, client.TLSConfig, &quicgo.Config{
+		HandshakeIdleTimeout: client.HandshakeTimeout,
+		MaxIdleTimeout:       client.IdleTimeout,
+		KeepAlivePeriod:      client.IdleTimeout / 2,
+		EnableDatagrams:      client.Datagram,
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	if client.Datagram {
+		return conn, nil, nil
+	}
+
+	stream, err := conn.OpenStreamSync(ctx)
+	if err == nil {
+		_, err = stream.Write([]byte(header))
+	}
+	if err != nil {
+		conn.CloseWithError(0, "")
+		return nil, nil, err
+	}
+	return conn, stream, nil
+}
+
+func (client *Client) connect() {
+	defer client.wg.Done()
+
+reconnect:
+	conn, stream := client.dial()
+	if conn == nil {
+		return
+	}
+
+	client.Lock()
+	if client.closeFlag {
+		client.Unlock()
+		conn.CloseWithError(0, "")
+		return
+	}
+	client.conns[conn] = struct{}{}
+	client.Unlock()
+
+	quicConn := newConn(conn, stream, client.PendingWriteNum, client.msgParser, client.MaxMsgLen)
+	agent := client.NewAgent(quicConn)
+	agent.Run()
+
+	// cleanup
+	quicConn.Close()
+	client.Lock()
+	delete(client.conns, conn)
+	client.Unlock()
+	agent.OnClose()
+
+	if client.AutoReconnect {
+		time.Sleep(client.ConnectInterval)
+		goto reconnect
+	}
+}
+
+func (client *Client) Close() {
+	client.Lock()
+	client.closeFlag = true
+	for conn := range client.conns {
+		conn.CloseWithError(0, "")
+	}
+	client.conns = nil
+	client.Unlock()
+
+	client.wg.Wait()
+}
@@ -0,0 +1,209 @@
+package quic
+
+import (
+	"errors"
+	"github.com/name5566/leaf/log"
+	"github.com/name5566/leaf/network"
+	quicgo "github.com/quic-go/quic-go"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+type ConnSet map[*quicgo.Conn]struct{}
+
+// waits for the peer to read the rest of the stream on Close
+const closeTimeout = 3 * time.Second
+
+// the max length of a message in datagram mode, below the smallest QUIC
+// datagram payload so that it always fits a packet
+const MaxDatagramLen = 1150
+
+// written by the client on opening the stream, the server only sees a
+// stream once some data is sent on it
+const header = "{{{"
+
+func readHeader(stream *quicgo.Stream, timeout time.Duration) error {
+	b := make([]byte, len(header))
+	stream.SetReadDeadline(time.Now().Add(timeout))
+	_, err := io.ReadFull(stream, b)
+	if err != nil {
+		return err
+	}
+	if string(b) != header {
+		return errors.New("invalid header")
+	}
+	stream.SetReadDeadline(time.Time{})
+	return nil
+}
+
+// one stream per session, or unreliable datagrams if stream is nil
+type Conn struct {
+	sync.Mutex
+	conn      *quicgo.Conn
+	stream    *quicgo.Stream
+	writeChan chan []byte
+	closeFlag bool
+	msgParser *network.MsgParser
+	maxMsgLen uint32
+}
+
+func newConn(conn *quicgo.Conn, stream *quicgo.Stream, pendingWriteNum int, msgParser *network.MsgParser, maxMsgLen uint32) *Conn {
+	quicConn := new(Conn)
+	quicConn.conn = conn
+	quicConn.stream = stream
+	quicConn.writeChan = make(chan []byte, pendingWriteNum)
+	quicConn.msgParser = msgParser
+	quicConn.maxMsgLen = maxMsgLen
+
+	go func() {
+		for b := range quicConn.writeChan {
+			if b == nil {
+				break
+			}
+
+			var err error
+			if stream != nil {
+				_, err = stream.Write(b)
+			} else {
+				err = conn.SendDatagram(b)
+				// the path may not carry MaxDatagramLen, the datagram
+				// is lost as any other
+				var tooLarge *quicgo.DatagramTooLargeError
+				if errors.As(err, &tooLarge) {
+					log.Debug("datagram dropped: %v", err)
+					err = nil
+				}
+			}
+			if err != nil {
+				break
+			}
+		}
+
+		if stream != nil {
+			stream.Close()
+			select {
+			case <-conn.Context().Done():
+			case <-time.After(closeTimeout):
+			}
+		}
+		conn.CloseWithError(0, "")
+		quicConn.Lock()
+		quicConn.closeFlag = true
+		quicConn.Unlock()
+	}()
+
+	return quicConn
+}
+
+func (quicConn *Conn) doDestroy() {
+	quicConn.conn.CloseWithError(0, "")
+
+	if !quicConn.closeFlag {
+		close(quicConn.writeChan)
+		quicConn.closeFlag = true
+	}
+}
+
+func (quicConn *Conn) Destroy() {
+	quicConn.Lock()
+	defer quicConn.Unlock()
+
+	quicConn.doDestroy()
+}
+
+func (quicConn *Conn) Close() {
+	quicConn.Lock()
+	defer quicConn.Unlock()
+	if quicConn.closeFlag {
+		return
+	}
+
+	quicConn.doWrite(nil)
+	quicConn.closeFlag = true
+}
+
+func (quicConn *Conn) doWrite(b []byte) {
+	if len(quicConn.writeChan) == cap(quicConn.writeChan) {
+		log.Debug("close conn: channel full")
+		quicConn.doDestroy()
+		return
+	}
+
+	quicConn.writeChan <- b
+}
+
+func (quicConn *Conn) LocalAddr() net.Addr {
+	return quicConn.conn.LocalAddr()
+}
+
+func (quicConn *Conn) RemoteAddr() net.Addr {
+	return quicConn.conn.RemoteAddr()
+}
+
+// true if the messages are sent as unreliable datagrams
+func (quicConn *Conn) Datagram() bool {
+	return quicConn.stream == nil
+}
+
+// goroutine not safe
+func (quicConn *Conn) ReadMsg() ([]byte, error) {
+	if quicConn.stream != nil {
+		return quicConn.msgParser.Read(quicConn.stream)
+	}
+
+	b, err := quicConn.conn.ReceiveDatagram(quicConn.conn.Context())
+	if err != nil {
+		return nil, err
+	}
+	if uint32(len(b)) > quicConn.maxMsgLen {
+		return nil, errors.New("message too long")
+	} else if len(b) < 1 {
+		return nil, errors.New("message too short")
+	}
+	return b, nil
+}
+
+// args must not be modified by the others goroutines
+func (quicConn *Conn) WriteMsg(args ...[]byte) error {
+	quicConn.Lock()
+	defer quicConn.Unlock()
+	if quicConn.closeFlag {
+		return nil
+	}
+
+	var msg []byte
+	if quicConn.stream != nil {
+		var err error
+		msg, err = quicConn.msgParser.Pack(args...)
+		if err != nil {
+			return err
+		}
+	} else {
+		// get len
+		var msgLen uint32
+		for i := 0; i < len(args); i++ {
+			msgLen += uint32(len(args[i]))
+		}
+
+		// check len
+		if msgLen > quicConn.maxMsgLen {
+			return errors.New("message too long")
+		} else if msgLen < 1 {
+			return errors.New("message too short")
+		}
+
+		// merge the args
+		msg = make([]byte, msgLen)
+		l := 0
+		for i := 0; i < len(args); i++ {
+			copy(msg[l:], args[i])
+			l += len(args[i])
+		}
+	}
+
+	quicConn.doWrite(msg)
+
+	return nil
+}
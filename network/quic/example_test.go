@@ -0,0 +1,112 @@
+package quic_test
+
+import (
+	"crypto/tls"
+	"fmt"
+	"github.com/name5566/leaf/network"
+	"github.com/name5566/leaf/network/quic"
+)
+
+type echoAgent struct {
+	conn *quic.Conn
+}
+
+func (a *echoAgent) Run() {
+	for {
+		data, err := a.conn.ReadMsg()
+		if err != nil {
+			return
+		}
+		a.conn.WriteMsg(data)
+	}
+}
+
+func (a *echoAgent) OnClose() {}
+
+type clientAgent struct {
+	conn *quic.Conn
+	done chan bool
+}
+
+func (a *clientAgent) Run() {
+	a.conn.WriteMsg([]byte("My name is Leaf"))
+	data, err := a.conn.ReadMsg()
+	if err == nil {
+		fmt.Println(string(data))
+	}
+	a.done <- true
+}
+
+func (a *clientAgent) OnClose() {}
+
+func Example() {
+	tlsConfig, err := quic.SelfSignedTLSConfig()
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	server := new(quic.Server)
+	server.Addr = "127.0.0.1:3563"
+	server.TLSConfig = tlsConfig
+	server.NewAgent = func(conn *quic.Conn) network.Agent {
+		return &echoAgent{conn: conn}
+	}
+	server.Start()
+	defer server.Close()
+
+	done := make(chan bool)
+	client := new(quic.Client)
+	client.Addr = server.Addr
+	client.TLSConfig = &tls.Config{InsecureSkipVerify: true}
+	client.NewAgent = func(conn *quic.Conn) network.Agent {
+		return &clientAgent{conn: conn, done: done}
+	}
+	client.Start()
+	<-done
+	client.Close()
+
+	// Output:
+	// My name is Leaf
+}
+
+func Example_datagram() {
+	tlsConfig, err := quic.SelfSignedTLSConfig()
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	server := new(quic.Server)
+	server.Addr = "127.0.0.1:3581"
+	server.TLSConfig = tlsConfig
+	server.Datagram = true
+	server.NewAgent = func(conn *quic.Conn) network.Agent {
+		return &echoAgent{conn: conn}
+	}
+	server.Start()
+	defer server.Close()
+
+	done := make(chan bool)
+	client := new(quic.Client)
+	client.Addr = server.Addr
+	client.TLSConfig = &tls.Config{InsecureSkipVerify: true}
+	client.Datagram = true
+	client.NewAgent = func(conn *quic.Conn) network.Agent {
+		fmt.Println(conn.Datagram())
+
+		// beyond the datagram limit
+		fmt.Println(conn.WriteMsg(make([]byte, quic.MaxDatagramLen+1)))
+
+		// the connection is kept
+		return &clientAgent{conn: conn, done: done}
+	}
+	client.Start()
+	<-done
+	client.Close()
+
+	// Output:
+	// true
+	// message too long
+	// My name is Leaf
+}
@@ -0,0 +1,175 @@
+// QUIC transport, one stream or unreliable datagrams per connection,
+// WebTransport is not supported
+package quic
+
+import (
+	"context"
+	"crypto/tls"
+	"github.com/name5566/leaf/log"
+	"github.com/name5566/leaf/network"
+	quicgo "github.com/quic-go/quic-go"
+	"sync"
+	"time"
+)
+
+const nextProto = "leaf"
+
+type Server struct {
+	Addr             string
+	MaxConnNum       int
+	PendingWriteNum  int
+	HandshakeTimeout time.Duration
+	IdleTimeout      time.Duration
+	CertFile         string
+	KeyFile          string
+	TLSConfig        *tls.Config // used instead of CertFile and KeyFile if not nil
+	Datagram         bool        // unreliable datagrams instead of a stream
+	NewAgent         func(*Conn) network.Agent
+	ln               *quicgo.Listener
+	conns            ConnSet
+	mutexConns       sync.Mutex
+	wgLn             sync.WaitGroup
+	wgConns          sync.WaitGroup
+
+	// msg parser
+	LenMsgLen    int
+	MinMsgLen    uint32
+	MaxMsgLen    uint32
+	LittleEndian bool
+	msgParser    *network.MsgParser
+}
+
+func (server *Server) Start() {
+	server.init()
+	server.wgLn.Add(1)
+	go server.run()
+}
+
+func (server *Server) init() {
+	if server.MaxConnNum <= 0 {
+		server.MaxConnNum = 100
+		log.Release("invalid MaxConnNum, reset to %v", server.MaxConnNum)
+	}
+	if server.PendingWriteNum <= 0 {
+		server.PendingWriteNum = 100
+		log.Release("invalid PendingWriteNum, reset to %v", server.PendingWriteNum)
+	}
+	if server.MaxMsgLen <= 0 {
+		server.MaxMsgLen = 4096
+		log.Release("invalid MaxMsgLen, reset to %v", server.MaxMsgLen)
+	}
+	if server.Datagram && server.MaxMsgLen > MaxDatagramLen {
+		server.MaxMsgLen = MaxDatagramLen
+		log.Release("MaxMsgLen above the datagram limit, reset to %v", server.MaxMsgLen)
+	}
+	if server.HandshakeTimeout <= 0 {
+		server.HandshakeTimeout = 10 * time.Second
+		log.Release("invalid HandshakeTimeout, reset to %v", server.HandshakeTimeout)
+	}
+	if server.IdleTimeout <= 0 {
+		server.IdleTimeout = 30 * time.Second
+		log.Release("invalid IdleTimeout, reset to %v", server.IdleTimeout)
+	}
+	if server.NewAgent == nil {
+		log.Fatal("NewAgent must not be nil")
+	}
+
+	tlsConfig := server.TLSConfig
+	if tlsConfig == nil {
+		cert, err := tls.LoadX509KeyPair(server.CertFile, server.KeyFile)
+		if err != nil {
+			log.Fatal("%v", err)
+		}
+		tlsConfig = &tls.Config{Certificates: []tls.Certificate{cert}}
+	}
+	tlsConfig = tlsConfig.Clone()
+	tlsConfig.NextProtos = []string{nextProto}
+
+	ln, err := quicgo.ListenAddr(server.Addr, tlsConfig, &quicgo.Config{
+		HandshakeIdleTimeout: server.HandshakeTimeout,
+		MaxIdleTimeout:       server.IdleTimeout,
+		EnableDatagrams:      server.Datagram,
+	})
+	if err != nil {
+		log.Fatal("%v", err)
+	}
+
+	server.ln = ln
+	server.conns = make(ConnSet)
+
+	// msg parser
+	msgParser := network.NewMsgParser()
+	msgParser.SetMsgLen(server.LenMsgLen, server.MinMsgLen, server.MaxMsgLen)
+	msgParser.SetByteOrder(server.LittleEndian)
+	server.msgParser = msgParser
+}
+
+func (server *Server) run() {
+	defer server.wgLn.Done()
+
+	for {
+		conn, err := server.ln.Accept(context.Background())
+		if err != nil {
+			return
+		}
+
+		server.mutexConns.Lock()
+		if len(server.conns) >= server.MaxConnNum {
+			server.mutexConns.Unlock()
+			conn.CloseWithError(0, "too many connections")
+			log.Debug("too many connections")
+			continue
+		}
+		server.conns[conn] = struct{}{}
+		server.mutexConns.Unlock()
+
+		server.wgConns.Add(1)
+		go server.serve(conn)
+	}
+}
+
+func (server *Server) serve(conn *quicgo.Conn) {
+	defer server.wgConns.Done()
+	defer func() {
+		server.mutexConns.Lock()
+		delete(server.conns, conn)
+		server.mutexConns.Unlock()
+	}()
+
+	var stream *quicgo.Stream
+	if !server.Datagram {
+		ctx, cancel := context.WithTimeout(conn.Context(), server.HandshakeTimeout)
+		var err error
+		stream, err = conn.AcceptStream(ctx)
+		cancel()
+		if err == nil {
+			err = readHeader(stream, server.HandshakeTimeout)
+		}
+		if err != nil {
+			log.Debug("accept stream error: %v", err)
+			conn.CloseWithError(0, "")
+			return
+		}
+	}
+
+	quicConn := newConn(conn, stream, server.PendingWriteNum, server.msgParser, server.MaxMsgLen)
+	agent := server.NewAgent(quicConn)
+	agent.Run()
+
+	// cleanup
+	quicConn.Close()
+	agent.OnClose()
+}
+
+func (server *Server) Close() {
+	server.ln.Close()
+	server.wgLn.Wait()
+
+	server.mutexConns.Lock()
+	for conn := range server.conns {
+		conn.CloseWithError(0, "")
+	}
+	server.conns = nil
+	server.mutexConns.Unlock()
+	server.wgConns.Wait()
+}
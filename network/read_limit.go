@@ -0,0 +1,76 @@
+package network
+
+import (
+	"io"
+	"sync/atomic"
+	"time"
+)
+
+// caps the bytes of the messages being read across connections, the read
+// of a message not fitting the budget fails and its connection is closed,
+// the budget sheds the load instead of slowing the readers down
+// goroutine safe
+type ByteBudget struct {
+	used int64 // accessed atomically, keep it 64-bit aligned
+	max  int64
+}
+
+func NewByteBudget(max int64) *ByteBudget {
+	return &ByteBudget{max: max}
+}
+
+func (b *ByteBudget) Acquire(n int64) bool {
+	for {
+		used := atomic.LoadInt64(&b.used)
+		if used+n > b.max {
+			return false
+		}
+		if atomic.CompareAndSwapInt64(&b.used, used, used+n) {
+			return true
+		}
+	}
+}
+
+func (b *ByteBudget) Release(n int64) {
+	atomic.AddInt64(&b.used, -n)
+}
+
+func (b *ByteBudget) Used() int64 {
+	return atomic.LoadInt64(&b.used)
+}
+
+type readDeadliner interface {
+	SetReadDeadline(t time.Time) error
+}
+
+// once the first byte of a message arrives, the message must be read in
+// timeout plus the time to transfer the bytes read so far at minRate
+type rateReader struct {
+	r       io.Reader
+	conn    readDeadliner
+	timeout time.Duration
+	minRate int
+	start   time.Time
+	n       int
+}
+
+func (r *rateReader) begin() {
+	r.start = time.Now()
+	r.conn.SetReadDeadline(r.start.Add(r.timeout))
+}
+
+func (r *rateReader) Read(b []byte) (int, error) {
+	n, err := r.r.Read(b)
+	if n > 0 {
+		if r.start.IsZero() {
+			r.start = time.Now()
+		}
+		r.n += n
+		deadline := r.start.Add(r.timeout)
+		if r.minRate > 0 {
+			deadline = deadline.Add(time.Duration(r.n) * time.Second / time.Duration(r.minRate))
+		}
+		r.conn.SetReadDeadline(deadline)
+	}
+	return n, err
+}
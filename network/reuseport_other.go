@@ -0,0 +1,11 @@
+//go:build !linux && !darwin && !dragonfly && !freebsd && !netbsd && !openbsd
+
+package network
+
+import (
+	"errors"
+)
+
+func setReusePort(fd uintptr) error {
+	return errors.New("SO_REUSEPORT is not supported")
+}
@@ -0,0 +1,107 @@
+package network
+
+import (
+	"context"
+	"net"
+	"syscall"
+	"time"
+)
+
+// tcp socket options, the zero value keeps the defaults of Go and the system
+type SockOpts struct {
+	Delay       bool          // Nagle's algorithm, Go sets TCP_NODELAY by default
+	ReadBuffer  int           // SO_RCVBUF bytes, 0 for the system default
+	WriteBuffer int           // SO_SNDBUF bytes, 0 for the system default
+	KeepAlive   time.Duration // keepalive period, 0 for the Go default, < 0 disables keepalive
+	Linger      time.Duration // SO_LINGER on close, 0 for the system default, < 0 discards the unsent data
+}
+
+// the conns other than *net.TCPConn are left as is
+func (o *SockOpts) Apply(conn net.Conn) error {
+	tc, ok := conn.(*net.TCPConn)
+	if !ok {
+		return nil
+	}
+
+	if o.Delay {
+		if err := tc.SetNoDelay(false); err != nil {
+			return err
+		}
+	}
+	if o.ReadBuffer > 0 {
+		if err := tc.SetReadBuffer(o.ReadBuffer); err != nil {
+			return err
+		}
+	}
+	if o.WriteBuffer > 0 {
+		if err := tc.SetWriteBuffer(o.WriteBuffer); err != nil {
+			return err
+		}
+	}
+	if o.KeepAlive < 0 {
+		if err := tc.SetKeepAlive(false); err != nil {
+			return err
+		}
+	} else if o.KeepAlive > 0 {
+		if err := tc.SetKeepAlive(true); err != nil {
+			return err
+		}
+		if err := tc.SetKeepAlivePeriod(o.KeepAlive); err != nil {
+			return err
+		}
+	}
+	if o.Linger < 0 {
+		if err := tc.SetLinger(0); err != nil {
+			return err
+		}
+	} else if o.Linger > 0 {
+		// in seconds, rounded up
+		if err := tc.SetLinger(int((o.Linger + time.Second - 1) / time.Second)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// n listeners on addr sharing the port with SO_REUSEPORT if n > 1, the
+// system spreads the connections over them
+func listen(addr string, n int) ([]net.Listener, error) {
+	if n <= 1 {
+		ln, err := net.Listen("tcp", addr)
+		if err != nil {
+			return nil, err
+		}
+		return []net.Listener{ln}, nil
+	}
+
+	lc := net.ListenConfig{
+		Control: func(network, address string, c syscall.RawConn) error {
+			var errOpt error
+			err := c.Control(func(fd uintptr) {
+				errOpt = setReusePort(fd)
+			})
+			if err != nil {
+				return err
+			}
+			return errOpt
+		},
+	}
+
+	var lns []net.Listener
+	for i := 0; i < n; i++ {
+		ln, err := lc.Listen(context.Background(), "tcp", addr)
+		if err != nil {
+			for _, ln := range lns {
+				ln.Close()
+			}
+			return nil, err
+		}
+		lns = append(lns, ln)
+
+		// the port picked for ":0" is shared
+		if i == 0 {
+			addr = ln.Addr().String()
+		}
+	}
+	return lns, nil
+}
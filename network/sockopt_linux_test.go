@@ -0,0 +1,145 @@
+package network
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+func Example_listen() {
+	lns, err := listen("127.0.0.1:0", 4)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	defer func() {
+		for _, ln := range lns {
+			ln.Close()
+		}
+	}()
+
+	// the port picked for the first listener is shared by the others
+	addr := lns[0].Addr().String()
+	shared := true
+	for _, ln := range lns[1:] {
+		shared = shared && ln.Addr().String() == addr
+	}
+	fmt.Println(len(lns), shared)
+
+	accepted := make(chan struct{}, len(lns))
+	for _, ln := range lns {
+		go func(ln net.Listener) {
+			for {
+				conn, err := ln.Accept()
+				if err != nil {
+					return
+				}
+				conn.Close()
+				accepted <- struct{}{}
+			}
+		}(ln)
+	}
+	for i := 0; i < 8; i++ {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		<-accepted
+		conn.Close()
+	}
+	fmt.Println("accepted")
+
+	// Output:
+	// 4 true
+	// accepted
+}
+
+type shardAgent struct {
+	conn *TCPConn
+}
+
+func (a *shardAgent) Run() {
+	for {
+		msg, err := a.conn.ReadMsg()
+		if err != nil {
+			return
+		}
+		a.conn.WriteMsg(msg)
+	}
+}
+
+func (a *shardAgent) OnClose() {}
+
+// counts the connections accepted
+type countListener struct {
+	net.Listener
+	accepted *int32
+}
+
+func (ln countListener) Accept() (net.Conn, error) {
+	conn, err := ln.Listener.Accept()
+	if err == nil {
+		atomic.AddInt32(ln.accepted, 1)
+	}
+	return conn, err
+}
+
+func ExampleTCPServer_acceptShards() {
+	server := new(TCPServer)
+	server.Addr = "127.0.0.1:3576"
+	server.MaxConnNum = 20
+	server.PendingWriteNum = 10
+	server.LenMsgLen = 2
+	server.AcceptShards = 4
+	server.SockOpts = SockOpts{
+		ReadBuffer: 4096,
+		KeepAlive:  -1,
+		Linger:     -1,
+	}
+	server.NewAgent = func(conn *TCPConn) Agent {
+		return &shardAgent{conn: conn}
+	}
+
+	// Start with the listeners counted
+	server.init()
+	accepted := make([]int32, len(server.lns))
+	for i, ln := range server.lns {
+		server.lns[i] = countListener{ln, &accepted[i]}
+	}
+	for _, ln := range server.lns {
+		server.wgLn.Add(1)
+		go server.run(ln)
+	}
+	defer server.Close()
+
+	// the connections spread over the listeners are all served
+	served := 0
+	for i := 0; i < 16; i++ {
+		conn, err := net.Dial("tcp", server.Addr)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		conn.Write([]byte{'{', '{', '{', 0, 4, 'p', 'i', 'n', 'g'})
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		b := make([]byte, 6)
+		if _, err := io.ReadFull(conn, b); err == nil && binary.BigEndian.Uint16(b) == 4 && string(b[2:]) == "ping" {
+			served++
+		}
+		conn.Close()
+	}
+	shards := 0
+	for i := range accepted {
+		if atomic.LoadInt32(&accepted[i]) > 0 {
+			shards++
+		}
+	}
+	fmt.Println("served:", served, len(accepted), shards > 1)
+
+	// Output:
+	// served: 16 4 true
+}
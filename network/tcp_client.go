@@ -16,6 +16,7 @@ type TCPClient struct {
 	PendingWriteNum int
 	AutoReconnect   bool
 	NewAgent        func(*TCPConn) Agent
+	SockOpts        SockOpts
 	conns           ConnSet
 	wg              sync.WaitGroup
 	closeFlag       bool
@@ -73,7 +74,14 @@ func (client *TCPClient) init() {
 func (client *TCPClient) dial() net.Conn {
 	for {
 		conn, err := net.Dial("tcp", client.Addr)
-		if err == nil || client.closeFlag {
+		if err == nil {
+			err = client.SockOpts.Apply(conn)
+			if err != nil {
+				log.Error("socket options error: %v", err)
+			}
+			return conn
+		}
+		if client.closeFlag {
 			return conn
 		}
 		log.Release("connect to %v error: %v", client.Addr, err)
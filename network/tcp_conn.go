@@ -4,31 +4,71 @@ import (
 	"github.com/name5566/leaf/log"
 	"net"
 	"sync"
+	"time"
 )
 
 type ConnSet map[net.Conn]struct{}
 
+// the zero value closes the connection
+type writeReq struct {
+	b   []byte
+	raw [][]byte
+	buf *Buffer
+}
+
+func (req writeReq) release() {
+	if req.buf != nil {
+		req.buf.Release()
+	}
+}
+
+// releases the buffers left in the channel once the writer stops
+func drainWriteChan(writeChan chan writeReq) {
+	for {
+		select {
+		case req, ok := <-writeChan:
+			if !ok {
+				return
+			}
+			req.release()
+		default:
+			return
+		}
+	}
+}
+
 type TCPConn struct {
 	sync.Mutex
 	conn      net.Conn
-	writeChan chan []byte
+	writeChan chan writeReq
 	closeFlag bool
 	msgParser *MsgParser
+
+	// read limits, set before reading
+	readTimeout time.Duration
+	minReadRate int
 }
 
 func newTCPConn(conn net.Conn, pendingWriteNum int, msgParser *MsgParser) *TCPConn {
 	tcpConn := new(TCPConn)
 	tcpConn.conn = conn
-	tcpConn.writeChan = make(chan []byte, pendingWriteNum)
+	tcpConn.writeChan = make(chan writeReq, pendingWriteNum)
 	tcpConn.msgParser = msgParser
 
 	go func() {
-		for b := range tcpConn.writeChan {
-			if b == nil {
+		for req := range tcpConn.writeChan {
+			if req.b == nil && req.buf == nil {
 				break
 			}
 
-			_, err := conn.Write(b)
+			var err error
+			if req.buf != nil {
+				bufs := net.Buffers(req.raw)
+				_, err = bufs.WriteTo(conn)
+				req.release()
+			} else {
+				_, err = conn.Write(req.b)
+			}
 			if err != nil {
 				break
 			}
@@ -38,6 +78,7 @@ func newTCPConn(conn net.Conn, pendingWriteNum int, msgParser *MsgParser) *TCPCo
 		tcpConn.Lock()
 		tcpConn.closeFlag = true
 		tcpConn.Unlock()
+		drainWriteChan(tcpConn.writeChan)
 	}()
 
 	return tcpConn
@@ -67,18 +108,19 @@ func (tcpConn *TCPConn) Close() {
 		return
 	}
 
-	tcpConn.doWrite(nil)
+	tcpConn.doWrite(writeReq{})
 	tcpConn.closeFlag = true
 }
 
-func (tcpConn *TCPConn) doWrite(b []byte) {
+func (tcpConn *TCPConn) doWrite(req writeReq) {
 	if len(tcpConn.writeChan) == cap(tcpConn.writeChan) {
 		log.Debug("close conn: channel full")
 		tcpConn.doDestroy()
+		req.release()
 		return
 	}
 
-	tcpConn.writeChan <- b
+	tcpConn.writeChan <- req
 }
 
 // b must not be modified by the others goroutines
@@ -89,7 +131,27 @@ func (tcpConn *TCPConn) Write(b []byte) {
 		return
 	}
 
-	tcpConn.doWrite(b)
+	tcpConn.doWrite(writeReq{b: b})
+}
+
+// buf is framed and written with no copy, it is retained until written
+func (tcpConn *TCPConn) WriteRaw(buf *Buffer) error {
+	header, err := tcpConn.msgParser.header(uint32(buf.Len()))
+	if err != nil {
+		return err
+	}
+
+	tcpConn.Lock()
+	defer tcpConn.Unlock()
+	if tcpConn.closeFlag {
+		return nil
+	}
+
+	raw := make([][]byte, 0, len(buf.Parts())+1)
+	raw = append(raw, header)
+	raw = append(raw, buf.Parts()...)
+	tcpConn.doWrite(writeReq{raw: raw, buf: buf.Retain()})
+	return nil
 }
 
 func (tcpConn *TCPConn) Read(b []byte) (int, error) {
@@ -105,7 +167,21 @@ func (tcpConn *TCPConn) RemoteAddr() net.Addr {
 }
 
 func (tcpConn *TCPConn) ReadMsg() ([]byte, error) {
-	return tcpConn.msgParser.Read(tcpConn)
+	if tcpConn.readTimeout <= 0 {
+		return tcpConn.msgParser.Read(tcpConn)
+	}
+
+	r := &rateReader{
+		r:       tcpConn,
+		conn:    tcpConn.conn,
+		timeout: tcpConn.readTimeout,
+		minRate: tcpConn.minReadRate,
+	}
+	b, err := tcpConn.msgParser.Read(r)
+	if err == nil {
+		tcpConn.conn.SetReadDeadline(noDeadline)
+	}
+	return b, err
 }
 
 func (tcpConn *TCPConn) WriteMsg(args ...[]byte) error {
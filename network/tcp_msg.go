@@ -15,6 +15,7 @@ type MsgParser struct {
 	minMsgLen    uint32
 	maxMsgLen    uint32
 	littleEndian bool
+	budget       *ByteBudget
 }
 
 func NewMsgParser() *MsgParser {
@@ -61,16 +62,56 @@ func (p *MsgParser) SetByteOrder(littleEndian bool) {
 	p.littleEndian = littleEndian
 }
 
+// It's dangerous to call the method on reading or writing
+// the reads fail while the budget is exhausted, which closes the
+// connections, see ByteBudget
+func (p *MsgParser) SetBudget(budget *ByteBudget) {
+	p.budget = budget
+}
+
 // goroutine safe
-func (p *MsgParser) Read(conn *TCPConn) ([]byte, error) {
+func (p *MsgParser) Read(r io.Reader) ([]byte, error) {
 	var b [4]byte
 	bufMsgLen := b[:p.lenMsgLen]
 
 	// read len
-	if _, err := io.ReadFull(conn, bufMsgLen); err != nil {
+	if _, err := io.ReadFull(r, bufMsgLen); err != nil {
 		return nil, err
 	}
 
+	msgLen, err := p.parseLen(bufMsgLen)
+	if err != nil {
+		return nil, err
+	}
+
+	if p.budget != nil {
+		if !p.budget.Acquire(int64(msgLen)) {
+			return nil, errors.New("too many bytes in flight")
+		}
+		defer p.budget.Release(int64(msgLen))
+	}
+
+	// data
+	msgData := make([]byte, msgLen)
+	if _, err := io.ReadFull(r, msgData); err != nil {
+		return nil, err
+	}
+
+	return msgData, nil
+}
+
+// goroutine safe
+// the len of the message framed at the start of b, ok is false if b is
+// shorter than the len
+func (p *MsgParser) peekLen(b []byte) (msgLen uint32, ok bool, err error) {
+	if len(b) < p.lenMsgLen {
+		return 0, false, nil
+	}
+	msgLen, err = p.parseLen(b[:p.lenMsgLen])
+	return msgLen, err == nil, err
+}
+
+func (p *MsgParser) parseLen(bufMsgLen []byte) (uint32, error) {
 	// parse len
 	var msgLen uint32
 	switch p.lenMsgLen {
@@ -92,22 +133,28 @@ func (p *MsgParser) Read(conn *TCPConn) ([]byte, error) {
 
 	// check len
 	if msgLen > p.maxMsgLen {
-		return nil, errors.New("message too long")
+		return 0, errors.New("message too long")
 	} else if msgLen < p.minMsgLen {
-		return nil, errors.New("message too short")
+		return 0, errors.New("message too short")
 	}
+	return msgLen, nil
+}
 
-	// data
-	msgData := make([]byte, msgLen)
-	if _, err := io.ReadFull(conn, msgData); err != nil {
-		return nil, err
+// goroutine safe
+func (p *MsgParser) Write(conn *TCPConn, args ...[]byte) error {
+	msg, err := p.Pack(args...)
+	if err != nil {
+		return err
 	}
 
-	return msgData, nil
+	conn.Write(msg)
+
+	return nil
 }
 
 // goroutine safe
-func (p *MsgParser) Write(conn *TCPConn, args ...[]byte) error {
+// returns the framed message
+func (p *MsgParser) Pack(args ...[]byte) ([]byte, error) {
 	// get len
 	var msgLen uint32
 	for i := 0; i < len(args); i++ {
@@ -116,14 +163,40 @@ func (p *MsgParser) Write(conn *TCPConn, args ...[]byte) error {
 
 	// check len
 	if msgLen > p.maxMsgLen {
-		return errors.New("message too long")
+		return nil, errors.New("message too long")
 	} else if msgLen < p.minMsgLen {
-		return errors.New("message too short")
+		return nil, errors.New("message too short")
 	}
 
 	msg := make([]byte, uint32(p.lenMsgLen)+msgLen)
+	p.putLen(msg, msgLen)
 
-	// write len
+	// write data
+	l := p.lenMsgLen
+	for i := 0; i < len(args); i++ {
+		copy(msg[l:], args[i])
+		l += len(args[i])
+	}
+
+	return msg, nil
+}
+
+// goroutine safe
+// returns the len of a message to frame
+func (p *MsgParser) header(msgLen uint32) ([]byte, error) {
+	// check len
+	if msgLen > p.maxMsgLen {
+		return nil, errors.New("message too long")
+	} else if msgLen < p.minMsgLen {
+		return nil, errors.New("message too short")
+	}
+
+	header := make([]byte, p.lenMsgLen)
+	p.putLen(header, msgLen)
+	return header, nil
+}
+
+func (p *MsgParser) putLen(msg []byte, msgLen uint32) {
 	switch p.lenMsgLen {
 	case 1:
 		msg[0] = byte(msgLen)
@@ -140,15 +213,4 @@ func (p *MsgParser) Write(conn *TCPConn, args ...[]byte) error {
 			binary.BigEndian.PutUint32(msg, msgLen)
 		}
 	}
-
-	// write data
-	l := p.lenMsgLen
-	for i := 0; i < len(args); i++ {
-		copy(msg[l:], args[i])
-		l += len(args[i])
-	}
-
-	conn.Write(msg)
-
-	return nil
 }
@@ -1,6 +1,7 @@
 package network
 
 import (
+	"io"
 	"net"
 	"sync"
 	"time"
@@ -15,11 +16,24 @@ type TCPServer struct {
 	MaxConnNum      int
 	PendingWriteNum int
 	NewAgent        func(*TCPConn) Agent
-	ln              net.Listener
-	conns           ConnSet
-	mutexConns      sync.Mutex
-	wgLn            sync.WaitGroup
-	wgConns         sync.WaitGroup
+
+	// read limits, ReadTimeout 0 means no limit
+	HandshakeTimeout time.Duration // to read the connection header
+	ReadTimeout      time.Duration // to read a message once its first byte arrives
+	MinReadRate      int           // bytes per second, extends ReadTimeout
+	ReadBudget       *ByteBudget   // bytes of the messages being read, a message beyond closes its connection
+
+	// listeners sharing Addr by SO_REUSEPORT, each with its own accept
+	// goroutine, 0 or 1 for one listener
+	AcceptShards int
+	// options of the accepted connections
+	SockOpts SockOpts
+
+	lns        []net.Listener
+	conns      ConnSet
+	mutexConns sync.Mutex
+	wgLn       sync.WaitGroup
+	wgConns    sync.WaitGroup
 
 	// msg parser
 	LenMsgLen    int
@@ -31,11 +45,14 @@ type TCPServer struct {
 
 func (server *TCPServer) Start() {
 	server.init()
-	go server.run()
+	for _, ln := range server.lns {
+		server.wgLn.Add(1)
+		go server.run(ln)
+	}
 }
 
 func (server *TCPServer) init() {
-	ln, err := net.Listen("tcp", server.Addr)
+	lns, err := listen(server.Addr, server.AcceptShards)
 	if err != nil {
 		log.Fatal("%v", err)
 	}
@@ -48,27 +65,30 @@ func (server *TCPServer) init() {
 		server.PendingWriteNum = 100
 		log.Release("invalid PendingWriteNum, reset to %v", server.PendingWriteNum)
 	}
+	if server.HandshakeTimeout <= 0 {
+		server.HandshakeTimeout = 3 * time.Second
+	}
 	if server.NewAgent == nil {
 		log.Fatal("NewAgent must not be nil")
 	}
 
-	server.ln = ln
+	server.lns = lns
 	server.conns = make(ConnSet)
 
 	// msg parser
 	msgParser := NewMsgParser()
 	msgParser.SetMsgLen(server.LenMsgLen, server.MinMsgLen, server.MaxMsgLen)
 	msgParser.SetByteOrder(server.LittleEndian)
+	msgParser.SetBudget(server.ReadBudget)
 	server.msgParser = msgParser
 }
 
-func (server *TCPServer) run() {
-	server.wgLn.Add(1)
+func (server *TCPServer) run(ln net.Listener) {
 	defer server.wgLn.Done()
 
 	var tempDelay time.Duration
 	for {
-		conn, err := server.ln.Accept()
+		conn, err := ln.Accept()
 		if err != nil {
 			if ne, ok := err.(net.Error); ok && ne.Temporary() {
 				if tempDelay == 0 {
@@ -87,45 +107,61 @@ func (server *TCPServer) run() {
 		}
 		tempDelay = 0
 		log.Debug(conn.RemoteAddr().String())
-		header := make([]byte, 3)
-		conn.SetReadDeadline(time.Now().Add(3 * time.Second))
-		_, err = conn.Read(header)
-		if err != nil || string(header) != "{{{" {
-			conn.Close()
-			continue
-		}
-		conn.SetReadDeadline(noDeadline)
-		server.mutexConns.Lock()
-		if len(server.conns) >= server.MaxConnNum {
-			server.mutexConns.Unlock()
-			conn.Close()
-			log.Debug("too many connections")
-			continue
+		err = server.SockOpts.Apply(conn)
+		if err != nil {
+			log.Error("socket options error: %v", err)
 		}
-		server.conns[conn] = struct{}{}
-		server.mutexConns.Unlock()
 
+		// the handshake must not block the accept loop
 		server.wgConns.Add(1)
+		go server.serve(conn)
+	}
+}
 
-		tcpConn := newTCPConn(conn, server.PendingWriteNum, server.msgParser)
-		agent := server.NewAgent(tcpConn)
-		go func() {
-			agent.Run()
-
-			// cleanup
-			tcpConn.Close()
-			server.mutexConns.Lock()
-			delete(server.conns, conn)
-			server.mutexConns.Unlock()
-			agent.OnClose()
+func (server *TCPServer) serve(conn net.Conn) {
+	defer server.wgConns.Done()
 
-			server.wgConns.Done()
-		}()
+	header := make([]byte, 3)
+	conn.SetReadDeadline(time.Now().Add(server.HandshakeTimeout))
+	_, err := io.ReadFull(conn, header)
+	if err != nil || string(header) != "{{{" {
+		conn.Close()
+		return
 	}
+	conn.SetReadDeadline(noDeadline)
+	server.mutexConns.Lock()
+	if server.conns == nil {
+		server.mutexConns.Unlock()
+		conn.Close()
+		return
+	}
+	if len(server.conns) >= server.MaxConnNum {
+		server.mutexConns.Unlock()
+		conn.Close()
+		log.Debug("too many connections")
+		return
+	}
+	server.conns[conn] = struct{}{}
+	server.mutexConns.Unlock()
+
+	tcpConn := newTCPConn(conn, server.PendingWriteNum, server.msgParser)
+	tcpConn.readTimeout = server.ReadTimeout
+	tcpConn.minReadRate = server.MinReadRate
+	agent := server.NewAgent(tcpConn)
+	agent.Run()
+
+	// cleanup
+	tcpConn.Close()
+	server.mutexConns.Lock()
+	delete(server.conns, conn)
+	server.mutexConns.Unlock()
+	agent.OnClose()
 }
 
 func (server *TCPServer) Close() {
-	server.ln.Close()
+	for _, ln := range server.lns {
+		ln.Close()
+	}
 	server.wgLn.Wait()
 
 	server.mutexConns.Lock()
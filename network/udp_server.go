@@ -0,0 +1,100 @@
+package network
+
+import (
+	"errors"
+	"github.com/name5566/leaf/log"
+	"net"
+	"sync"
+)
+
+// every datagram from the clients starts with a token issued over the
+// reliable connection
+const DatagramTokenLen = 16
+
+// ------------------
+// | token | data |
+// ------------------
+type UDPServer struct {
+	Addr       string
+	MaxMsgLen  uint32
+	OnDatagram func(token string, addr *net.UDPAddr, data []byte)
+	conn       *net.UDPConn
+	wg         sync.WaitGroup
+}
+
+func (server *UDPServer) Start() {
+	addr, err := net.ResolveUDPAddr("udp", server.Addr)
+	if err != nil {
+		log.Fatal("%v", err)
+	}
+	conn, err := net.ListenUDP("udp", addr)
+	if err != nil {
+		log.Fatal("%v", err)
+	}
+
+	if server.MaxMsgLen <= 0 {
+		server.MaxMsgLen = 1024
+		log.Release("invalid MaxMsgLen, reset to %v", server.MaxMsgLen)
+	}
+	if server.OnDatagram == nil {
+		log.Fatal("OnDatagram must not be nil")
+	}
+
+	server.conn = conn
+	server.wg.Add(1)
+	go server.run()
+}
+
+func (server *UDPServer) run() {
+	defer server.wg.Done()
+
+	buf := make([]byte, 64*1024)
+	for {
+		n, addr, err := server.conn.ReadFromUDP(buf)
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				continue
+			}
+			return
+		}
+		if n <= DatagramTokenLen || uint32(n-DatagramTokenLen) > server.MaxMsgLen {
+			continue
+		}
+
+		data := make([]byte, n-DatagramTokenLen)
+		copy(data, buf[DatagramTokenLen:n])
+		server.OnDatagram(string(buf[:DatagramTokenLen]), addr, data)
+	}
+}
+
+// goroutine safe
+func (server *UDPServer) WriteTo(addr *net.UDPAddr, args ...[]byte) error {
+	// get len
+	var msgLen uint32
+	for i := 0; i < len(args); i++ {
+		msgLen += uint32(len(args[i]))
+	}
+
+	// check len
+	if msgLen > server.MaxMsgLen {
+		return errors.New("message too long")
+	} else if msgLen < 1 {
+		return errors.New("message too short")
+	}
+
+	// merge the args
+	msg := make([]byte, msgLen)
+	l := 0
+	for i := 0; i < len(args); i++ {
+		copy(msg[l:], args[i])
+		l += len(args[i])
+	}
+
+	_, err := server.conn.WriteToUDP(msg, addr)
+	return err
+}
+
+func (server *UDPServer) Close() {
+	server.conn.Close()
+	server.wg.Wait()
+}
@@ -4,8 +4,10 @@ import (
 	"errors"
 	"github.com/gorilla/websocket"
 	"github.com/name5566/leaf/log"
+	"io"
 	"net"
 	"sync"
+	"time"
 )
 
 type WebsocketConnSet map[*websocket.Conn]struct{}
@@ -13,24 +15,35 @@ type WebsocketConnSet map[*websocket.Conn]struct{}
 type WSConn struct {
 	sync.Mutex
 	conn      *websocket.Conn
-	writeChan chan []byte
+	writeChan chan writeReq
 	maxMsgLen uint32
 	closeFlag bool
+
+	// read limits, set before reading
+	readTimeout time.Duration
+	minReadRate int
+	readBudget  *ByteBudget
 }
 
 func newWSConn(conn *websocket.Conn, pendingWriteNum int, maxMsgLen uint32) *WSConn {
 	wsConn := new(WSConn)
 	wsConn.conn = conn
-	wsConn.writeChan = make(chan []byte, pendingWriteNum)
+	wsConn.writeChan = make(chan writeReq, pendingWriteNum)
 	wsConn.maxMsgLen = maxMsgLen
 
 	go func() {
-		for b := range wsConn.writeChan {
-			if b == nil {
+		for req := range wsConn.writeChan {
+			if req.b == nil && req.buf == nil {
 				break
 			}
 
-			err := conn.WriteMessage(websocket.BinaryMessage, b)
+			var err error
+			if req.buf != nil {
+				err = writeParts(conn, req.raw)
+				req.release()
+			} else {
+				err = conn.WriteMessage(websocket.BinaryMessage, req.b)
+			}
 			if err != nil {
 				break
 			}
@@ -40,6 +53,7 @@ func newWSConn(conn *websocket.Conn, pendingWriteNum int, maxMsgLen uint32) *WSC
 		wsConn.Lock()
 		wsConn.closeFlag = true
 		wsConn.Unlock()
+		drainWriteChan(wsConn.writeChan)
 	}()
 
 	return wsConn
@@ -69,18 +83,34 @@ func (wsConn *WSConn) Close() {
 		return
 	}
 
-	wsConn.doWrite(nil)
+	wsConn.doWrite(writeReq{})
 	wsConn.closeFlag = true
 }
 
-func (wsConn *WSConn) doWrite(b []byte) {
+func (wsConn *WSConn) doWrite(req writeReq) {
 	if len(wsConn.writeChan) == cap(wsConn.writeChan) {
 		log.Debug("close conn: channel full")
 		wsConn.doDestroy()
+		req.release()
 		return
 	}
 
-	wsConn.writeChan <- b
+	wsConn.writeChan <- req
+}
+
+// the parts make up one websocket message
+func writeParts(conn *websocket.Conn, parts [][]byte) error {
+	w, err := conn.NextWriter(websocket.BinaryMessage)
+	if err != nil {
+		return err
+	}
+	for _, p := range parts {
+		if _, err := w.Write(p); err != nil {
+			w.Close()
+			return err
+		}
+	}
+	return w.Close()
 }
 
 func (wsConn *WSConn) LocalAddr() net.Addr {
@@ -93,8 +123,56 @@ func (wsConn *WSConn) RemoteAddr() net.Addr {
 
 // goroutine not safe
 func (wsConn *WSConn) ReadMsg() ([]byte, error) {
-	_, b, err := wsConn.conn.ReadMessage()
-	return b, err
+	if wsConn.readTimeout <= 0 && wsConn.readBudget == nil {
+		_, b, err := wsConn.conn.ReadMessage()
+		return b, err
+	}
+
+	_, r, err := wsConn.conn.NextReader()
+	if err != nil {
+		return nil, err
+	}
+	if wsConn.readTimeout > 0 {
+		rr := &rateReader{
+			r:       r,
+			conn:    wsConn.conn,
+			timeout: wsConn.readTimeout,
+			minRate: wsConn.minReadRate,
+		}
+		rr.begin()
+		r = rr
+	}
+
+	var acquired int64
+	defer func() {
+		if wsConn.readBudget != nil {
+			wsConn.readBudget.Release(acquired)
+		}
+	}()
+
+	var b []byte
+	chunk := make([]byte, 4096)
+	for {
+		n, err := r.Read(chunk)
+		// only the bytes kept count
+		if wsConn.readBudget != nil && n > 0 {
+			if !wsConn.readBudget.Acquire(int64(n)) {
+				return nil, errors.New("too many bytes in flight")
+			}
+			acquired += int64(n)
+		}
+		b = append(b, chunk[:n]...)
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+	}
+
+	if wsConn.readTimeout > 0 {
+		wsConn.conn.SetReadDeadline(time.Time{})
+	}
+	return b, nil
 }
 
 // args must not be modified by the others goroutines
@@ -120,7 +198,7 @@ func (wsConn *WSConn) WriteMsg(args ...[]byte) error {
 
 	// don't copy
 	if len(args) == 1 {
-		wsConn.doWrite(args[0])
+		wsConn.doWrite(writeReq{b: args[0]})
 		return nil
 	}
 
@@ -132,7 +210,27 @@ func (wsConn *WSConn) WriteMsg(args ...[]byte) error {
 		l += len(args[i])
 	}
 
-	wsConn.doWrite(msg)
+	wsConn.doWrite(writeReq{b: msg})
+
+	return nil
+}
+
+// buf is written as one message with no copy, it is retained until written
+func (wsConn *WSConn) WriteRaw(buf *Buffer) error {
+	wsConn.Lock()
+	defer wsConn.Unlock()
+	if wsConn.closeFlag {
+		return nil
+	}
+
+	// check len
+	msgLen := uint32(buf.Len())
+	if msgLen > wsConn.maxMsgLen {
+		return errors.New("message too long")
+	} else if msgLen < 1 {
+		return errors.New("message too short")
+	}
 
+	wsConn.doWrite(writeReq{raw: buf.Parts(), buf: buf.Retain()})
 	return nil
 }
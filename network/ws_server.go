@@ -19,15 +19,32 @@ type WSServer struct {
 	CertFile        string
 	KeyFile         string
 	NewAgent        func(*WSConn) Agent
-	ln              net.Listener
-	handler         *WSHandler
+
+	// read limits, ReadTimeout 0 means no limit
+	HandshakeTimeout time.Duration // to read the request headers of the upgrade
+	ReadTimeout      time.Duration // to read a message once its first byte arrives
+	MinReadRate      int           // bytes per second, extends ReadTimeout
+	ReadBudget       *ByteBudget   // bytes of the messages being read, a message beyond closes its connection
+
+	ln      net.Listener
+	handler *WSHandler
+
+	// long polling, served from the same HTTP server
+	LPPath           string
+	LPPollTimeout    time.Duration
+	LPSessionTimeout time.Duration
+	NewLPAgent       func(*LPConn) Agent
+	lpHandler        *LPHandler
 }
 
 type WSHandler struct {
-	maxConnNum      int
+	connNum         *connNum
 	pendingWriteNum int
 	maxMsgLen       uint32
 	newAgent        func(*WSConn) Agent
+	readTimeout     time.Duration
+	minReadRate     int
+	readBudget      *ByteBudget
 	upgrader        websocket.Upgrader
 	conns           WebsocketConnSet
 	mutexConns      sync.Mutex
@@ -55,7 +72,7 @@ func (handler *WSHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		conn.Close()
 		return
 	}
-	if len(handler.conns) >= handler.maxConnNum {
+	if !handler.connNum.add() {
 		handler.mutexConns.Unlock()
 		conn.Close()
 		log.Debug("too many connections")
@@ -65,6 +82,9 @@ func (handler *WSHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	handler.mutexConns.Unlock()
 
 	wsConn := newWSConn(conn, handler.pendingWriteNum, handler.maxMsgLen)
+	wsConn.readTimeout = handler.readTimeout
+	wsConn.minReadRate = handler.minReadRate
+	wsConn.readBudget = handler.readBudget
 	agent := handler.newAgent(wsConn)
 	agent.Run()
 
@@ -73,6 +93,7 @@ func (handler *WSHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	handler.mutexConns.Lock()
 	delete(handler.conns, conn)
 	handler.mutexConns.Unlock()
+	handler.connNum.done()
 	agent.OnClose()
 }
 
@@ -98,9 +119,25 @@ func (server *WSServer) Start() {
 		server.HTTPTimeout = 10 * time.Second
 		log.Release("invalid HTTPTimeout, reset to %v", server.HTTPTimeout)
 	}
+	if server.HandshakeTimeout <= 0 || server.HandshakeTimeout > server.HTTPTimeout {
+		server.HandshakeTimeout = server.HTTPTimeout
+	}
 	if server.NewAgent == nil {
 		log.Fatal("NewAgent must not be nil")
 	}
+	if server.LPPath != "" {
+		if server.LPPollTimeout <= 0 || server.LPPollTimeout >= server.HTTPTimeout {
+			server.LPPollTimeout = server.HTTPTimeout / 2
+			log.Release("invalid LPPollTimeout, reset to %v", server.LPPollTimeout)
+		}
+		if server.LPSessionTimeout <= 0 {
+			server.LPSessionTimeout = server.HTTPTimeout + server.LPPollTimeout
+			log.Release("invalid LPSessionTimeout, reset to %v", server.LPSessionTimeout)
+		}
+		if server.NewLPAgent == nil {
+			log.Fatal("NewLPAgent must not be nil")
+		}
+	}
 
 	if server.CertFile != "" || server.KeyFile != "" {
 		config := &tls.Config{}
@@ -116,25 +153,50 @@ func (server *WSServer) Start() {
 		ln = tls.NewListener(ln, config)
 	}
 
+	// the websocket and the long polling connections share MaxConnNum
+	connNum := &connNum{max: server.MaxConnNum}
+
 	server.ln = ln
 	server.handler = &WSHandler{
-		maxConnNum:      server.MaxConnNum,
+		connNum:         connNum,
 		pendingWriteNum: server.PendingWriteNum,
 		maxMsgLen:       server.MaxMsgLen,
 		newAgent:        server.NewAgent,
+		readTimeout:     server.ReadTimeout,
+		minReadRate:     server.MinReadRate,
+		readBudget:      server.ReadBudget,
 		conns:           make(WebsocketConnSet),
 		upgrader: websocket.Upgrader{
-			HandshakeTimeout: server.HTTPTimeout,
+			HandshakeTimeout: server.HandshakeTimeout,
 			CheckOrigin:      func(_ *http.Request) bool { return true },
 		},
 	}
 
+	var handler http.Handler = server.handler
+	if server.LPPath != "" {
+		server.lpHandler = &LPHandler{
+			connNum:         connNum,
+			pendingWriteNum: server.PendingWriteNum,
+			maxMsgLen:       server.MaxMsgLen,
+			pollTimeout:     server.LPPollTimeout,
+			sessionTimeout:  server.LPSessionTimeout,
+			newAgent:        server.NewLPAgent,
+			conns:           make(map[string]*LPConn),
+		}
+
+		mux := http.NewServeMux()
+		mux.Handle(server.LPPath, server.lpHandler)
+		mux.Handle("/", server.handler)
+		handler = mux
+	}
+
 	httpServer := &http.Server{
-		Addr:           server.Addr,
-		Handler:        server.handler,
-		ReadTimeout:    server.HTTPTimeout,
-		WriteTimeout:   server.HTTPTimeout,
-		MaxHeaderBytes: 1024,
+		Addr:              server.Addr,
+		Handler:           handler,
+		ReadHeaderTimeout: server.HandshakeTimeout,
+		ReadTimeout:       server.HTTPTimeout,
+		WriteTimeout:      server.HTTPTimeout,
+		MaxHeaderBytes:    1024,
 	}
 
 	go httpServer.Serve(ln)
@@ -151,4 +213,32 @@ func (server *WSServer) Close() {
 	server.handler.mutexConns.Unlock()
 
 	server.handler.wg.Wait()
+
+	if server.lpHandler != nil {
+		server.lpHandler.close()
+	}
+}
+
+// goroutine safe
+type connNum struct {
+	mutex sync.Mutex
+	n     int
+	max   int
+}
+
+// false if there are already max connections
+func (c *connNum) add() bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.n >= c.max {
+		return false
+	}
+	c.n++
+	return true
+}
+
+func (c *connNum) done() {
+	c.mutex.Lock()
+	c.n--
+	c.mutex.Unlock()
 }
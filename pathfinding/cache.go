@@ -0,0 +1,87 @@
+package pathfinding
+
+import (
+	"container/list"
+	"sync"
+)
+
+type cacheKey struct {
+	grid      *Grid
+	version   uint64
+	from      Point
+	to        Point
+	algorithm int
+	diagonal  bool
+	smooth    bool
+}
+
+type cacheEntry struct {
+	key  cacheKey
+	path []Point
+}
+
+// the least recently used paths, a change of the grid makes its paths
+// stale, they are dropped in time
+// goroutine safe
+type Cache struct {
+	size  int
+	mutex sync.Mutex
+	ll    *list.List
+	m     map[cacheKey]*list.Element
+}
+
+func NewCache(size int) *Cache {
+	c := new(Cache)
+	if size <= 0 {
+		size = 1
+	}
+	c.size = size
+	c.ll = list.New()
+	c.m = make(map[cacheKey]*list.Element)
+	return c
+}
+
+func (c *Cache) Len() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return c.ll.Len()
+}
+
+func (c *Cache) Clear() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.ll.Init()
+	c.m = make(map[cacheKey]*list.Element)
+}
+
+// a nil path means no path
+func (c *Cache) get(key cacheKey) ([]Point, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	e := c.m[key]
+	if e == nil {
+		return nil, false
+	}
+	c.ll.MoveToFront(e)
+	return e.Value.(*cacheEntry).path, true
+}
+
+func (c *Cache) add(key cacheKey, path []Point) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if e := c.m[key]; e != nil {
+		e.Value.(*cacheEntry).path = path
+		c.ll.MoveToFront(e)
+		return
+	}
+	c.m[key] = c.ll.PushFront(&cacheEntry{key, path})
+	for c.ll.Len() > c.size {
+		e := c.ll.Back()
+		c.ll.Remove(e)
+		delete(c.m, e.Value.(*cacheEntry).key)
+	}
+}
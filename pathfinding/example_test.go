@@ -0,0 +1,226 @@
+package pathfinding_test
+
+import (
+	"fmt"
+	"github.com/name5566/leaf/chanrpc"
+	"github.com/name5566/leaf/module"
+	"github.com/name5566/leaf/pathfinding"
+	"strings"
+)
+
+func ExampleFinder() {
+	g, err := pathfinding.ReadGrid(strings.NewReader("// map" + testMap))
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	f := &pathfinding.Finder{
+		Grid:      g,
+		Algorithm: pathfinding.JPS,
+		Budget:    1000,
+		Smooth:    true,
+		Cache:     pathfinding.NewCache(100),
+	}
+	path, err := f.Find(pathfinding.Point{0, 3}, pathfinding.Point{6, 3})
+	fmt.Println(path, err)
+
+	g.SetBlocked(6, 1, true)
+	_, err = f.Find(pathfinding.Point{0, 3}, pathfinding.Point{6, 3})
+	fmt.Println(err)
+
+	// Output:
+	// [{0 3} {0 0} {6 0} {6 3}] <nil>
+	// no path
+}
+
+const testMap = `
+.......
+.#####.
+.....#.
+.....#.
+`
+
+func ExampleFinder_aStar() {
+	g, _ := pathfinding.ReadGrid(strings.NewReader(testMap))
+
+	// 4 directions
+	f := &pathfinding.Finder{
+		Grid:      g,
+		Algorithm: pathfinding.AStar,
+	}
+	path, err := f.Find(pathfinding.Point{0, 3}, pathfinding.Point{6, 3})
+	fmt.Println(path, err)
+
+	// 8 directions
+	f.Diagonal = true
+	f.Smooth = true
+	path, err = f.Find(pathfinding.Point{0, 3}, pathfinding.Point{6, 3})
+	fmt.Println(path, err)
+
+	// Output:
+	// [{0 3} {0 2} {0 1} {0 0} {1 0} {2 0} {3 0} {4 0} {5 0} {6 0} {6 1} {6 2} {6 3}] <nil>
+	// [{0 3} {0 0} {6 0} {6 3}] <nil>
+}
+
+func ExampleFinder_budget() {
+	g, _ := pathfinding.ReadGrid(strings.NewReader(testMap))
+
+	f := &pathfinding.Finder{
+		Grid:      g,
+		Algorithm: pathfinding.AStar,
+		Budget:    5,
+	}
+	_, err := f.Find(pathfinding.Point{0, 3}, pathfinding.Point{6, 3})
+	fmt.Println(err)
+
+	// Output:
+	// search budget exceeded
+}
+
+func ExampleFinder_FindAsync() {
+	g, _ := pathfinding.ReadGrid(strings.NewReader(testMap))
+
+	f := &pathfinding.Finder{
+		Grid:      g,
+		Algorithm: pathfinding.JPS,
+		Budget:    5,
+		Smooth:    true,
+		Cache:     pathfinding.NewCache(100),
+	}
+
+	s := &module.Skeleton{
+		GoLen:         10,
+		ChanRPCServer: chanrpc.NewServer(10),
+	}
+	s.Init()
+	closeSig := make(chan bool, 1)
+	done := make(chan struct{})
+	go func() {
+		s.Run(closeSig)
+		close(done)
+	}()
+
+	found := make(chan struct{})
+	s.RegisterChanRPC("find", func(args []interface{}) {
+		// Budget applies to Find only, the search off the loop is unlimited
+		f.FindAsync(s, pathfinding.Point{0, 3}, pathfinding.Point{6, 3}, func(path []pathfinding.Point, err error) {
+			fmt.Println(path, err)
+			found <- struct{}{}
+		})
+	})
+	s.ChanRPCServer.Go("find")
+	<-found
+
+	// cached, the callback is called at once
+	s.ChanRPCServer.Go("find")
+	<-found
+
+	closeSig <- true
+	<-done
+
+	// Output:
+	// [{0 3} {0 0} {6 0} {6 3}] <nil>
+	// [{0 3} {0 0} {6 0} {6 3}] <nil>
+}
+
+// an L of three squares and an island
+const testMesh = `
+// A
+v 0 0
+v 10 0
+v 10 10
+v 0 10
+p 0 1 2 3
+// B, right of A
+v 20 0
+v 20 10
+p 1 4 5 2
+// C, above B, clockwise
+v 20 20
+v 10 20
+p 2 7 6 5
+// the island
+v 30 0
+v 40 0
+v 40 10
+v 30 10
+p 8 9 10 11
+`
+
+func ExampleNavMesh() {
+	m, err := pathfinding.ReadNavMesh(strings.NewReader(testMesh))
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	fmt.Println(m.Len(), m.Locate(pathfinding.Vec2{15, 15}), m.Locate(pathfinding.Vec2{5, 15}))
+
+	// around the inner corner
+	fmt.Println(m.Find(pathfinding.Vec2{1, 1}, pathfinding.Vec2{11, 19}))
+	fmt.Println(m.Find(pathfinding.Vec2{11, 19}, pathfinding.Vec2{1, 1}))
+	// in line of sight
+	fmt.Println(m.Find(pathfinding.Vec2{1, 1}, pathfinding.Vec2{15, 5}))
+	fmt.Println(m.Find(pathfinding.Vec2{1, 1}, pathfinding.Vec2{2, 2}))
+	// off the mesh and unreachable
+	fmt.Println(m.Find(pathfinding.Vec2{1, 1}, pathfinding.Vec2{5, 15}))
+	fmt.Println(m.Find(pathfinding.Vec2{1, 1}, pathfinding.Vec2{35, 5}))
+
+	m.Budget = 1
+	fmt.Println(m.Find(pathfinding.Vec2{1, 1}, pathfinding.Vec2{11, 19}))
+
+	_, err = pathfinding.ReadNavMesh(strings.NewReader("v 0 0\nv 10 0\nv 10 10\nv 8 2\np 0 1 2 3\n"))
+	fmt.Println(err)
+
+	// a corridor zigzagging up
+	m, _ = pathfinding.NewNavMesh([]pathfinding.Vec2{
+		{0, 0}, {20, 0}, {30, 0}, {30, 10}, {20, 10}, {0, 10},
+		{30, 20}, {20, 20}, {30, 30}, {20, 30}, {0, 30}, {0, 20},
+	}, [][]int{{0, 1, 4, 5}, {1, 2, 3, 4}, {4, 3, 6, 7}, {7, 6, 8, 9}, {11, 7, 9, 10}})
+	fmt.Println(m.Find(pathfinding.Vec2{1, 5}, pathfinding.Vec2{1, 25}))
+
+	// Output:
+	// 4 2 -1
+	// [{1 1} {10 10} {11 19}] <nil>
+	// [{11 19} {10 10} {1 1}] <nil>
+	// [{1 1} {15 5}] <nil>
+	// [{1 1} {2 2}] <nil>
+	// [] no path
+	// [] no path
+	// [] search budget exceeded
+	// polygon 0: not convex
+	// [{1 5} {20 10} {20 20} {1 25}] <nil>
+}
+
+func ExampleNavMesh_FindAsync() {
+	m, _ := pathfinding.ReadNavMesh(strings.NewReader(testMesh))
+	m.Budget = 1
+
+	s := &module.Skeleton{
+		GoLen:         10,
+		ChanRPCServer: chanrpc.NewServer(10),
+	}
+	s.Init()
+	found := make(chan struct{})
+	s.RegisterChanRPC("find", func(args []interface{}) {
+		// Budget applies to Find only
+		m.FindAsync(s, pathfinding.Vec2{1, 1}, pathfinding.Vec2{11, 19}, func(path []pathfinding.Vec2, err error) {
+			fmt.Println(path, err)
+			close(found)
+		})
+	})
+	closeSig := make(chan bool, 1)
+	done := make(chan struct{})
+	go func() {
+		s.Run(closeSig)
+		close(done)
+	}()
+
+	s.ChanRPCServer.Go("find")
+	<-found
+	closeSig <- true
+	<-done
+
+	// Output:
+	// [{1 1} {10 10} {11 19}] <nil>
+}
@@ -0,0 +1,427 @@
+package pathfinding
+
+import (
+	"container/heap"
+	"errors"
+	"github.com/name5566/leaf/module"
+	"math"
+)
+
+var (
+	ErrNoPath = errors.New("no path")
+	ErrBudget = errors.New("search budget exceeded")
+)
+
+const (
+	AStar = iota
+	JPS   // jump point search, 8 directions only
+)
+
+// a finder may be used by several goroutines at once if its Grid is not
+// modified meanwhile, the paths returned must not be modified, they may be
+// shared by the cache
+type Finder struct {
+	Grid      *Grid
+	Algorithm int
+	// 8 directions, a diagonal move never crosses a blocked corner
+	Diagonal bool
+	// the max cells examined by a search on the module goroutine, 0 for no
+	// limit, ErrBudget is returned beyond
+	Budget int
+	// the budget of FindAsync, 0 for no limit
+	AsyncBudget int
+	// the path is reduced to the waypoints in line of sight
+	Smooth bool
+	// nil for no cache
+	Cache *Cache
+}
+
+// the path from from to to, both included
+func (f *Finder) Find(from, to Point) ([]Point, error) {
+	return f.find(f.cacheKey(from, to), f.Budget)
+}
+
+// runs the search by s.Go, cb is called on the module goroutine, at once if
+// the path is cached
+func (f *Finder) FindAsync(s *module.Skeleton, from, to Point, cb func(path []Point, err error)) {
+	// the version of the grid is read on the module goroutine
+	key := f.cacheKey(from, to)
+	if path, ok := f.cached(key); ok {
+		cb(path, pathErr(path))
+		return
+	}
+
+	var path []Point
+	var err error
+	s.Go(func() {
+		path, err = f.find(key, f.AsyncBudget)
+	}, func() {
+		cb(path, err)
+	})
+}
+
+func pathErr(path []Point) error {
+	if path == nil {
+		return ErrNoPath
+	}
+	return nil
+}
+
+func (f *Finder) cacheKey(from, to Point) cacheKey {
+	return cacheKey{
+		grid:      f.Grid,
+		version:   f.Grid.version,
+		from:      from,
+		to:        to,
+		algorithm: f.Algorithm,
+		diagonal:  f.Diagonal,
+		smooth:    f.Smooth,
+	}
+}
+
+func (f *Finder) cached(key cacheKey) ([]Point, bool) {
+	if f.Cache == nil {
+		return nil, false
+	}
+	return f.Cache.get(key)
+}
+
+func (f *Finder) find(key cacheKey, budget int) ([]Point, error) {
+	if path, ok := f.cached(key); ok {
+		return path, pathErr(path)
+	}
+	from, to := key.from, key.to
+
+	s := &search{
+		grid:     f.Grid,
+		to:       to,
+		diagonal: f.Diagonal || f.Algorithm == JPS,
+		jps:      f.Algorithm == JPS,
+		budget:   budget,
+		nodes:    make(map[Point]*node),
+	}
+	path := s.run(from)
+	if s.exceeded {
+		return nil, ErrBudget
+	}
+	if path != nil && f.Smooth {
+		path = Smooth(f.Grid, path)
+	}
+	if f.Cache != nil {
+		f.Cache.add(key, path)
+	}
+	return path, pathErr(path)
+}
+
+type node struct {
+	p      Point
+	g      float64
+	f      float64
+	parent *node
+	index  int
+	closed bool
+}
+
+type nodeHeap []*node
+
+func (h nodeHeap) Len() int           { return len(h) }
+func (h nodeHeap) Less(i, j int) bool { return h[i].f < h[j].f }
+func (h nodeHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *nodeHeap) Push(x interface{}) {
+	n := x.(*node)
+	n.index = len(*h)
+	*h = append(*h, n)
+}
+
+func (h *nodeHeap) Pop() interface{} {
+	old := *h
+	n := old[len(old)-1]
+	old[len(old)-1] = nil
+	*h = old[:len(old)-1]
+	n.index = -1
+	return n
+}
+
+type search struct {
+	grid     *Grid
+	to       Point
+	diagonal bool
+	jps      bool
+	budget   int
+	steps    int
+	exceeded bool
+	nodes    map[Point]*node
+	open     nodeHeap
+}
+
+// counts a cell examined, false once the budget is exceeded
+func (s *search) step() bool {
+	s.steps++
+	if s.budget > 0 && s.steps > s.budget {
+		s.exceeded = true
+		return false
+	}
+	return true
+}
+
+func (s *search) heuristic(a, b Point) float64 {
+	dx := math.Abs(float64(a.X - b.X))
+	dy := math.Abs(float64(a.Y - b.Y))
+	if s.diagonal {
+		// octile
+		return dx + dy + (math.Sqrt2-2)*math.Min(dx, dy)
+	}
+	return dx + dy
+}
+
+func (s *search) run(from Point) []Point {
+	g := s.grid
+	if !g.Walkable(from.X, from.Y) || !g.Walkable(s.to.X, s.to.Y) {
+		return nil
+	}
+
+	start := &node{p: from, f: s.heuristic(from, s.to)}
+	s.nodes[from] = start
+	heap.Push(&s.open, start)
+	for s.open.Len() > 0 {
+		n := heap.Pop(&s.open).(*node)
+		n.closed = true
+		if n.p == s.to {
+			return s.path(n)
+		}
+
+		for _, p := range s.successors(n) {
+			if s.exceeded {
+				return nil
+			}
+			m := s.nodes[p]
+			if m != nil && m.closed {
+				continue
+			}
+			cost := n.g + s.heuristic(n.p, p)
+			if m == nil {
+				m = &node{p: p, index: -1}
+				s.nodes[p] = m
+			} else if cost >= m.g {
+				continue
+			}
+			m.g = cost
+			m.f = cost + s.heuristic(p, s.to)
+			m.parent = n
+			if m.index >= 0 {
+				heap.Fix(&s.open, m.index)
+			} else {
+				heap.Push(&s.open, m)
+			}
+		}
+		if s.exceeded {
+			return nil
+		}
+	}
+	return nil
+}
+
+// the cells of the path, the jump points are joined by straight or
+// diagonal lines
+func (s *search) path(n *node) []Point {
+	var points []Point
+	for ; n != nil; n = n.parent {
+		points = append(points, n.p)
+	}
+	for i, j := 0, len(points)-1; i < j; i, j = i+1, j-1 {
+		points[i], points[j] = points[j], points[i]
+	}
+	if !s.jps {
+		return points
+	}
+
+	path := []Point{points[0]}
+	for _, p := range points[1:] {
+		last := path[len(path)-1]
+		dx, dy := sign(p.X-last.X), sign(p.Y-last.Y)
+		for last != p {
+			last = Point{last.X + dx, last.Y + dy}
+			path = append(path, last)
+		}
+	}
+	return path
+}
+
+func sign(i int) int {
+	switch {
+	case i > 0:
+		return 1
+	case i < 0:
+		return -1
+	default:
+		return 0
+	}
+}
+
+func (s *search) successors(n *node) []Point {
+	if !s.jps {
+		if !s.step() {
+			return nil
+		}
+		return s.neighbors(n.p)
+	}
+
+	var points []Point
+	for _, p := range s.pruned(n) {
+		jp, ok := s.jump(p.X, p.Y, n.p.X, n.p.Y)
+		if ok {
+			points = append(points, jp)
+		}
+	}
+	return points
+}
+
+// 4 directions, the diagonals if both sides are walkable
+func (s *search) neighbors(p Point) []Point {
+	g := s.grid
+	var points []Point
+	up := g.Walkable(p.X, p.Y-1)
+	down := g.Walkable(p.X, p.Y+1)
+	left := g.Walkable(p.X-1, p.Y)
+	right := g.Walkable(p.X+1, p.Y)
+	if up {
+		points = append(points, Point{p.X, p.Y - 1})
+	}
+	if right {
+		points = append(points, Point{p.X + 1, p.Y})
+	}
+	if down {
+		points = append(points, Point{p.X, p.Y + 1})
+	}
+	if left {
+		points = append(points, Point{p.X - 1, p.Y})
+	}
+	if !s.diagonal {
+		return points
+	}
+	if up && left && g.Walkable(p.X-1, p.Y-1) {
+		points = append(points, Point{p.X - 1, p.Y - 1})
+	}
+	if up && right && g.Walkable(p.X+1, p.Y-1) {
+		points = append(points, Point{p.X + 1, p.Y - 1})
+	}
+	if down && right && g.Walkable(p.X+1, p.Y+1) {
+		points = append(points, Point{p.X + 1, p.Y + 1})
+	}
+	if down && left && g.Walkable(p.X-1, p.Y+1) {
+		points = append(points, Point{p.X - 1, p.Y + 1})
+	}
+	return points
+}
+
+// the neighbors worth a jump given the direction from the parent
+func (s *search) pruned(n *node) []Point {
+	if n.parent == nil {
+		return s.neighbors(n.p)
+	}
+
+	g := s.grid
+	x, y := n.p.X, n.p.Y
+	dx, dy := sign(x-n.parent.p.X), sign(y-n.parent.p.Y)
+	var points []Point
+	if dx != 0 && dy != 0 {
+		v := g.Walkable(x, y+dy)
+		h := g.Walkable(x+dx, y)
+		if v {
+			points = append(points, Point{x, y + dy})
+		}
+		if h {
+			points = append(points, Point{x + dx, y})
+		}
+		if v && h {
+			points = append(points, Point{x + dx, y + dy})
+		}
+	} else if dx != 0 {
+		next := g.Walkable(x+dx, y)
+		top := g.Walkable(x, y-1)
+		bottom := g.Walkable(x, y+1)
+		if next {
+			points = append(points, Point{x + dx, y})
+			if top {
+				points = append(points, Point{x + dx, y - 1})
+			}
+			if bottom {
+				points = append(points, Point{x + dx, y + 1})
+			}
+		}
+		if top {
+			points = append(points, Point{x, y - 1})
+		}
+		if bottom {
+			points = append(points, Point{x, y + 1})
+		}
+	} else {
+		next := g.Walkable(x, y+dy)
+		left := g.Walkable(x-1, y)
+		right := g.Walkable(x+1, y)
+		if next {
+			points = append(points, Point{x, y + dy})
+			if left {
+				points = append(points, Point{x - 1, y + dy})
+			}
+			if right {
+				points = append(points, Point{x + 1, y + dy})
+			}
+		}
+		if left {
+			points = append(points, Point{x - 1, y})
+		}
+		if right {
+			points = append(points, Point{x + 1, y})
+		}
+	}
+	return points
+}
+
+// moves from (px, py) through (x, y) until a jump point, the goal, a wall
+// or the end of the budget
+func (s *search) jump(x, y, px, py int) (Point, bool) {
+	g := s.grid
+	dx, dy := x-px, y-py
+	for {
+		if !s.step() || !g.Walkable(x, y) {
+			return Point{}, false
+		}
+		if x == s.to.X && y == s.to.Y {
+			return Point{x, y}, true
+		}
+
+		if dx != 0 && dy != 0 {
+			if _, ok := s.jump(x+dx, y, x, y); ok {
+				return Point{x, y}, true
+			}
+			if _, ok := s.jump(x, y+dy, x, y); ok {
+				return Point{x, y}, true
+			}
+			if s.exceeded {
+				return Point{}, false
+			}
+		} else if dx != 0 {
+			if g.Walkable(x, y-1) && !g.Walkable(x-dx, y-1) ||
+				g.Walkable(x, y+1) && !g.Walkable(x-dx, y+1) {
+				return Point{x, y}, true
+			}
+		} else {
+			if g.Walkable(x-1, y) && !g.Walkable(x-1, y-dy) ||
+				g.Walkable(x+1, y) && !g.Walkable(x+1, y-dy) {
+				return Point{x, y}, true
+			}
+		}
+
+		// never across a blocked corner
+		if !g.Walkable(x+dx, y) || !g.Walkable(x, y+dy) {
+			return Point{}, false
+		}
+		x, y = x+dx, y+dy
+	}
+}
@@ -0,0 +1,121 @@
+// path search on grid maps and navigation meshes
+package pathfinding
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+type Point struct {
+	X, Y int
+}
+
+// a grid of walkable and blocked cells, modify it on one goroutine only and
+// not while searches run off-loop, search a Clone instead
+type Grid struct {
+	Width   int
+	Height  int
+	blocked []bool
+	version uint64
+}
+
+func NewGrid(width, height int) *Grid {
+	g := new(Grid)
+	g.Width = width
+	g.Height = height
+	g.blocked = make([]bool, width*height)
+	return g
+}
+
+// map files are text, a line per row from y 0, '.' is walkable and '#' is
+// blocked, the lines starting with // are skipped
+func LoadGrid(filename string) (*Grid, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return ReadGrid(f)
+}
+
+func ReadGrid(r io.Reader) (*Grid, error) {
+	var rows []string
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if line == "" || strings.HasPrefix(line, "//") {
+			continue
+		}
+		if len(rows) > 0 && len(line) != len(rows[0]) {
+			return nil, fmt.Errorf("row %v: width %v, %v expected", len(rows), len(line), len(rows[0]))
+		}
+		rows = append(rows, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 {
+		return nil, fmt.Errorf("empty map")
+	}
+
+	g := NewGrid(len(rows[0]), len(rows))
+	for y, row := range rows {
+		for x, c := range []byte(row) {
+			switch c {
+			case '.':
+			case '#':
+				g.blocked[y*g.Width+x] = true
+			default:
+				return nil, fmt.Errorf("row %v: invalid cell %q", y, c)
+			}
+		}
+	}
+	return g, nil
+}
+
+func (g *Grid) Inside(x, y int) bool {
+	return x >= 0 && x < g.Width && y >= 0 && y < g.Height
+}
+
+// false outside the grid
+func (g *Grid) Walkable(x, y int) bool {
+	return g.Inside(x, y) && !g.blocked[y*g.Width+x]
+}
+
+func (g *Grid) SetBlocked(x, y int, blocked bool) {
+	if !g.Inside(x, y) {
+		return
+	}
+	if g.blocked[y*g.Width+x] != blocked {
+		g.blocked[y*g.Width+x] = blocked
+		// the cached paths are stale
+		g.version++
+	}
+}
+
+func (g *Grid) Clone() *Grid {
+	c := new(Grid)
+	*c = *g
+	c.blocked = append([]bool(nil), g.blocked...)
+	return c
+}
+
+func (g *Grid) String() string {
+	var b strings.Builder
+	for y := 0; y < g.Height; y++ {
+		for x := 0; x < g.Width; x++ {
+			if g.blocked[y*g.Width+x] {
+				b.WriteByte('#')
+			} else {
+				b.WriteByte('.')
+			}
+		}
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
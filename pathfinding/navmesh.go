@@ -0,0 +1,362 @@
+package pathfinding
+
+import (
+	"bufio"
+	"container/heap"
+	"fmt"
+	"github.com/name5566/leaf/module"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+)
+
+type Vec2 struct {
+	X, Y float64
+}
+
+func (v Vec2) sub(o Vec2) Vec2 {
+	return Vec2{v.X - o.X, v.Y - o.Y}
+}
+
+func (v Vec2) dist(o Vec2) float64 {
+	return math.Hypot(v.X-o.X, v.Y-o.Y)
+}
+
+// twice the signed area of the triangle abc, positive if counterclockwise
+func cross(a, b, c Vec2) float64 {
+	ab, ac := b.sub(a), c.sub(a)
+	return ab.X*ac.Y - ab.Y*ac.X
+}
+
+type portal struct {
+	to          int // the polygon on the other side
+	left, right Vec2
+}
+
+type polygon struct {
+	vertices []Vec2 // counterclockwise
+	portals  []portal
+}
+
+// a navigation mesh of convex polygons, two polygons sharing an edge are
+// connected, the mesh is not modified once built so that it may be searched
+// by several goroutines at once
+type NavMesh struct {
+	// the max polygons examined by a search on the module goroutine, 0 for
+	// no limit, ErrBudget is returned beyond
+	Budget int
+	// the budget of FindAsync, 0 for no limit
+	AsyncBudget int
+
+	polygons []*polygon
+}
+
+// polygons are the indices of their vertices, clockwise or counterclockwise
+func NewNavMesh(vertices []Vec2, polygons [][]int) (*NavMesh, error) {
+	m := new(NavMesh)
+	type edge struct {
+		a, b int
+	}
+	edges := make(map[edge]int)
+	for i, indices := range polygons {
+		if len(indices) < 3 {
+			return nil, fmt.Errorf("polygon %v: %v vertices, 3 at least", i, len(indices))
+		}
+		for _, v := range indices {
+			if v < 0 || v >= len(vertices) {
+				return nil, fmt.Errorf("polygon %v: invalid vertex %v", i, v)
+			}
+		}
+
+		// counterclockwise
+		var area float64
+		for j := range indices {
+			a, b := vertices[indices[j]], vertices[indices[(j+1)%len(indices)]]
+			area += a.X*b.Y - b.X*a.Y
+		}
+		if area == 0 {
+			return nil, fmt.Errorf("polygon %v: empty", i)
+		}
+		if area < 0 {
+			reversed := make([]int, len(indices))
+			for j, v := range indices {
+				reversed[len(indices)-1-j] = v
+			}
+			indices = reversed
+		}
+
+		p := new(polygon)
+		for j := range indices {
+			a := vertices[indices[j]]
+			b := vertices[indices[(j+1)%len(indices)]]
+			c := vertices[indices[(j+2)%len(indices)]]
+			if cross(a, b, c) < 0 {
+				return nil, fmt.Errorf("polygon %v: not convex", i)
+			}
+			p.vertices = append(p.vertices, a)
+		}
+
+		// the neighbors run the shared edges the other way round
+		for j := range indices {
+			a, b := indices[j], indices[(j+1)%len(indices)]
+			if other, ok := edges[edge{a, b}]; ok {
+				return nil, fmt.Errorf("polygons %v and %v overlap", other, i)
+			}
+			edges[edge{a, b}] = i
+			other, ok := edges[edge{b, a}]
+			if !ok {
+				continue
+			}
+			// seen from p, the edge a b has b on the left
+			p.portals = append(p.portals, portal{other, vertices[b], vertices[a]})
+			o := m.polygons[other]
+			o.portals = append(o.portals, portal{i, vertices[a], vertices[b]})
+		}
+		m.polygons = append(m.polygons, p)
+	}
+	return m, nil
+}
+
+// mesh files are text, a line per vertex "v x y" then a line per polygon
+// "p i j k ..." with the indices of its vertices from 0, the lines starting
+// with // are skipped
+func LoadNavMesh(filename string) (*NavMesh, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return ReadNavMesh(f)
+}
+
+func ReadNavMesh(r io.Reader) (*NavMesh, error) {
+	var vertices []Vec2
+	var polygons [][]int
+	scanner := bufio.NewScanner(r)
+	line := 0
+	for scanner.Scan() {
+		line++
+		words := strings.Fields(scanner.Text())
+		if len(words) == 0 || strings.HasPrefix(words[0], "//") {
+			continue
+		}
+
+		switch words[0] {
+		case "v":
+			if len(words) != 3 {
+				return nil, fmt.Errorf("line %v: usage: v <x> <y>", line)
+			}
+			x, err1 := strconv.ParseFloat(words[1], 64)
+			y, err2 := strconv.ParseFloat(words[2], 64)
+			if err1 != nil || err2 != nil {
+				return nil, fmt.Errorf("line %v: invalid vertex", line)
+			}
+			vertices = append(vertices, Vec2{x, y})
+		case "p":
+			var indices []int
+			for _, w := range words[1:] {
+				i, err := strconv.Atoi(w)
+				if err != nil {
+					return nil, fmt.Errorf("line %v: invalid index %v", line, w)
+				}
+				indices = append(indices, i)
+			}
+			polygons = append(polygons, indices)
+		default:
+			return nil, fmt.Errorf("line %v: unknown statement %v", line, words[0])
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(polygons) == 0 {
+		return nil, fmt.Errorf("empty mesh")
+	}
+
+	return NewNavMesh(vertices, polygons)
+}
+
+func (m *NavMesh) Len() int {
+	return len(m.polygons)
+}
+
+// the polygon containing p, the edges included, -1 if none
+func (m *NavMesh) Locate(p Vec2) int {
+	const epsilon = 1e-9
+	for i, poly := range m.polygons {
+		inside := true
+		for j, a := range poly.vertices {
+			b := poly.vertices[(j+1)%len(poly.vertices)]
+			if cross(a, b, p) < -epsilon {
+				inside = false
+				break
+			}
+		}
+		if inside {
+			return i
+		}
+	}
+	return -1
+}
+
+// the shortest path from from to to through the polygons found, both
+// included, ErrNoPath if either is off the mesh
+func (m *NavMesh) Find(from, to Vec2) ([]Vec2, error) {
+	return m.find(from, to, m.Budget)
+}
+
+// runs the search by s.Go, cb is called on the module goroutine
+func (m *NavMesh) FindAsync(s *module.Skeleton, from, to Vec2, cb func(path []Vec2, err error)) {
+	var path []Vec2
+	var err error
+	s.Go(func() {
+		path, err = m.find(from, to, m.AsyncBudget)
+	}, func() {
+		cb(path, err)
+	})
+}
+
+type navNode struct {
+	poly   int
+	pos    Vec2 // where the polygon is entered
+	g      float64
+	f      float64
+	parent *navNode
+	portal portal // the portal entered by
+	index  int
+	closed bool
+}
+
+type navHeap []*navNode
+
+func (h navHeap) Len() int           { return len(h) }
+func (h navHeap) Less(i, j int) bool { return h[i].f < h[j].f }
+func (h navHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *navHeap) Push(x interface{}) {
+	n := x.(*navNode)
+	n.index = len(*h)
+	*h = append(*h, n)
+}
+
+func (h *navHeap) Pop() interface{} {
+	old := *h
+	n := old[len(old)-1]
+	old[len(old)-1] = nil
+	*h = old[:len(old)-1]
+	n.index = -1
+	return n
+}
+
+// A* over the polygons, the polygons are entered by the middle of the
+// portals, then the path is pulled tight through the portals
+func (m *NavMesh) find(from, to Vec2, budget int) ([]Vec2, error) {
+	start, goal := m.Locate(from), m.Locate(to)
+	if start < 0 || goal < 0 {
+		return nil, ErrNoPath
+	}
+
+	nodes := make(map[int]*navNode)
+	var open navHeap
+	n := &navNode{poly: start, pos: from, f: from.dist(to)}
+	nodes[start] = n
+	heap.Push(&open, n)
+	steps := 0
+	for open.Len() > 0 {
+		n := heap.Pop(&open).(*navNode)
+		n.closed = true
+		if n.poly == goal {
+			return pull(from, to, n), nil
+		}
+		steps++
+		if budget > 0 && steps > budget {
+			return nil, ErrBudget
+		}
+
+		for _, p := range m.polygons[n.poly].portals {
+			o := nodes[p.to]
+			if o != nil && o.closed {
+				continue
+			}
+			pos := Vec2{(p.left.X + p.right.X) / 2, (p.left.Y + p.right.Y) / 2}
+			cost := n.g + n.pos.dist(pos)
+			if o == nil {
+				o = &navNode{poly: p.to, index: -1}
+				nodes[p.to] = o
+			} else if cost >= o.g {
+				continue
+			}
+			o.pos = pos
+			o.g = cost
+			o.f = cost + pos.dist(to)
+			o.parent = n
+			o.portal = p
+			if o.index >= 0 {
+				heap.Fix(&open, o.index)
+			} else {
+				heap.Push(&open, o)
+			}
+		}
+	}
+	return nil, ErrNoPath
+}
+
+// the funnel algorithm over the portals crossed to reach n
+func pull(from, to Vec2, n *navNode) []Vec2 {
+	var portals []portal
+	for ; n.parent != nil; n = n.parent {
+		portals = append(portals, n.portal)
+	}
+	for i, j := 0, len(portals)-1; i < j; i, j = i+1, j-1 {
+		portals[i], portals[j] = portals[j], portals[i]
+	}
+	portals = append(portals, portal{left: to, right: to})
+
+	path := []Vec2{from}
+	add := func(v Vec2) {
+		if path[len(path)-1] != v {
+			path = append(path, v)
+		}
+	}
+	apex, left, right := from, from, from
+	apexIndex, leftIndex, rightIndex := -1, -1, -1
+	for i := 0; i < len(portals); i++ {
+		p := portals[i]
+
+		// narrow the right side
+		if cross(apex, right, p.right) >= 0 {
+			if apex == right || cross(apex, left, p.right) < 0 {
+				right, rightIndex = p.right, i
+			} else {
+				// the right crosses the left, the left is a corner
+				add(left)
+				apex, apexIndex = left, leftIndex
+				right, rightIndex = apex, apexIndex
+				i = apexIndex
+				continue
+			}
+		}
+
+		// narrow the left side
+		if cross(apex, left, p.left) <= 0 {
+			if apex == left || cross(apex, right, p.left) > 0 {
+				left, leftIndex = p.left, i
+			} else {
+				add(right)
+				apex, apexIndex = right, rightIndex
+				left, leftIndex = apex, apexIndex
+				i = apexIndex
+				continue
+			}
+		}
+	}
+	add(to)
+	return path
+}
@@ -0,0 +1,54 @@
+package pathfinding
+
+// true if every cell crossed by the line between the centers of a and b is
+// walkable, a line through a corner needs both sides walkable
+func (g *Grid) LineOfSight(a, b Point) bool {
+	x, y := a.X, a.Y
+	dx, dy := b.X-a.X, b.Y-a.Y
+	sx, sy := sign(dx), sign(dy)
+	dx, dy = dx*sx, dy*sy
+
+	if !g.Walkable(x, y) {
+		return false
+	}
+	e := dx - dy
+	for n := dx + dy; n > 0; n-- {
+		switch {
+		case e > 0:
+			x += sx
+			e -= 2 * dy
+		case e < 0:
+			y += sy
+			e += 2 * dx
+		default:
+			if !g.Walkable(x+sx, y) || !g.Walkable(x, y+sy) {
+				return false
+			}
+			x += sx
+			y += sy
+			e += 2 * (dx - dy)
+			n--
+		}
+		if !g.Walkable(x, y) {
+			return false
+		}
+	}
+	return true
+}
+
+// the waypoints of path, each in line of sight of the next
+func Smooth(g *Grid, path []Point) []Point {
+	if len(path) <= 2 {
+		return path
+	}
+
+	waypoints := []Point{path[0]}
+	anchor := 0
+	for i := 2; i < len(path); i++ {
+		if !g.LineOfSight(path[anchor], path[i]) {
+			anchor = i - 1
+			waypoints = append(waypoints, path[anchor])
+		}
+	}
+	return append(waypoints, path[len(path)-1])
+}
@@ -0,0 +1,259 @@
+package protodoc_test
+
+import (
+	"fmt"
+	"github.com/golang/protobuf/proto"
+	"github.com/name5566/leaf/chanrpc"
+	"github.com/name5566/leaf/network/json"
+	"github.com/name5566/leaf/network/protobuf"
+	"github.com/name5566/leaf/protodoc"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/typepb"
+	"image"
+	"os"
+	"strings"
+)
+
+type Hello struct {
+	Name string
+}
+
+type Move struct {
+	X, Y  float32
+	Speed float32 `json:"speed,omitempty"`
+}
+
+func Example() {
+	game := chanrpc.NewServer(10)
+
+	p := json.NewProcessor()
+	p.Register(&Hello{})
+	p.Register(&Move{})
+	p.SetRouter(&Move{}, game)
+
+	m := protodoc.FromJSON("Protocol", p, protodoc.Modules{game: "game"})
+	m.Markdown(os.Stdout)
+
+	// Output:
+	// # Protocol
+	//
+	// Processor: json
+	//
+	// | ID | Message | Module |
+	// | --- | --- | --- |
+	// | Hello | [Hello](#hello) |  |
+	// | Move | [Move](#move) | game |
+	//
+	// ## Hello
+	//
+	// ID: Hello
+	//
+	// | Field | Type |
+	// | --- | --- |
+	// | Name | string |
+	//
+	// ## Move
+	//
+	// ID: Move, routed to game
+	//
+	// | Field | Type |
+	// | --- | --- |
+	// | X | number |
+	// | Y | number |
+	// | speed | number |
+}
+
+type Point struct {
+	X, Y float32
+}
+
+// image.Point is another Point
+type Line struct {
+	From Point
+	To   image.Point `json:"to"`
+}
+
+func ExampleManifest_JSONSchema() {
+	p := json.NewProcessor()
+	p.Register(&Line{})
+
+	m := protodoc.FromJSON("Protocol", p, nil)
+	schema, err := m.JSONSchema()
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	fmt.Println(string(schema))
+
+	// Output:
+	// {
+	// 	"$defs": {
+	// 		"Line": {
+	// 			"properties": {
+	// 				"From": {
+	// 					"$ref": "#/$defs/Point"
+	// 				},
+	// 				"to": {
+	// 					"$ref": "#/$defs/Point2"
+	// 				}
+	// 			},
+	// 			"type": "object"
+	// 		},
+	// 		"Point": {
+	// 			"properties": {
+	// 				"X": {
+	// 					"type": "number"
+	// 				},
+	// 				"Y": {
+	// 					"type": "number"
+	// 				}
+	// 			},
+	// 			"type": "object"
+	// 		},
+	// 		"Point2": {
+	// 			"properties": {
+	// 				"X": {
+	// 					"type": "integer"
+	// 				},
+	// 				"Y": {
+	// 					"type": "integer"
+	// 				}
+	// 			},
+	// 			"type": "object"
+	// 		}
+	// 	},
+	// 	"$schema": "https://json-schema.org/draft/2020-12/schema",
+	// 	"oneOf": [
+	// 		{
+	// 			"additionalProperties": false,
+	// 			"properties": {
+	// 				"Line": {
+	// 					"$ref": "#/$defs/Line"
+	// 				}
+	// 			},
+	// 			"required": [
+	// 				"Line"
+	// 			],
+	// 			"type": "object"
+	// 		}
+	// 	],
+	// 	"title": "Protocol"
+	// }
+}
+
+type Account struct {
+	ID    int64   `json:"id,string"`
+	Hash  [2]byte `json:"hash"`
+	Key   []byte  `json:"key"`
+	Flags []bool  `json:"flags,string"`
+}
+
+func ExampleFromJSON() {
+	p := json.NewProcessor()
+	p.Register(&Account{})
+
+	m := protodoc.FromJSON("Protocol", p, nil)
+	for _, f := range m.Messages[0].Fields {
+		fmt.Println(f.Name, f.Type)
+	}
+	schema, err := m.JSONSchema()
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	fmt.Println(string(schema[:strings.Index(string(schema), "\t\"$schema\"")]))
+
+	// Output:
+	// id string (integer)
+	// hash array of integer
+	// key string (base64)
+	// flags array of boolean
+	// {
+	// 	"$defs": {
+	// 		"Account": {
+	// 			"properties": {
+	// 				"flags": {
+	// 					"items": {
+	// 						"type": "boolean"
+	// 					},
+	// 					"type": "array"
+	// 				},
+	// 				"hash": {
+	// 					"items": {
+	// 						"type": "integer"
+	// 					},
+	// 					"type": "array"
+	// 				},
+	// 				"id": {
+	// 					"contentMediaType": "application/json",
+	// 					"contentSchema": {
+	// 						"type": "integer"
+	// 					},
+	// 					"type": "string"
+	// 				},
+	// 				"key": {
+	// 					"contentEncoding": "base64",
+	// 					"type": "string"
+	// 				}
+	// 			},
+	// 			"type": "object"
+	// 		}
+	// 	},
+}
+
+func ExampleFromProtobuf() {
+	game := chanrpc.NewServer(10)
+
+	p := protobuf.NewProcessor()
+	p.Register(&durationpb.Duration{})
+	p.SetRouter(&durationpb.Duration{}, game)
+
+	m := protodoc.FromProtobuf("Protocol", p, protodoc.Modules{game: "game"})
+	m.HTML(os.Stdout)
+
+	// the files of the messages follow their dependencies
+	p = protobuf.NewProcessor()
+	p.Register(&typepb.Type{})
+	b, err := protodoc.FromProtobuf("Types", p, nil).DescriptorSet()
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	set := new(descriptorpb.FileDescriptorSet)
+	if err := proto.Unmarshal(b, set); err != nil {
+		fmt.Println(err)
+		return
+	}
+	for _, f := range set.File {
+		fmt.Println(f.GetName())
+	}
+
+	// Output:
+	// <!DOCTYPE html>
+	// <html>
+	// <head>
+	// <meta charset="utf-8">
+	// <title>Protocol</title>
+	// </head>
+	// <body>
+	// <h1>Protocol</h1>
+	// <p>Processor: protobuf</p>
+	// <table>
+	// <tr><th>ID</th><th>Message</th><th>Module</th></tr>
+	// <tr><td>0</td><td><a href="#googleprotobufduration">google.protobuf.Duration</a></td><td>game</td></tr>
+	// </table>
+	//
+	// <h2 id="googleprotobufduration">google.protobuf.Duration</h2>
+	// <p>ID: 0, routed to game</p>
+	// <table>
+	// <tr><th>Field</th><th>Type</th><th>Number</th></tr>
+	// <tr><td>seconds</td><td>int64</td><td>1</td></tr>
+	// <tr><td>nanos</td><td>int32</td><td>2</td></tr>
+	// </table>
+	// </body>
+	// </html>
+	// google/protobuf/any.proto
+	// google/protobuf/source_context.proto
+	// google/protobuf/type.proto
+}
@@ -0,0 +1,212 @@
+package protodoc
+
+import (
+	"fmt"
+	"github.com/golang/protobuf/proto"
+	"github.com/name5566/leaf/chanrpc"
+	"github.com/name5566/leaf/network/json"
+	"github.com/name5566/leaf/network/protobuf"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"reflect"
+	"strings"
+)
+
+// the messages of a processor, in id order
+type Manifest struct {
+	Title     string
+	Processor string // json or protobuf
+	Messages  []*Message
+}
+
+type Message struct {
+	ID     string
+	Name   string
+	Module string // the routing module, "?" if unnamed, empty if not routed
+	Fields []*Field
+
+	t reflect.Type
+	d protoreflect.MessageDescriptor
+}
+
+type Field struct {
+	Name   string
+	Type   string
+	Number int // protobuf only
+}
+
+// names the modules routing the messages, e.g. {game.ChanRPC: "game"}
+type Modules map[*chanrpc.Server]string
+
+func (modules Modules) name(s *chanrpc.Server) string {
+	if s == nil {
+		return ""
+	}
+	if name, ok := modules[s]; ok {
+		return name
+	}
+	return "?"
+}
+
+func FromJSON(title string, p *json.Processor, modules Modules) *Manifest {
+	m := &Manifest{Title: title, Processor: "json"}
+	p.Range(func(id string, t reflect.Type) {
+		msg := &Message{
+			ID:     id,
+			Name:   id,
+			Module: modules.name(p.MsgRouter(id)),
+			t:      t.Elem(),
+		}
+		for _, f := range jsonFields(msg.t) {
+			t := jsonTypeName(f.t)
+			if f.quoted {
+				t = "string (" + t + ")"
+			}
+			msg.Fields = append(msg.Fields, &Field{
+				Name: f.name,
+				Type: t,
+			})
+		}
+		m.Messages = append(m.Messages, msg)
+	})
+	return m
+}
+
+func FromProtobuf(title string, p *protobuf.Processor, modules Modules) *Manifest {
+	m := &Manifest{Title: title, Processor: "protobuf"}
+	p.Range(func(id uint16, t reflect.Type) {
+		d := proto.MessageV2(reflect.New(t.Elem()).Interface()).ProtoReflect().Descriptor()
+		msg := &Message{
+			ID:     fmt.Sprint(id),
+			Name:   string(d.FullName()),
+			Module: modules.name(p.MsgRouter(id)),
+			t:      t.Elem(),
+			d:      d,
+		}
+		fields := d.Fields()
+		for i := 0; i < fields.Len(); i++ {
+			f := fields.Get(i)
+			msg.Fields = append(msg.Fields, &Field{
+				Name:   string(f.Name()),
+				Type:   protoTypeName(f),
+				Number: int(f.Number()),
+			})
+		}
+		m.Messages = append(m.Messages, msg)
+	})
+	return m
+}
+
+type jsonField struct {
+	name   string
+	t      reflect.Type
+	quoted bool // the value is marshaled into a JSON string
+}
+
+// the fields encoding/json marshals
+func jsonFields(t reflect.Type) []jsonField {
+	var fields []jsonField
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		opts := strings.Split(tag, ",")
+		name := opts[0]
+
+		ft := f.Type
+		if ft.Kind() == reflect.Ptr {
+			ft = ft.Elem()
+		}
+		if f.Anonymous && name == "" && ft.Kind() == reflect.Struct {
+			fields = append(fields, jsonFields(ft)...)
+			continue
+		}
+		if f.PkgPath != "" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		fields = append(fields, jsonField{name, f.Type, jsonQuoted(opts[1:], f.Type)})
+	}
+	return fields
+}
+
+// the string option only applies to the scalars
+func jsonQuoted(opts []string, t reflect.Type) bool {
+	var quoted bool
+	for _, opt := range opts {
+		if opt == "string" {
+			quoted = true
+		}
+	}
+	if !quoted {
+		return false
+	}
+
+	if t.Name() == "" && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.Bool,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+		reflect.Float32, reflect.Float64,
+		reflect.String:
+		return true
+	default:
+		return false
+	}
+}
+
+func jsonTypeName(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.Ptr:
+		return jsonTypeName(t.Elem())
+	case reflect.Bool:
+		return "boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "integer"
+	case reflect.Float32, reflect.Float64:
+		return "number"
+	case reflect.String:
+		return "string"
+	case reflect.Slice, reflect.Array:
+		// the byte arrays are number arrays
+		if t.Kind() == reflect.Slice && t.Elem().Kind() == reflect.Uint8 {
+			return "string (base64)"
+		}
+		return "array of " + jsonTypeName(t.Elem())
+	case reflect.Map:
+		return "map of " + jsonTypeName(t.Elem())
+	case reflect.Struct:
+		if t.Name() != "" {
+			return t.Name()
+		}
+		return "object"
+	default:
+		return "any"
+	}
+}
+
+func protoTypeName(f protoreflect.FieldDescriptor) string {
+	if f.IsMap() {
+		return fmt.Sprintf("map<%v, %v>", protoTypeName(f.MapKey()), protoTypeName(f.MapValue()))
+	}
+
+	var name string
+	switch f.Kind() {
+	case protoreflect.MessageKind, protoreflect.GroupKind:
+		name = string(f.Message().FullName())
+	case protoreflect.EnumKind:
+		name = string(f.Enum().FullName())
+	default:
+		name = f.Kind().String()
+	}
+	if f.IsList() {
+		return "repeated " + name
+	}
+	return name
+}
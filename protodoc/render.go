@@ -0,0 +1,79 @@
+package protodoc
+
+import (
+	htmltemplate "html/template"
+	"io"
+	"strings"
+	"text/template"
+)
+
+var markdownTemplate = template.Must(template.New("markdown").Funcs(template.FuncMap{
+	"anchor": anchor,
+	"cell":   markdownCell,
+}).Parse(`# {{.Title}}
+
+Processor: {{.Processor}}
+
+| ID | Message | Module |
+| --- | --- | --- |
+{{range .Messages}}| {{cell .ID}} | [{{cell .Name}}](#{{anchor .Name}}) | {{cell .Module}} |
+{{end}}
+{{- range .Messages}}
+## {{.Name}}
+
+ID: {{.ID}}{{if .Module}}, routed to {{.Module}}{{end}}
+{{if .Fields}}
+| Field | Type |{{if $.Protobuf}} Number |{{end}}
+| --- | --- |{{if $.Protobuf}} --- |{{end}}
+{{range .Fields}}| {{cell .Name}} | {{cell .Type}} |{{if $.Protobuf}} {{.Number}} |{{end}}
+{{end}}{{else}}
+No fields.
+{{end}}{{end}}`))
+
+var htmlTemplate = htmltemplate.Must(htmltemplate.New("html").Funcs(htmltemplate.FuncMap{
+	"anchor": anchor,
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+<p>Processor: {{.Processor}}</p>
+<table>
+<tr><th>ID</th><th>Message</th><th>Module</th></tr>
+{{range .Messages}}<tr><td>{{.ID}}</td><td><a href="#{{anchor .Name}}">{{.Name}}</a></td><td>{{.Module}}</td></tr>
+{{end}}</table>
+{{range .Messages}}
+<h2 id="{{anchor .Name}}">{{.Name}}</h2>
+<p>ID: {{.ID}}{{if .Module}}, routed to {{.Module}}{{end}}</p>
+{{if .Fields}}<table>
+<tr><th>Field</th><th>Type</th>{{if $.Protobuf}}<th>Number</th>{{end}}</tr>
+{{range .Fields}}<tr><td>{{.Name}}</td><td>{{.Type}}</td>{{if $.Protobuf}}<td>{{.Number}}</td>{{end}}</tr>
+{{end}}</table>
+{{else}}<p>No fields.</p>
+{{end}}{{end}}</body>
+</html>
+`))
+
+type view struct {
+	*Manifest
+	Protobuf bool
+}
+
+func (m *Manifest) Markdown(w io.Writer) error {
+	return markdownTemplate.Execute(w, view{m, m.Processor == "protobuf"})
+}
+
+func (m *Manifest) HTML(w io.Writer) error {
+	return htmlTemplate.Execute(w, view{m, m.Processor == "protobuf"})
+}
+
+func anchor(name string) string {
+	return strings.ToLower(strings.Replace(name, ".", "", -1))
+}
+
+func markdownCell(s string) string {
+	return strings.Replace(s, "|", "\\|", -1)
+}
@@ -0,0 +1,150 @@
+package protodoc
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/golang/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"reflect"
+)
+
+const schemaVersion = "https://json-schema.org/draft/2020-12/schema"
+
+// the schema of the json messages as sent on the wire, {"Hello": {...}}
+func (m *Manifest) JSONSchema() ([]byte, error) {
+	if m.Processor != "json" {
+		return nil, errors.New("json schema of a json processor only")
+	}
+
+	defs := &schemaDefs{
+		names:   make(map[reflect.Type]string),
+		schemas: make(map[string]interface{}),
+	}
+	var oneOf []interface{}
+	for _, msg := range m.Messages {
+		oneOf = append(oneOf, map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				msg.ID: jsonSchema(msg.t, defs),
+			},
+			"required":             []string{msg.ID},
+			"additionalProperties": false,
+		})
+	}
+
+	schema := map[string]interface{}{
+		"$schema": schemaVersion,
+		"title":   m.Title,
+		"oneOf":   oneOf,
+		"$defs":   defs.schemas,
+	}
+	return json.MarshalIndent(schema, "", "\t")
+}
+
+// the named structs, the structs of the same name from other packages get
+// a suffix, e.g. Point and Point2
+type schemaDefs struct {
+	names   map[reflect.Type]string
+	schemas map[string]interface{}
+}
+
+// the named structs go to defs
+func jsonSchema(t reflect.Type, defs *schemaDefs) interface{} {
+	switch t.Kind() {
+	case reflect.Ptr:
+		return jsonSchema(t.Elem(), defs)
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		// the byte arrays are number arrays
+		if t.Kind() == reflect.Slice && t.Elem().Kind() == reflect.Uint8 {
+			return map[string]interface{}{"type": "string", "contentEncoding": "base64"}
+		}
+		return map[string]interface{}{"type": "array", "items": jsonSchema(t.Elem(), defs)}
+	case reflect.Map:
+		return map[string]interface{}{
+			"type":                 "object",
+			"additionalProperties": jsonSchema(t.Elem(), defs),
+		}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return structSchema(t, defs)
+		}
+		name, ok := defs.names[t]
+		if !ok {
+			name = t.Name()
+			for i := 2; ; i++ {
+				if _, ok := defs.schemas[name]; !ok {
+					break
+				}
+				name = fmt.Sprint(t.Name(), i)
+			}
+			defs.names[t] = name
+			// placeholder for the recursive types
+			defs.schemas[name] = nil
+			defs.schemas[name] = structSchema(t, defs)
+		}
+		return map[string]interface{}{"$ref": "#/$defs/" + name}
+	default:
+		return map[string]interface{}{}
+	}
+}
+
+func structSchema(t reflect.Type, defs *schemaDefs) interface{} {
+	properties := make(map[string]interface{})
+	for _, f := range jsonFields(t) {
+		if f.quoted {
+			properties[f.name] = map[string]interface{}{
+				"type":             "string",
+				"contentMediaType": "application/json",
+				"contentSchema":    jsonSchema(f.t, defs),
+			}
+			continue
+		}
+		properties[f.name] = jsonSchema(f.t, defs)
+	}
+	return map[string]interface{}{
+		"type":       "object",
+		"properties": properties,
+	}
+}
+
+// a serialized google.protobuf.FileDescriptorSet of the files defining the
+// messages and their dependencies
+func (m *Manifest) DescriptorSet() ([]byte, error) {
+	if m.Processor != "protobuf" {
+		return nil, errors.New("descriptor set of a protobuf processor only")
+	}
+
+	set := new(descriptorpb.FileDescriptorSet)
+	added := make(map[string]bool)
+	var add func(fd protoreflect.FileDescriptor)
+	add = func(fd protoreflect.FileDescriptor) {
+		if added[fd.Path()] {
+			return
+		}
+		added[fd.Path()] = true
+
+		// dependencies first
+		imports := fd.Imports()
+		for i := 0; i < imports.Len(); i++ {
+			add(imports.Get(i).FileDescriptor)
+		}
+		set.File = append(set.File, protodesc.ToFileDescriptorProto(fd))
+	}
+	for _, msg := range m.Messages {
+		add(msg.d.ParentFile())
+	}
+
+	return proto.Marshal(set)
+}
@@ -0,0 +1,109 @@
+package reset_test
+
+import (
+	"errors"
+	"fmt"
+	"github.com/name5566/leaf/log"
+	"github.com/name5566/leaf/reset"
+	"github.com/name5566/leaf/timer"
+	"time"
+)
+
+func init() {
+	// keep the output of the examples clean
+	logger, _ := log.New("fatal", "", 0)
+	log.Export(logger)
+}
+
+func ExamplePeriod() {
+	loc := time.FixedZone("UTC+8", 8*3600)
+	now := time.Date(2000, 1, 5, 3, 0, 0, 0, loc) // Wednesday
+
+	daily := reset.Daily(5, 0, loc)
+	fmt.Println(daily.Last(now))
+	fmt.Println(daily.Next(now))
+
+	weekly := reset.Weekly(time.Monday, 0, 0, loc)
+	fmt.Println(weekly.Last(now))
+	fmt.Println(weekly.Next(now))
+
+	// Output:
+	// 2000-01-04 05:00:00 +0800 UTC+8
+	// 2000-01-05 05:00:00 +0800 UTC+8
+	// 2000-01-03 00:00:00 +0800 UTC+8
+	// 2000-01-10 00:00:00 +0800 UTC+8
+}
+
+func ExampleService() {
+	now := time.Date(2000, 1, 5, 3, 0, 0, 0, time.UTC)
+	store := new(reset.MemStore)
+	// the server was down at the last reset
+	store.Save("daily", time.Date(2000, 1, 3, 5, 0, 0, 0, time.UTC))
+
+	s := reset.NewService(store)
+	s.Now = func() time.Time { return now }
+	s.Register("daily", reset.Daily(5, 0, time.UTC), func(at time.Time) {
+		fmt.Println("daily reset", at)
+	})
+	s.Register("quests", reset.Daily(5, 0, time.UTC), nil)
+
+	d := timer.NewDispatcher(10)
+	s.Start(d)
+	defer s.Stop()
+
+	// at login
+	at, ok := s.Pending("quests", time.Date(2000, 1, 4, 4, 0, 0, 0, time.UTC))
+	fmt.Println(at, ok)
+	_, ok = s.Pending("quests", at)
+	fmt.Println(ok)
+
+	// Output:
+	// daily reset 2000-01-04 05:00:00 +0000 UTC
+	// 2000-01-04 05:00:00 +0000 UTC true
+	// false
+}
+
+// a store failing its first loads
+type flakyStore struct {
+	reset.MemStore
+	fails int
+}
+
+func (s *flakyStore) Load(key string) (time.Time, error) {
+	if s.fails > 0 {
+		s.fails--
+		return time.Time{}, errors.New("store unavailable")
+	}
+	return s.MemStore.Load(key)
+}
+
+func ExampleService_loadError() {
+	now := time.Date(2000, 1, 5, 3, 0, 0, 0, time.UTC)
+	store := &flakyStore{fails: 2}
+	store.Save("daily", time.Date(2000, 1, 3, 5, 0, 0, 0, time.UTC))
+
+	s := reset.NewService(store)
+	s.Now = func() time.Time { return now }
+	s.LoadRetry = time.Millisecond
+	s.Register("daily", reset.Daily(5, 0, time.UTC), func(at time.Time) {
+		fmt.Println("daily reset", at)
+	})
+
+	d := timer.NewDispatcher(10)
+	s.Start(d)
+	defer s.Stop()
+
+	// the stored reset is kept until a load succeeds
+	for i := 0; i < 2; i++ {
+		last, _ := store.MemStore.Load("daily")
+		fmt.Println(last)
+		(<-d.ChanTimer).Cb()
+	}
+	fmt.Println(s.Last("daily"))
+
+	// Output:
+	// 2000-01-03 05:00:00 +0000 UTC
+	// 2000-01-03 05:00:00 +0000 UTC
+	// daily reset 2000-01-04 05:00:00 +0000 UTC
+	// 2000-01-04 05:00:00 +0000 UTC
+}
@@ -0,0 +1,94 @@
+package reset
+
+import (
+	"fmt"
+	"github.com/name5566/leaf/log"
+	"time"
+)
+
+// a daily or weekly reset time, e.g. every day at 05:00 or every Monday at
+// 00:00, in the time zone of the region
+type Period struct {
+	Weekly   bool
+	Weekday  time.Weekday
+	Hour     int
+	Minute   int
+	Location *time.Location // time.Local if nil
+}
+
+// hour in [0, 23], minute in [0, 59]
+func Daily(hour, minute int, loc *time.Location) *Period {
+	p := &Period{Hour: hour, Minute: minute, Location: loc}
+	if err := p.check(); err != nil {
+		log.Fatal("%v", err)
+	}
+	return p
+}
+
+// hour in [0, 23], minute in [0, 59]
+func Weekly(weekday time.Weekday, hour, minute int, loc *time.Location) *Period {
+	p := &Period{Weekly: true, Weekday: weekday, Hour: hour, Minute: minute, Location: loc}
+	if err := p.check(); err != nil {
+		log.Fatal("%v", err)
+	}
+	return p
+}
+
+// time.Date would normalize the out of range values to another time
+func (p *Period) check() error {
+	if p.Hour < 0 || p.Hour > 23 {
+		return fmt.Errorf("invalid hour %v", p.Hour)
+	}
+	if p.Minute < 0 || p.Minute > 59 {
+		return fmt.Errorf("invalid minute %v", p.Minute)
+	}
+	if p.Weekly && (p.Weekday < time.Sunday || p.Weekday > time.Saturday) {
+		return fmt.Errorf("invalid weekday %v", p.Weekday)
+	}
+	return nil
+}
+
+func (p *Period) location() *time.Location {
+	if p.Location == nil {
+		return time.Local
+	}
+	return p.Location
+}
+
+func (p *Period) days() int {
+	if p.Weekly {
+		return 7
+	}
+	return 1
+}
+
+// the reset time days after the day of t, the wall clock is kept across
+// daylight saving changes
+func (p *Period) at(t time.Time, days int) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day()+days, p.Hour, p.Minute, 0, 0, p.location())
+}
+
+// the latest reset time not after now
+func (p *Period) Last(now time.Time) time.Time {
+	t := now.In(p.location())
+	days := 0
+	if p.Weekly {
+		days = -((int(t.Weekday()) - int(p.Weekday) + 7) % 7)
+	}
+	last := p.at(t, days)
+	if last.After(now) {
+		last = p.at(t, days-p.days())
+	}
+	return last
+}
+
+// the earliest reset time after now
+func (p *Period) Next(now time.Time) time.Time {
+	last := p.Last(now)
+	return p.at(last.In(p.location()), p.days())
+}
+
+// true if a reset time passed after last and not after now
+func (p *Period) Due(last time.Time, now time.Time) bool {
+	return last.Before(p.Last(now))
+}
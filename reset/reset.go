@@ -0,0 +1,202 @@
+package reset
+
+import (
+	"github.com/name5566/leaf/log"
+	"github.com/name5566/leaf/timer"
+	"sort"
+	"time"
+)
+
+// the default LoadRetry
+const loadRetry = 10 * time.Second
+
+// *timer.Dispatcher and *module.Skeleton
+type Scheduler interface {
+	AfterFunc(d time.Duration, cb func()) *timer.Timer
+}
+
+type reset struct {
+	key    string
+	period *Period
+	f      func(at time.Time)
+	last   time.Time
+	t      *timer.Timer
+}
+
+// runs the global resets at the reset times and on Start the resets missed
+// while the server was down, the last reset of each key is kept in Store
+// one service per goroutine (goroutine not safe)
+type Service struct {
+	Store Store
+	// time.Now if nil, overridden in tests
+	Now func() time.Time
+	// the interval of the retries of a failed load, 10s if not positive
+	LoadRetry time.Duration
+
+	resets  map[string]*reset
+	sched   Scheduler
+	started bool
+}
+
+func NewService(store Store) *Service {
+	s := new(Service)
+	s.Store = store
+	s.resets = make(map[string]*reset)
+	return s
+}
+
+func (s *Service) now() time.Time {
+	if s.Now != nil {
+		return s.Now()
+	}
+	return time.Now()
+}
+
+// f runs once per reset time with the reset time, several missed resets
+// run f once with the latest one, f may be nil for the resets applied
+// lazily with Pending only
+// you must call the function before calling Start
+func (s *Service) Register(key string, p *Period, f func(at time.Time)) {
+	if s.started {
+		log.Fatal("reset %v is registered after Start", key)
+	}
+	if _, ok := s.resets[key]; ok {
+		log.Fatal("reset %v is already registered", key)
+	}
+	if err := p.check(); err != nil {
+		log.Fatal("reset %v: %v", key, err)
+	}
+	s.resets[key] = &reset{key: key, period: p, f: f}
+}
+
+// runs the missed resets then schedules the next ones on sched, a key
+// with no last reset in Store is not run until its next reset time
+func (s *Service) Start(sched Scheduler) {
+	if s.started {
+		return
+	}
+	s.started = true
+	s.sched = sched
+	if s.LoadRetry <= 0 {
+		s.LoadRetry = loadRetry
+	}
+
+	// in a stable order
+	var keys []string
+	for key := range s.resets {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		r := s.resets[key]
+		if r.f == nil {
+			continue
+		}
+		s.start(r)
+	}
+}
+
+// a failed load is retried every LoadRetry, the reset is neither run nor
+// saved until the last reset is known
+func (s *Service) start(r *reset) {
+	last, err := s.Store.Load(r.key)
+	if err != nil {
+		log.Error("load reset %v error: %v", r.key, err)
+		r.t = s.sched.AfterFunc(s.LoadRetry, func() {
+			r.t = nil
+			if s.started {
+				s.start(r)
+			}
+		})
+		return
+	}
+
+	now := s.now()
+	if last.IsZero() {
+		r.last = r.period.Last(now)
+		s.save(r)
+	} else {
+		r.last = last
+		s.run(r, now)
+	}
+	s.schedule(r, now)
+}
+
+func (s *Service) Stop() {
+	for _, r := range s.resets {
+		if r.t != nil {
+			r.t.Stop()
+			r.t = nil
+		}
+	}
+	s.started = false
+}
+
+func (s *Service) save(r *reset) {
+	err := s.Store.Save(r.key, r.last)
+	if err != nil {
+		log.Error("save reset %v error: %v", r.key, err)
+	}
+}
+
+// the last reset is saved after f returns, a crash in between runs the
+// reset again on the next Start
+func (s *Service) run(r *reset, now time.Time) {
+	if !r.period.Due(r.last, now) {
+		return
+	}
+	r.last = r.period.Last(now)
+	r.f(r.last)
+	s.save(r)
+}
+
+func (s *Service) schedule(r *reset, now time.Time) {
+	r.t = s.sched.AfterFunc(r.period.Next(now).Sub(now), func() {
+		r.t = nil
+		// the timer may fire a bit early or late, Due checks the clock
+		now := s.now()
+		s.run(r, now)
+		if s.started {
+			s.schedule(r, now)
+		}
+	})
+}
+
+// the time of the last global reset of key, e.g. to show when the
+// rankings were reset
+func (s *Service) Last(key string) time.Time {
+	r := s.resets[key]
+	if r == nil {
+		return time.Time{}
+	}
+	if r.f == nil {
+		return r.period.Last(s.now())
+	}
+	return r.last
+}
+
+// the time of the next reset of key
+func (s *Service) Next(key string) time.Time {
+	r := s.resets[key]
+	if r == nil {
+		return time.Time{}
+	}
+	return r.period.Next(s.now())
+}
+
+// for the lazy per-player resets, e.g. at login, last is the time the
+// player was last reset, the player is reset if ok and must keep at as
+// its new last reset time
+func (s *Service) Pending(key string, last time.Time) (at time.Time, ok bool) {
+	r := s.resets[key]
+	if r == nil {
+		log.Error("reset %v not registered", key)
+		return time.Time{}, false
+	}
+	now := s.now()
+	if !r.period.Due(last, now) {
+		return last, false
+	}
+	return r.period.Last(now), true
+}
@@ -0,0 +1,93 @@
+package reset
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+)
+
+// the time of the last reset per key, a zero time if the key never reset
+type Store interface {
+	Load(key string) (time.Time, error)
+	Save(key string, t time.Time) error
+}
+
+// a Store in memory, for tests
+type MemStore struct {
+	mutex sync.Mutex
+	m     map[string]time.Time
+}
+
+func (s *MemStore) Load(key string) (time.Time, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.m[key], nil
+}
+
+func (s *MemStore) Save(key string, t time.Time) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.m == nil {
+		s.m = make(map[string]time.Time)
+	}
+	s.m[key] = t
+	return nil
+}
+
+// a Store in a json file, rewritten on each Save
+type FileStore struct {
+	Name  string
+	mutex sync.Mutex
+}
+
+func (s *FileStore) read() (map[string]time.Time, error) {
+	m := make(map[string]time.Time)
+	data, err := os.ReadFile(s.Name)
+	if os.IsNotExist(err) {
+		return m, nil
+	} else if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(data, &m)
+	if err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (s *FileStore) Load(key string) (time.Time, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	m, err := s.read()
+	if err != nil {
+		return time.Time{}, err
+	}
+	return m[key], nil
+}
+
+func (s *FileStore) Save(key string, t time.Time) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	m, err := s.read()
+	if err != nil {
+		return err
+	}
+	m[key] = t
+	data, err := json.MarshalIndent(m, "", "\t")
+	if err != nil {
+		return err
+	}
+
+	// a crash while writing keeps the previous file
+	tmp := s.Name + ".tmp"
+	err = os.WriteFile(tmp, data, 0644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.Name)
+}
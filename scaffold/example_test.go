@@ -0,0 +1,125 @@
+package scaffold_test
+
+import (
+	"fmt"
+	"github.com/name5566/leaf/scaffold"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+func Example() {
+	dir, err := os.MkdirTemp("", "leaf")
+	if err != nil {
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	p := &scaffold.Project{
+		Dir:       filepath.Join(dir, "server"),
+		Processor: scaffold.ProcessorJSON,
+	}
+	err = p.Generate()
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	for _, name := range p.Files() {
+		fmt.Println(name)
+	}
+
+	// Output:
+	// README.md
+	// base/skeleton.go
+	// bin/conf/server.json
+	// conf/conf.go
+	// conf/json.go
+	// game/external.go
+	// game/internal/chanrpc.go
+	// game/internal/handler.gen.go
+	// game/internal/handler_test.go
+	// game/internal/module.go
+	// game/internal/msghandler.go
+	// gate/external.go
+	// gate/internal/module.go
+	// gate/router.gen.go
+	// go.mod
+	// login/external.go
+	// login/internal/handler.gen.go
+	// login/internal/module.go
+	// login/internal/msghandler.go
+	// main.go
+	// msg/msg.gen.go
+	// msg/msg.idl
+}
+
+func goCmd(dir string, args ...string) (string, error) {
+	cmd := exec.Command("go", args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "GOFLAGS=-mod=mod", "GONOSUMDB=*", "GONOSUMCHECK=1", "GOSUMDB=off")
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("go %v: %v\n%s", strings.Join(args, " "), err, out)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// leaf gen run over a new project keeps it building
+func Example_gen() {
+	for _, processor := range []string{scaffold.ProcessorJSON, scaffold.ProcessorProtobuf} {
+		err := func() error {
+			dir, err := os.MkdirTemp("", "leaf")
+			if err != nil {
+				return err
+			}
+			defer os.RemoveAll(dir)
+
+			p := &scaffold.Project{
+				Dir:       filepath.Join(dir, "server"),
+				Processor: processor,
+			}
+			err = p.Generate()
+			if err != nil {
+				return err
+			}
+
+			// build with this copy of Leaf
+			leafDir, err := goCmd(".", "list", "-m", "-f", "{{.Dir}}")
+			if err != nil {
+				return err
+			}
+			f, err := os.OpenFile(filepath.Join(p.Dir, "go.mod"), os.O_APPEND|os.O_WRONLY, 0)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(f, "\nrequire github.com/name5566/leaf v0.0.0\n\nreplace github.com/name5566/leaf => %v\n", leafDir)
+			f.Close()
+
+			out, err := goCmd(".", "run", "github.com/name5566/leaf/cmd/leaf", "gen", "-o", p.Dir, filepath.Join(p.Dir, "msg", "msg.idl"))
+			if err != nil {
+				return err
+			}
+			fmt.Println(processor + ":")
+			fmt.Println(out)
+
+			_, err = goCmd(p.Dir, "vet", "./...")
+			return err
+		}()
+		if err != nil {
+			fmt.Println(err)
+		}
+	}
+
+	// Output:
+	// json:
+	// msg/msg.gen.go
+	// gate/router.gen.go
+	// game/internal/handler.gen.go
+	// login/internal/handler.gen.go
+	// protobuf:
+	// msg/msg.gen.go
+	// gate/router.gen.go
+	// game/internal/handler.gen.go
+	// login/internal/handler.gen.go
+}
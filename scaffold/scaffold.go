@@ -0,0 +1,181 @@
+package scaffold
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/name5566/leaf/idl"
+	"go/format"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"text/template"
+)
+
+const (
+	ProcessorJSON     = "json"
+	ProcessorProtobuf = "protobuf"
+)
+
+// a new Leaf server with the gate, login and game modules
+type Project struct {
+	Dir       string
+	Module    string // go module path, the base of Dir by default
+	Processor string // json (default) or protobuf
+	LeafPath  string // import path of Leaf
+	TCPAddr   string
+	WSAddr    string
+}
+
+func (p *Project) init() error {
+	if p.Dir == "" {
+		return errors.New("project directory required")
+	}
+	if p.Module == "" {
+		p.Module = filepath.Base(filepath.Clean(p.Dir))
+	}
+	if p.Processor == "" {
+		p.Processor = ProcessorJSON
+	}
+	if p.Processor != ProcessorJSON && p.Processor != ProcessorProtobuf {
+		return fmt.Errorf("unknown processor %v", p.Processor)
+	}
+	if p.LeafPath == "" {
+		p.LeafPath = "github.com/name5566/leaf"
+	}
+	if p.TCPAddr == "" {
+		p.TCPAddr = "127.0.0.1:3563"
+	}
+	if p.WSAddr == "" {
+		p.WSAddr = "127.0.0.1:3653"
+	}
+	return nil
+}
+
+// the package of the message types, the protobuf ones are in pb
+func (p *Project) MsgPackage() string {
+	if p.Processor == ProcessorProtobuf {
+		return "pb"
+	}
+	return "msg"
+}
+
+// the relative paths of the generated files, sorted
+func (p *Project) Files() []string {
+	names := make(map[string]bool)
+	for _, name := range p.templates() {
+		names[name] = true
+	}
+	// msg/msg.idl is always valid
+	outs, _ := p.gen()
+	for _, out := range outs {
+		names[out.Name] = true
+	}
+
+	var files []string
+	for name := range names {
+		files = append(files, name)
+	}
+	sort.Strings(files)
+	return files
+}
+
+func (p *Project) templates() []string {
+	var names []string
+	for name := range templates {
+		if protobufOnly[name] && p.Processor != ProcessorProtobuf {
+			continue
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// the output of leaf gen over msg/msg.idl, the messages, the routing and
+// the handler registration are generated so that leaf gen could be run
+// again once the messages are edited
+func (p *Project) gen() ([]*idl.Output, error) {
+	data, err := p.render("msg/msg.idl")
+	if err != nil {
+		return nil, err
+	}
+	file, err := idl.Parse(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("msg/msg.idl:%v", err)
+	}
+	g := &idl.Generator{LeafPath: p.LeafPath}
+	return g.Generate(file)
+}
+
+// Generate fails if Dir is not empty
+func (p *Project) Generate() error {
+	err := p.init()
+	if err != nil {
+		return err
+	}
+
+	entries, err := os.ReadDir(p.Dir)
+	if err == nil && len(entries) > 0 {
+		return fmt.Errorf("directory %v is not empty", p.Dir)
+	} else if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	files := make(map[string][]byte)
+	for _, name := range p.templates() {
+		data, err := p.render(name)
+		if err != nil {
+			return err
+		}
+		files[name] = data
+	}
+	outs, err := p.gen()
+	if err != nil {
+		return err
+	}
+	for _, out := range outs {
+		// the handler stubs are written by the templates
+		if out.Stub && files[out.Name] != nil {
+			continue
+		}
+		files[out.Name] = out.Data
+	}
+
+	for name, data := range files {
+		filename := filepath.Join(p.Dir, filepath.FromSlash(name))
+		err = os.MkdirAll(filepath.Dir(filename), 0755)
+		if err != nil {
+			return err
+		}
+		err = os.WriteFile(filename, data, 0644)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (p *Project) render(name string) ([]byte, error) {
+	t, err := template.New(name).Parse(templates[name])
+	if err != nil {
+		return nil, fmt.Errorf("template %v: %v", name, err)
+	}
+
+	var buf bytes.Buffer
+	err = t.Execute(&buf, p)
+	if err != nil {
+		return nil, fmt.Errorf("template %v: %v", name, err)
+	}
+	if path.Ext(name) != ".go" {
+		return buf.Bytes(), nil
+	}
+
+	data, err := format.Source(buf.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("template %v: %v", name, err)
+	}
+	return data, nil
+}
@@ -0,0 +1,444 @@
+package scaffold
+
+var protobufOnly = map[string]bool{
+	"pb/pb.go":     true,
+	"pb/msg.proto": true,
+}
+
+var templates = map[string]string{
+	"go.mod": `module {{.Module}}
+
+go 1.20
+`,
+
+	"README.md": `# {{.Module}}
+
+A Leaf server with three modules:
+
+* gate: accepts the connections, routes the messages
+* login: authenticates the users
+* game: the game logic
+
+Fetch the dependencies, then build and run from bin so that
+conf/server.json is found:
+
+    go mod tidy
+    go build -o bin/server .
+    cd bin && ./server
+
+Messages are defined in msg/msg.idl ({{.Processor}}). Once it is edited, run
+
+    leaf gen msg/msg.idl
+
+to regenerate the *.gen.go files, the handler stubs of the new messages are
+added to the msghandler.go files of the modules.
+`,
+
+	"main.go": `package main
+
+import (
+	"{{.LeafPath}}"
+	lconf "{{.LeafPath}}/conf"
+	"{{.Module}}/conf"
+	"{{.Module}}/game"
+	"{{.Module}}/gate"
+	"{{.Module}}/login"
+)
+
+func main() {
+	conf.Load("conf/server.json")
+
+	lconf.LogLevel = conf.Server.LogLevel
+	lconf.LogPath = conf.Server.LogPath
+	lconf.LogFlag = conf.LogFlag
+	lconf.ConsolePort = conf.Server.ConsolePort
+	lconf.ProfilePath = conf.Server.ProfilePath
+
+	leaf.Run(
+		game.Module,
+		gate.Module,
+		login.Module,
+	)
+}
+`,
+
+	"bin/conf/server.json": `{
+	"LogLevel": "debug",
+	"LogPath": "",
+	"TCPAddr": "{{.TCPAddr}}",
+	"WSAddr": "{{.WSAddr}}",
+	"MaxConnNum": 20000,
+	"ConsolePort": 0,
+	"ProfilePath": ""
+}
+`,
+
+	"conf/conf.go": `package conf
+
+import (
+	"log"
+	"time"
+)
+
+var (
+	// log conf
+	LogFlag = log.LstdFlags
+
+	// gate conf
+	PendingWriteNum        = 2000
+	MaxMsgLen       uint32 = 4096
+	HTTPTimeout            = 10 * time.Second
+	LenMsgLen              = 2
+	LittleEndian           = false
+
+	// skeleton conf
+	GoLen              = 10000
+	TimerDispatcherLen = 10000
+	AsynCallLen        = 10000
+	ChanRPCLen         = 10000
+)
+`,
+
+	"conf/json.go": `package conf
+
+import (
+	"encoding/json"
+	"{{.LeafPath}}/log"
+	"os"
+)
+
+var Server struct {
+	LogLevel    string
+	LogPath     string
+	WSAddr      string
+	CertFile    string
+	KeyFile     string
+	TCPAddr     string
+	MaxConnNum  int
+	ConsolePort int
+	ProfilePath string
+}
+
+func Load(filename string) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		log.Fatal("%v", err)
+	}
+	err = json.Unmarshal(data, &Server)
+	if err != nil {
+		log.Fatal("%v", err)
+	}
+}
+`,
+
+	"base/skeleton.go": `package base
+
+import (
+	"{{.LeafPath}}/chanrpc"
+	"{{.LeafPath}}/module"
+	"{{.Module}}/conf"
+)
+
+func NewSkeleton() *module.Skeleton {
+	skeleton := &module.Skeleton{
+		GoLen:              conf.GoLen,
+		TimerDispatcherLen: conf.TimerDispatcherLen,
+		AsynCallLen:        conf.AsynCallLen,
+		ChanRPCServer:      chanrpc.NewServer(conf.ChanRPCLen),
+	}
+	skeleton.Init()
+	return skeleton
+}
+`,
+
+	"msg/msg.idl": `// the messages of the server, run leaf gen msg/msg.idl once edited
+
+package msg "{{.Module}}/msg"
+processor {{.Processor}}
+module game "{{.Module}}/game"
+module login "{{.Module}}/login"
+{{- if eq .Processor "protobuf"}}
+import pb "{{.Module}}/pb"
+
+message Hello = 0 c2s game pb.Hello
+message Login = 1 c2s login pb.Login
+message LoginResult = 2 s2c pb.LoginResult
+{{- else}}
+
+message Hello = 0 c2s game {
+	Name string
+}
+
+message Login = 1 c2s login {
+	UserName string
+	Password string
+}
+
+message LoginResult = 2 s2c {
+	Ok     bool
+	Reason string
+}
+{{- end}}
+`,
+
+	"pb/pb.go": `package pb
+
+import (
+	"github.com/golang/protobuf/proto"
+)
+
+// the messages mirror msg.proto, replace them with the protoc-gen-go output
+
+type Hello struct {
+	Name string ` + "`" + `protobuf:"bytes,1,opt,name=name,proto3"` + "`" + `
+}
+
+func (m *Hello) Reset()         { *m = Hello{} }
+func (m *Hello) String() string { return proto.CompactTextString(m) }
+func (*Hello) ProtoMessage()    {}
+
+type Login struct {
+	UserName string ` + "`" + `protobuf:"bytes,1,opt,name=user_name,json=userName,proto3"` + "`" + `
+	Password string ` + "`" + `protobuf:"bytes,2,opt,name=password,proto3"` + "`" + `
+}
+
+func (m *Login) Reset()         { *m = Login{} }
+func (m *Login) String() string { return proto.CompactTextString(m) }
+func (*Login) ProtoMessage()    {}
+
+type LoginResult struct {
+	Ok     bool   ` + "`" + `protobuf:"varint,1,opt,name=ok,proto3"` + "`" + `
+	Reason string ` + "`" + `protobuf:"bytes,2,opt,name=reason,proto3"` + "`" + `
+}
+
+func (m *LoginResult) Reset()         { *m = LoginResult{} }
+func (m *LoginResult) String() string { return proto.CompactTextString(m) }
+func (*LoginResult) ProtoMessage()    {}
+`,
+
+	"pb/msg.proto": `syntax = "proto3";
+
+package pb;
+
+option go_package = "{{.Module}}/pb";
+
+message Hello {
+	string name = 1;
+}
+
+message Login {
+	string user_name = 1;
+	string password = 2;
+}
+
+message LoginResult {
+	bool ok = 1;
+	string reason = 2;
+}
+`,
+
+	"gate/external.go": `package gate
+
+import (
+	"{{.Module}}/gate/internal"
+)
+
+var Module = new(internal.Module)
+`,
+
+	"gate/internal/module.go": `package internal
+
+import (
+	"{{.LeafPath}}/gate"
+	"{{.Module}}/conf"
+	"{{.Module}}/game"
+	"{{.Module}}/msg"
+)
+
+type Module struct {
+	*gate.Gate
+}
+
+func (m *Module) OnInit() {
+	m.Gate = &gate.Gate{
+		MaxConnNum:      conf.Server.MaxConnNum,
+		PendingWriteNum: conf.PendingWriteNum,
+		MaxMsgLen:       conf.MaxMsgLen,
+		WSAddr:          conf.Server.WSAddr,
+		HTTPTimeout:     conf.HTTPTimeout,
+		CertFile:        conf.Server.CertFile,
+		KeyFile:         conf.Server.KeyFile,
+		TCPAddr:         conf.Server.TCPAddr,
+		LenMsgLen:       conf.LenMsgLen,
+		LittleEndian:    conf.LittleEndian,
+		Processor:       msg.Processor,
+		AgentChanRPC:    game.ChanRPC,
+	}
+	m.Gate.RegisterCommands()
+}
+`,
+
+	"login/external.go": `package login
+
+import (
+	"{{.Module}}/login/internal"
+)
+
+var (
+	Module  = new(internal.Module)
+	ChanRPC = internal.ChanRPC
+)
+`,
+
+	"login/internal/module.go": `package internal
+
+import (
+	"{{.LeafPath}}/module"
+	"{{.Module}}/base"
+)
+
+var (
+	skeleton = base.NewSkeleton()
+	ChanRPC  = skeleton.ChanRPCServer
+)
+
+type Module struct {
+	*module.Skeleton
+}
+
+func (m *Module) OnInit() {
+	m.Skeleton = skeleton
+}
+
+func (m *Module) OnDestroy() {
+
+}
+`,
+
+	"login/internal/msghandler.go": `package internal
+
+import (
+	"{{.LeafPath}}/gate"
+	"{{.Module}}/{{.MsgPackage}}"
+)
+
+func handleLogin(m *{{.MsgPackage}}.Login, a gate.Agent) {
+	// TODO: check the password
+	if m.UserName == "" {
+		a.WriteMsg(&{{.MsgPackage}}.LoginResult{Reason: "user name required"})
+		return
+	}
+
+	a.Attrs().Set(gate.AttrUserID, m.UserName)
+	a.WriteMsg(&{{.MsgPackage}}.LoginResult{Ok: true})
+}
+`,
+
+	"game/external.go": `package game
+
+import (
+	"{{.Module}}/game/internal"
+)
+
+var (
+	Module  = new(internal.Module)
+	ChanRPC = internal.ChanRPC
+)
+`,
+
+	"game/internal/module.go": `package internal
+
+import (
+	"{{.LeafPath}}/module"
+	"{{.Module}}/base"
+)
+
+var (
+	skeleton = base.NewSkeleton()
+	ChanRPC  = skeleton.ChanRPCServer
+)
+
+type Module struct {
+	*module.Skeleton
+}
+
+func (m *Module) OnInit() {
+	m.Skeleton = skeleton
+}
+
+func (m *Module) OnDestroy() {
+
+}
+`,
+
+	"game/internal/chanrpc.go": `package internal
+
+import (
+	"{{.LeafPath}}/gate"
+	"{{.LeafPath}}/log"
+)
+
+func init() {
+	skeleton.RegisterChanRPC("NewAgent", rpcNewAgent)
+	skeleton.RegisterChanRPC("CloseAgent", rpcCloseAgent)
+}
+
+func rpcNewAgent(args []interface{}) {
+	a := args[0].(gate.Agent)
+	log.Debug("new agent %v from %v", a.ID(), a.RemoteAddr())
+}
+
+func rpcCloseAgent(args []interface{}) {
+	a := args[0].(gate.Agent)
+	log.Debug("close agent %v", a.ID())
+}
+`,
+
+	"game/internal/msghandler.go": `package internal
+
+import (
+	"{{.LeafPath}}/gate"
+	"{{.LeafPath}}/log"
+	"{{.Module}}/{{.MsgPackage}}"
+)
+
+func handleHello(m *{{.MsgPackage}}.Hello, a gate.Agent) {
+	log.Debug("hello %v", m.Name)
+
+	a.WriteMsg(&{{.MsgPackage}}.Hello{
+		Name: "client",
+	})
+}
+`,
+
+	"game/internal/handler_test.go": `package internal
+
+import (
+	"{{.LeafPath}}/gate"
+	"{{.Module}}/{{.MsgPackage}}"
+	"testing"
+)
+
+// records the messages written by the handlers
+type testAgent struct {
+	gate.Agent
+	msgs []interface{}
+}
+
+func (a *testAgent) WriteMsg(msg interface{}) {
+	a.msgs = append(a.msgs, msg)
+}
+
+func TestHello(t *testing.T) {
+	a := new(testAgent)
+	handleHello(&{{.MsgPackage}}.Hello{Name: "leaf"}, a)
+
+	if len(a.msgs) != 1 {
+		t.Fatalf("got %v messages, want 1", len(a.msgs))
+	}
+	if m, ok := a.msgs[0].(*{{.MsgPackage}}.Hello); !ok || m.Name != "client" {
+		t.Fatalf("unexpected reply %v", a.msgs[0])
+	}
+}
+`,
+}
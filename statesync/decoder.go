@@ -0,0 +1,159 @@
+package statesync
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// the client side of a Syncer, for bots and Go clients
+// one decoder per goroutine (goroutine not safe)
+type Decoder struct {
+	// snapshots kept as the baselines of the next deltas
+	History int
+
+	schema *Schema
+	worlds map[uint32]world
+	ticks  []uint32
+	latest uint32
+}
+
+func NewDecoder(schema *Schema) *Decoder {
+	d := new(Decoder)
+	d.History = 32
+	d.schema = schema
+	d.worlds = make(map[uint32]world)
+	return d
+}
+
+func readUvarint(data []byte) (uint64, []byte, error) {
+	v, n := binary.Uvarint(data)
+	if n <= 0 {
+		return 0, nil, errShort
+	}
+	return v, data[n:], nil
+}
+
+// returns the tick to ack
+func (d *Decoder) Decode(data []byte) (uint32, error) {
+	tick, data, err := readUvarint(data)
+	if err != nil {
+		return 0, err
+	}
+	base, data, err := readUvarint(data)
+	if err != nil {
+		return 0, err
+	}
+	n, data, err := readUvarint(data)
+	if err != nil {
+		return 0, err
+	}
+	if uint32(tick) <= d.latest {
+		return d.latest, errors.New("stale snapshot")
+	}
+
+	var baseWorld world
+	if base != 0 {
+		baseWorld = d.worlds[uint32(base)]
+		if baseWorld == nil {
+			return 0, fmt.Errorf("baseline %v not found", base)
+		}
+	}
+	w := make(world, len(baseWorld))
+	for id, e := range baseWorld {
+		w[id] = e
+	}
+
+	for i := uint64(0); i < n; i++ {
+		var id uint64
+		id, data, err = readUvarint(data)
+		if err != nil {
+			return 0, err
+		}
+		if len(data) < 1 {
+			return 0, errShort
+		}
+		op := data[0]
+		data = data[1:]
+
+		switch op {
+		case opFull:
+			var typ uint64
+			typ, data, err = readUvarint(data)
+			if err != nil {
+				return 0, err
+			}
+			if typ >= uint64(len(d.schema.types)) {
+				return 0, fmt.Errorf("state type %v not registered", typ)
+			}
+			e := &entity{typ: int(typ)}
+			ti := d.schema.types[typ]
+			e.fields = make([][]byte, len(ti.fields))
+			for j, fi := range ti.fields {
+				var l int
+				l, err = readField(data, fi, noValue)
+				if err != nil {
+					return 0, err
+				}
+				e.fields[j], data = data[:l], data[l:]
+			}
+			w[uint32(id)] = e
+		case opDelta:
+			var mask uint64
+			mask, data, err = readUvarint(data)
+			if err != nil {
+				return 0, err
+			}
+			old := w[uint32(id)]
+			if old == nil {
+				return 0, fmt.Errorf("entity %v not found", id)
+			}
+			e := &entity{typ: old.typ, fields: make([][]byte, len(old.fields))}
+			copy(e.fields, old.fields)
+			ti := d.schema.types[old.typ]
+			for j, fi := range ti.fields {
+				if mask&(1<<uint(j)) == 0 {
+					continue
+				}
+				var l int
+				l, err = readField(data, fi, noValue)
+				if err != nil {
+					return 0, err
+				}
+				e.fields[j], data = data[:l], data[l:]
+			}
+			w[uint32(id)] = e
+		case opRemove:
+			delete(w, uint32(id))
+		default:
+			return 0, fmt.Errorf("invalid op %v", op)
+		}
+	}
+
+	if len(d.ticks) >= d.History {
+		delete(d.worlds, d.ticks[0])
+		d.ticks = d.ticks[1:]
+	}
+	d.worlds[uint32(tick)] = w
+	d.ticks = append(d.ticks, uint32(tick))
+	d.latest = uint32(tick)
+	return d.latest, nil
+}
+
+// the state of an entity in the latest snapshot, nil if not found
+func (d *Decoder) State(id uint32) (interface{}, error) {
+	e := d.worlds[d.latest][id]
+	if e == nil {
+		return nil, nil
+	}
+	return d.schema.decode(e.typ, e.fields)
+}
+
+// the ids of the entities in the latest snapshot
+func (d *Decoder) Entities() []uint32 {
+	var ids []uint32
+	for id := range d.worlds[d.latest] {
+		ids = append(ids, id)
+	}
+	return ids
+}
@@ -0,0 +1,279 @@
+package statesync_test
+
+import (
+	"fmt"
+	"github.com/name5566/leaf/chanrpc"
+	"github.com/name5566/leaf/module"
+	"github.com/name5566/leaf/statesync"
+	"math"
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+type Player struct {
+	Name string
+	HP   int
+	X    float32 `quant:"-1000,1000,20"`
+	Y    float32 `quant:"-1000,1000,20"`
+}
+
+func Example() {
+	schema := statesync.NewSchema()
+	if err := schema.Register(Player{}); err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	s := statesync.NewSyncer(schema)
+	d := statesync.NewDecoder(schema)
+	s.AddClient("client")
+
+	send := func(c interface{}, data []byte) {
+		fmt.Println(len(data))
+		tick, err := d.Decode(data)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		s.Ack(c, tick)
+	}
+
+	// full
+	p := &Player{Name: "Leaf", HP: 100, X: 1.5, Y: -2}
+	s.Set(1, p)
+	s.Flush(send)
+
+	// delta
+	p.HP = 90
+	s.Set(1, p)
+	s.Flush(send)
+
+	st, _ := d.State(1)
+	p = st.(*Player)
+	fmt.Printf("%v %v %.2f %.2f\n", p.Name, p.HP, p.X, p.Y)
+
+	// Output:
+	// 19
+	// 8
+	// Leaf 90 1.50 -2.00
+}
+
+func newSchema() *statesync.Schema {
+	schema := statesync.NewSchema()
+	schema.Register(Player{})
+	return schema
+}
+
+func entities(d *statesync.Decoder) []uint32 {
+	ids := d.Entities()
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+func ExampleSyncer_Remove() {
+	schema := newSchema()
+	s := statesync.NewSyncer(schema)
+	d := statesync.NewDecoder(schema)
+	s.AddClient("client")
+	send := func(c interface{}, data []byte) {
+		tick, err := d.Decode(data)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		s.Ack(c, tick)
+	}
+
+	s.Set(1, &Player{Name: "a"})
+	s.Set(2, &Player{Name: "b"})
+	s.Flush(send)
+	fmt.Println(entities(d))
+
+	s.Remove(2)
+	s.Flush(send)
+	fmt.Println(entities(d))
+
+	// Output:
+	// [1 2]
+	// [1]
+}
+
+func ExampleSyncer_Interest() {
+	schema := newSchema()
+	s := statesync.NewSyncer(schema)
+	decoders := map[string]*statesync.Decoder{
+		"near": statesync.NewDecoder(schema),
+		"far":  statesync.NewDecoder(schema),
+	}
+	s.AddClient("near")
+	s.AddClient("far")
+	s.Interest = func(c interface{}, id uint32) bool {
+		return c == "near" || id >= 10
+	}
+	send := func(c interface{}, data []byte) {
+		tick, err := decoders[c.(string)].Decode(data)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		s.Ack(c, tick)
+	}
+
+	s.Set(1, &Player{Name: "a"})
+	s.Set(10, &Player{Name: "b"})
+	s.Flush(send)
+	fmt.Println(entities(decoders["near"]), entities(decoders["far"]))
+
+	// out of interest is removed from the client
+	s.Interest = func(c interface{}, id uint32) bool {
+		return c == "near"
+	}
+	s.Flush(send)
+	fmt.Println(entities(decoders["near"]), entities(decoders["far"]))
+
+	// Output:
+	// [1 10] [10]
+	// [1 10] []
+}
+
+func ExampleSyncer_Ack() {
+	schema := newSchema()
+	s := statesync.NewSyncer(schema)
+	s.History = 4
+	d := statesync.NewDecoder(schema)
+	s.AddClient("client")
+
+	lost := false
+	send := func(c interface{}, data []byte) {
+		if lost {
+			return
+		}
+		tick, err := d.Decode(data)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		s.Ack(c, tick)
+	}
+
+	p := &Player{Name: "a", HP: 100}
+	s.Set(1, p)
+	s.Flush(send)
+
+	// the deltas stay based on the last tick acked
+	lost = true
+	p.HP = 90
+	s.Set(1, p)
+	s.Flush(send)
+	lost = false
+	p.HP = 80
+	s.Set(1, p)
+	s.Flush(send)
+	st, _ := d.State(1)
+	fmt.Println(st.(*Player).HP)
+
+	// once the tick acked is beyond History, a full snapshot is sent
+	lost = true
+	for i := 0; i < 5; i++ {
+		s.Flush(send)
+	}
+	lost = false
+	d = statesync.NewDecoder(schema)
+	p.HP = 70
+	s.Set(1, p)
+	s.Flush(send)
+	st, _ = d.State(1)
+	fmt.Println(st.(*Player).HP)
+
+	// Output:
+	// 80
+	// 70
+}
+
+func ExampleSyncer_MaxSize() {
+	schema := newSchema()
+	s := statesync.NewSyncer(schema)
+	s.MaxSize = 45
+	d := statesync.NewDecoder(schema)
+	s.AddClient("client")
+	send := func(c interface{}, data []byte) {
+		fmt.Println(len(data) <= s.MaxSize)
+		tick, err := d.Decode(data)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		s.Ack(c, tick)
+	}
+
+	// two players fit a snapshot, the others are sent in the next ticks
+	for i := uint32(1); i <= 5; i++ {
+		s.Set(i, &Player{Name: fmt.Sprint("p", i), HP: 100, X: 1, Y: 1})
+	}
+	for i := 0; i < 3; i++ {
+		s.Flush(send)
+		fmt.Println(len(d.Entities()))
+	}
+
+	// Output:
+	// true
+	// 2
+	// true
+	// 4
+	// true
+	// 5
+}
+
+func ExampleSyncer_Start() {
+	s := &module.Skeleton{
+		TimerDispatcherLen: 10,
+		ChanRPCServer:      chanrpc.NewServer(10),
+	}
+	s.Init()
+	closeSig := make(chan bool, 1)
+	done := make(chan struct{})
+	go func() {
+		s.Run(closeSig)
+		close(done)
+	}()
+
+	syncer := statesync.NewSyncer(newSchema())
+	syncer.AddClient("client")
+	var sent int32
+	stops := make(chan func(), 1)
+	s.RegisterChanRPC("start", func(args []interface{}) {
+		stops <- syncer.Start(s, time.Millisecond, func(c interface{}, data []byte) {
+			atomic.AddInt32(&sent, 1)
+		})
+	})
+	s.ChanRPCServer.Go("start")
+	stop := <-stops
+	for atomic.LoadInt32(&sent) < 3 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// off the skeleton goroutine
+	stop()
+	time.Sleep(20 * time.Millisecond)
+	n := atomic.LoadInt32(&sent)
+	time.Sleep(20 * time.Millisecond)
+	fmt.Println(atomic.LoadInt32(&sent) == n)
+
+	closeSig <- true
+	<-done
+
+	// Output:
+	// true
+}
+
+func ExampleQuantize() {
+	fmt.Println(statesync.Quantize(0, -1, 1, 8), statesync.Quantize(2, -1, 1, 8))
+	fmt.Println(statesync.Quantize(math.Inf(1), -1, 1, 8), statesync.Quantize(math.Inf(-1), -1, 1, 8))
+	fmt.Println(statesync.Quantize(math.NaN(), -1, 1, 8))
+
+	// Output:
+	// 128 255
+	// 255 0
+	// 0
+}
@@ -0,0 +1,273 @@
+package statesync
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// maps [min, max] to an integer of bits bits, the values out of range
+// (infinities too) are clamped, NaN is mapped to min
+func Quantize(v, min, max float64, bits uint) uint64 {
+	if math.IsNaN(v) || v <= min {
+		return 0
+	}
+	steps := uint64(1)<<bits - 1
+	if v >= max {
+		return steps
+	}
+	return uint64(math.Floor((v-min)/(max-min)*float64(steps) + 0.5))
+}
+
+func Dequantize(q uint64, min, max float64, bits uint) float64 {
+	steps := uint64(1)<<bits - 1
+	if q >= steps {
+		return max
+	}
+	return min + float64(q)/float64(steps)*(max-min)
+}
+
+type quant struct {
+	min  float64
+	max  float64
+	bits uint
+}
+
+type fieldInfo struct {
+	index int
+	kind  reflect.Kind
+	quant *quant
+}
+
+type typeInfo struct {
+	typ    reflect.Type
+	fields []fieldInfo
+}
+
+// the list of state types, the server and the clients must register the
+// same types in the same order
+//
+// every exported field of a state is synced, float fields tagged with
+// `quant:"min,max,bits"` are quantized
+type Schema struct {
+	types     []*typeInfo
+	typeIndex map[reflect.Type]int
+}
+
+func NewSchema() *Schema {
+	s := new(Schema)
+	s.typeIndex = make(map[reflect.Type]int)
+	return s
+}
+
+// st is a struct or a pointer to a struct
+func (s *Schema) Register(st interface{}) error {
+	typ := reflect.TypeOf(st)
+	if typ != nil && typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	if typ == nil || typ.Kind() != reflect.Struct {
+		return errors.New("st must be a struct")
+	}
+	if _, ok := s.typeIndex[typ]; ok {
+		return fmt.Errorf("state %v is already registered", typ)
+	}
+
+	ti := &typeInfo{typ: typ}
+	for i := 0; i < typ.NumField(); i++ {
+		f := typ.Field(i)
+		if f.PkgPath != "" {
+			continue
+		}
+
+		fi := fieldInfo{index: i, kind: f.Type.Kind()}
+		switch fi.kind {
+		case reflect.Bool, reflect.String,
+			reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+			reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		case reflect.Float32, reflect.Float64:
+			if tag := f.Tag.Get("quant"); tag != "" {
+				q, err := parseQuant(tag)
+				if err != nil {
+					return fmt.Errorf("invalid quant tag of %v.%v: %v", typ, f.Name, err)
+				}
+				fi.quant = q
+			}
+		default:
+			return fmt.Errorf("invalid type: %v %s", f.Name, fi.kind)
+		}
+		ti.fields = append(ti.fields, fi)
+	}
+	if len(ti.fields) > 64 {
+		return fmt.Errorf("too many fields in %v (max = 64)", typ)
+	}
+
+	s.typeIndex[typ] = len(s.types)
+	s.types = append(s.types, ti)
+	return nil
+}
+
+func parseQuant(tag string) (*quant, error) {
+	s := strings.Split(tag, ",")
+	if len(s) != 3 {
+		return nil, errors.New("min,max,bits required")
+	}
+	min, err := strconv.ParseFloat(s[0], 64)
+	if err != nil {
+		return nil, err
+	}
+	max, err := strconv.ParseFloat(s[1], 64)
+	if err != nil {
+		return nil, err
+	}
+	bits, err := strconv.ParseUint(s[2], 10, 8)
+	if err != nil {
+		return nil, err
+	}
+	if min >= max || bits == 0 || bits > 32 {
+		return nil, errors.New("invalid range")
+	}
+	return &quant{min, max, uint(bits)}, nil
+}
+
+// encodes every field of st
+func (s *Schema) encode(st interface{}) (int, [][]byte, error) {
+	v := reflect.ValueOf(st)
+	if v.Kind() == reflect.Ptr {
+		v = v.Elem()
+	}
+	typ, ok := s.typeIndex[v.Type()]
+	if !ok {
+		return 0, nil, fmt.Errorf("state %v not registered", v.Type())
+	}
+
+	ti := s.types[typ]
+	fields := make([][]byte, len(ti.fields))
+	for i, fi := range ti.fields {
+		fields[i] = appendField(nil, fi, v.Field(fi.index))
+	}
+	return typ, fields, nil
+}
+
+func (s *Schema) decode(typ int, fields [][]byte) (interface{}, error) {
+	if typ >= len(s.types) {
+		return nil, fmt.Errorf("state type %v not registered", typ)
+	}
+
+	ti := s.types[typ]
+	v := reflect.New(ti.typ)
+	for i, fi := range ti.fields {
+		if _, err := readField(fields[i], fi, v.Elem().Field(fi.index)); err != nil {
+			return nil, err
+		}
+	}
+	return v.Interface(), nil
+}
+
+func appendField(b []byte, fi fieldInfo, v reflect.Value) []byte {
+	switch fi.kind {
+	case reflect.Bool:
+		if v.Bool() {
+			return append(b, 1)
+		}
+		return append(b, 0)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return binary.AppendVarint(b, v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return binary.AppendUvarint(b, v.Uint())
+	case reflect.Float32:
+		if fi.quant != nil {
+			return binary.AppendUvarint(b, Quantize(v.Float(), fi.quant.min, fi.quant.max, fi.quant.bits))
+		}
+		return binary.LittleEndian.AppendUint32(b, math.Float32bits(float32(v.Float())))
+	case reflect.Float64:
+		if fi.quant != nil {
+			return binary.AppendUvarint(b, Quantize(v.Float(), fi.quant.min, fi.quant.max, fi.quant.bits))
+		}
+		return binary.LittleEndian.AppendUint64(b, math.Float64bits(v.Float()))
+	case reflect.String:
+		b = binary.AppendUvarint(b, uint64(v.Len()))
+		return append(b, v.String()...)
+	}
+
+	panic("bug")
+}
+
+var errShort = errors.New("state data too short")
+
+// returns the length of the field, v is not set if it is invalid
+func readField(b []byte, fi fieldInfo, v reflect.Value) (int, error) {
+	switch fi.kind {
+	case reflect.Bool:
+		if len(b) < 1 {
+			return 0, errShort
+		}
+		if v.IsValid() {
+			v.SetBool(b[0] != 0)
+		}
+		return 1, nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, n := binary.Varint(b)
+		if n <= 0 {
+			return 0, errShort
+		}
+		if v.IsValid() {
+			v.SetInt(i)
+		}
+		return n, nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		u, n := binary.Uvarint(b)
+		if n <= 0 {
+			return 0, errShort
+		}
+		if v.IsValid() {
+			v.SetUint(u)
+		}
+		return n, nil
+	case reflect.Float32, reflect.Float64:
+		if fi.quant != nil {
+			q, n := binary.Uvarint(b)
+			if n <= 0 {
+				return 0, errShort
+			}
+			if v.IsValid() {
+				v.SetFloat(Dequantize(q, fi.quant.min, fi.quant.max, fi.quant.bits))
+			}
+			return n, nil
+		}
+		if fi.kind == reflect.Float32 {
+			if len(b) < 4 {
+				return 0, errShort
+			}
+			if v.IsValid() {
+				v.SetFloat(float64(math.Float32frombits(binary.LittleEndian.Uint32(b))))
+			}
+			return 4, nil
+		}
+		if len(b) < 8 {
+			return 0, errShort
+		}
+		if v.IsValid() {
+			v.SetFloat(math.Float64frombits(binary.LittleEndian.Uint64(b)))
+		}
+		return 8, nil
+	case reflect.String:
+		l, n := binary.Uvarint(b)
+		if n <= 0 || uint64(len(b)-n) < l {
+			return 0, errShort
+		}
+		if v.IsValid() {
+			v.SetString(string(b[n : n+int(l)]))
+		}
+		return n + int(l), nil
+	}
+
+	panic("bug")
+}
+
+// readField only checks the data
+var noValue reflect.Value
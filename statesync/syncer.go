@@ -0,0 +1,236 @@
+package statesync
+
+import (
+	"bytes"
+	"encoding/binary"
+	"github.com/name5566/leaf/log"
+	"github.com/name5566/leaf/module"
+	"sync/atomic"
+	"time"
+)
+
+// ------------------------------------------
+// | tick | base tick | n | entity | entity |
+// ------------------------------------------
+// base tick 0 means no baseline, the others are the ticks acked by the client
+//
+// entity:
+// | id | opFull   | type | fields         |
+// | id | opDelta  | mask | changed fields |
+// | id | opRemove |
+const (
+	opFull   = 0
+	opDelta  = 1
+	opRemove = 2
+)
+
+type entity struct {
+	typ    int
+	fields [][]byte
+}
+
+type world map[uint32]*entity
+
+type client struct {
+	snapshots map[uint32]world
+	ticks     []uint32
+	acked     uint32
+}
+
+// one syncer per goroutine (goroutine not safe)
+type Syncer struct {
+	// snapshots kept per client waiting for the acks
+	History int
+	// the max bytes of a snapshot, usually the MaxMsgLen of the datagrams,
+	// the entities beyond it are sent in the next ticks, 0 for no limit
+	MaxSize int
+	// optional, whether an entity is synced to a client
+	Interest func(c interface{}, id uint32) bool
+
+	schema   *Schema
+	entities world
+	clients  map[interface{}]*client
+	tick     uint32
+}
+
+func NewSyncer(schema *Schema) *Syncer {
+	s := new(Syncer)
+	s.History = 32
+	s.MaxSize = 1024
+	s.schema = schema
+	s.entities = make(world)
+	s.clients = make(map[interface{}]*client)
+	return s
+}
+
+// st is encoded immediately, it could be modified after the call
+func (s *Syncer) Set(id uint32, st interface{}) error {
+	typ, fields, err := s.schema.encode(st)
+	if err != nil {
+		return err
+	}
+
+	s.entities[id] = &entity{typ, fields}
+	return nil
+}
+
+func (s *Syncer) Remove(id uint32) {
+	delete(s.entities, id)
+}
+
+// c is usually a gate.Agent
+func (s *Syncer) AddClient(c interface{}) {
+	if _, ok := s.clients[c]; ok {
+		return
+	}
+	s.clients[c] = &client{snapshots: make(map[uint32]world)}
+}
+
+func (s *Syncer) RemoveClient(c interface{}) {
+	delete(s.clients, c)
+}
+
+// the client acks the latest tick it decoded
+func (s *Syncer) Ack(c interface{}, tick uint32) {
+	cl, ok := s.clients[c]
+	if !ok {
+		return
+	}
+	if _, ok := cl.snapshots[tick]; !ok || tick <= cl.acked {
+		return
+	}
+
+	cl.acked = tick
+	for len(cl.ticks) > 0 && cl.ticks[0] < tick {
+		delete(cl.snapshots, cl.ticks[0])
+		cl.ticks = cl.ticks[1:]
+	}
+}
+
+func (s *Syncer) Tick() uint32 {
+	return s.tick
+}
+
+// advances the tick and sends the deltas to every client
+// send usually calls gate.Agent.WriteDatagram
+func (s *Syncer) Flush(send func(c interface{}, data []byte)) {
+	s.tick++
+	for c := range s.clients {
+		send(c, s.delta(c))
+	}
+}
+
+// calls Flush every d on the skeleton goroutine until stop is called
+// stop is goroutine safe
+func (s *Syncer) Start(skeleton *module.Skeleton, d time.Duration, send func(c interface{}, data []byte)) (stop func()) {
+	var stopped int32
+	var loop func()
+	loop = func() {
+		if atomic.LoadInt32(&stopped) != 0 {
+			return
+		}
+		s.Flush(send)
+		skeleton.AfterFunc(d, loop)
+	}
+	skeleton.AfterFunc(d, loop)
+
+	return func() {
+		atomic.StoreInt32(&stopped, 1)
+	}
+}
+
+func (s *Syncer) delta(c interface{}) []byte {
+	cl := s.clients[c]
+
+	// baseline
+	base := cl.acked
+	baseWorld := cl.snapshots[base]
+	if baseWorld == nil {
+		base = 0
+	}
+
+	// snapshot
+	w := make(world, len(s.entities))
+	for id, e := range s.entities {
+		if s.Interest == nil || s.Interest(c, id) {
+			w[id] = e
+		}
+	}
+	if len(cl.ticks) >= s.History {
+		delete(cl.snapshots, cl.ticks[0])
+		cl.ticks = cl.ticks[1:]
+		if _, ok := cl.snapshots[cl.acked]; !ok {
+			cl.acked = 0
+		}
+	}
+	cl.snapshots[s.tick] = w
+	cl.ticks = append(cl.ticks, s.tick)
+
+	// encode, the snapshot kept is the world of the client once decoded
+	var body []byte
+	n := 0
+	head := 3 * binary.MaxVarintLen32
+	add := func(op []byte, id uint32) {
+		if s.MaxSize <= 0 || head+len(body)+len(op) <= s.MaxSize {
+			body = append(body, op...)
+			n++
+			return
+		}
+		if head+len(op) > s.MaxSize {
+			log.Error("state %v of %v bytes never fits a snapshot of %v bytes", id, len(op), s.MaxSize)
+		}
+		if old := baseWorld[id]; old != nil {
+			w[id] = old
+		} else {
+			delete(w, id)
+		}
+	}
+	for id, e := range w {
+		old := baseWorld[id]
+		if old == e {
+			continue
+		}
+
+		if old == nil || old.typ != e.typ {
+			op := binary.AppendUvarint(nil, uint64(id))
+			op = append(op, opFull)
+			op = binary.AppendUvarint(op, uint64(e.typ))
+			for _, f := range e.fields {
+				op = append(op, f...)
+			}
+			add(op, id)
+			continue
+		}
+
+		var mask uint64
+		for i, f := range e.fields {
+			if !bytes.Equal(f, old.fields[i]) {
+				mask |= 1 << uint(i)
+			}
+		}
+		if mask == 0 {
+			continue
+		}
+		op := binary.AppendUvarint(nil, uint64(id))
+		op = append(op, opDelta)
+		op = binary.AppendUvarint(op, mask)
+		for i, f := range e.fields {
+			if mask&(1<<uint(i)) != 0 {
+				op = append(op, f...)
+			}
+		}
+		add(op, id)
+	}
+	for id := range baseWorld {
+		if _, ok := w[id]; !ok {
+			op := binary.AppendUvarint(nil, uint64(id))
+			op = append(op, opRemove)
+			add(op, id)
+		}
+	}
+
+	data := binary.AppendUvarint(nil, uint64(s.tick))
+	data = binary.AppendUvarint(data, uint64(base))
+	data = binary.AppendUvarint(data, uint64(n))
+	return append(data, body...)
+}